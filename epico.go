@@ -1,7 +1,9 @@
 package epico
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/SREnity/epico/dashboard_reporter"
 	"io/ioutil"
@@ -10,7 +12,7 @@ import (
 	"os"
 	"plugin"
 	"reflect"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +20,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 	"gopkg.in/yaml.v2"
 
+	"github.com/SREnity/epico/secrets"
 	generic_structs "github.com/SREnity/epico/structs"
 	"github.com/SREnity/epico/utils"
 )
@@ -58,6 +61,92 @@ import (
 //                   to true will be used, others skipped
 // TODO: Should this be passed as a JSON []byte/string we can just marshal?
 func PullApiData(configLocation string, authParams []string, peekParams []string, postParams []string, additionalParams map[string]map[string]map[string]string, connectionOnly bool, apiKey string, apiSecret string, pluginID int) []byte {
+	responseList, jsonKeys, PluginPostProcessFunction, ok := pullApiResponses(configLocation, authParams, peekParams, additionalParams, connectionOnly, apiKey, apiSecret, pluginID, false)
+	if !ok {
+		return []byte(nil)
+	}
+
+	checkResult := make(map[string]string)
+	checkResult["Errors"] = "Invalid Credentials"
+	errorJson, _ := json.Marshal(checkResult)
+	if len(responseList) == 0 {
+		return errorJson
+	}
+
+	if connectionOnly {
+		respCodeSuccess := false
+		finalListElement := make(map[generic_structs.ComparableApiRequest][]byte)
+
+		for k := range responseList {
+			if k.ResponseCode >= 200 && k.ResponseCode <= 299 {
+				respCodeSuccess = true
+				finalListElement[k] = responseList[k]
+			}
+		}
+		if !respCodeSuccess {
+			for k := range responseList {
+				response := string(responseList[k])
+				if response == "[]" || strings.Contains(response,"</html>") || len(response)==0{
+					return errorJson
+				}
+				//responses neither xml nor json
+				if !strings.Contains(response,"{") || !strings.Contains(response,"<"){
+					checkResult["Errors"] = response
+					errorJson, _ := json.Marshal(checkResult)
+					return errorJson
+				}else {
+					finalListElement[k] = responseList[k]
+					break
+				}
+			}
+		}
+		responseList = finalListElement
+	}
+
+	// Theoretically we could send each response to its own post-processing,
+	//    but that kind of breaks the idea that we would return everything from
+	//    a single external call as a single JSON blob.  So instead, we're just
+	//    going to use the one provided in a general configuration file.
+	var finalResponseValueList []reflect.Value
+	finalResponseValueList = append(finalResponseValueList,
+		reflect.ValueOf(responseList), reflect.ValueOf(jsonKeys),
+		reflect.ValueOf(postParams))
+	finalResponse := reflect.ValueOf(**PluginPostProcessFunction).Call(
+		finalResponseValueList)
+
+	return finalResponse[0].Bytes()
+}
+
+// Runs a pull as PullApiData does, but returns the data, the errors reported
+//    by the APIs and the requests that failed separately, instead of what
+//    the plugin's post process makes of them.  Responses are converted with
+//    the plugin's ResponseToJson function and compiled by
+//    utils.DefaultJsonPostProcessResult.  Returns nil if the pull couldn't
+//    be run, e.g. an invalid config.
+// Args:
+// configLocation   = The folder where config YAMLs can be found for the plugin
+//                    that is being used.
+// authParams       = Plugin-specific auth parameters passed to the plugin being
+//                    used.
+// peekParams       = Plugin-specific peek parameters passed to the plugin being
+//                    used.
+// additionalParams = API-specific parameters for body, header, or querystring,
+//                    as for PullApiData.
+func PullApiResult(configLocation string, authParams []string, peekParams []string, additionalParams map[string]map[string]map[string]string, apiKey string, apiSecret string, pluginID int) *utils.PullResult {
+	responseList, jsonKeys, _, ok := pullApiResponses(configLocation, authParams, peekParams, additionalParams, false, apiKey, apiSecret, pluginID, true)
+	if !ok {
+		return nil
+	}
+
+	result := utils.DefaultJsonPostProcessResult(responseList, jsonKeys)
+	return &result
+}
+
+// Runs every config's endpoints, returning the responses and their key sets
+//    along with the post process function of the first config's plugin.
+//    convertResponses passes the responses through each plugin's
+//    ResponseToJson function.
+func pullApiResponses(configLocation string, authParams []string, peekParams []string, additionalParams map[string]map[string]map[string]string, connectionOnly bool, apiKey string, apiSecret string, pluginID int, convertResponses bool) (map[generic_structs.ComparableApiRequest][]byte, []map[string]string, **func(map[generic_structs.ComparableApiRequest][]uint8, []map[string]string, []string) []uint8, bool) {
 	api := generic_structs.ApiRoot{}
 
 	responseList := make(map[generic_structs.ComparableApiRequest][]byte)
@@ -65,8 +154,8 @@ func PullApiData(configLocation string, authParams []string, peekParams []string
 
 	files, err := ioutil.ReadDir(configLocation)
 	if err != nil {
-		utils.LogError("PullApiData", "Unable to read config directory", err)
-		return []byte(nil)
+		utils.LogError("pullApiResponses", "Unable to read config directory", err)
+		return nil, nil, nil, false
 	}
 
 	reporter := dashboard_reporter.Reporter{APIKey: apiKey, APISecret: apiSecret}
@@ -84,37 +173,37 @@ func PullApiData(configLocation string, authParams []string, peekParams []string
 		}
 	}
 
+	// Clients are shared across the whole run so connections are pooled
+	//    between requests - one per distinct http_client/tls configuration.
+	httpClients := make(map[string]*http.Client)
+
 	// Declare this outside the process loop because the post process function  gets applied to results of all API calls.
 	var PluginPostProcessFunction = new(*func(map[generic_structs.ComparableApiRequest][]uint8, []map[string]string, []string) []uint8)
 
 	for _, f := range files {
 		rawYaml, err := ioutil.ReadFile(configLocation + f.Name())
 		if err != nil {
-			utils.LogError("PullApiData", "Error reading YAML API defnition", err)
-			return []byte(nil)
+			utils.LogError("pullApiResponses", "Error reading YAML API defnition", err)
+			return nil, nil, nil, false
 		}
 
 		err = yaml.Unmarshal([]byte(rawYaml), &api)
 		if err != nil {
-			utils.LogError("PullApiData", "Error unmarshaling YAML API definition", err)
-			return []byte(nil)
+			utils.LogError("pullApiResponses", "Error unmarshaling YAML API definition", err)
+			return nil, nil, nil, false
 		}
 
-		// Do our YAML expansion so we can iterate through the various permutations.
-		var expandedYamls [][]byte
+		// Expand vars_data so we can iterate through the various permutations.
+		expandedApis := []generic_structs.ApiRoot{api}
 		if len(api.VarsData) > 0 {
-			expandedYamls = utils.PopulateYamlSlice(string(rawYaml), api.VarsData)
-		} else {
-			expandedYamls = append(expandedYamls, rawYaml)
-		}
-
-		for _, y := range expandedYamls {
-			// Repull our data incase some expansion vars were in there.
-			err = yaml.Unmarshal([]byte(y), &api)
+			expandedApis, err = utils.ExpandVarsData(api)
 			if err != nil {
-				utils.LogError("PullApiData", "Error unmarshaling YAML API definition", err)
-				return []byte(nil)
+				utils.LogError("pullApiResponses", "Error expanding vars_data", err)
+				return nil, nil, nil, false
 			}
+		}
+
+		for _, api := range expandedApis {
 			// Handle Params merging - options are:
 			// - overwrite config file with CLI vars
 			// - input CLI params into config file params at designated places
@@ -159,6 +248,19 @@ func PullApiData(configLocation string, authParams []string, peekParams []string
 				paps = api.PagingParams
 			}
 
+			// Swap any {{secret:provider:path}} references for their values
+			//    now that CLI params have been merged in.
+			aps, err = secrets.ResolveSlice(aps)
+			if err != nil {
+				utils.LogError("pullApiResponses", "Error resolving auth param secrets", err)
+				return nil, nil, nil, false
+			}
+			paps, err = secrets.ResolveSlice(paps)
+			if err != nil {
+				utils.LogError("pullApiResponses", "Error resolving paging param secrets", err)
+				return nil, nil, nil, false
+			}
+
 			rootSettingsData := generic_structs.ApiRequestInheritableSettings{
 				Name:            api.Name,
 				Vars:            api.Vars,
@@ -168,31 +270,54 @@ func PullApiData(configLocation string, authParams []string, peekParams []string
 				PagingParams:    paps,
 				GlobalVars:      api.GlobalVars,
 				SkipContentType: api.SkipContentType,
+				AuthTokenTtl:    api.AuthTokenTtl,
+			}
+
+			clientKey := fmt.Sprintf("%+v %+v", api.HttpClient, api.Tls)
+			httpClient, ok := httpClients[clientKey]
+			if !ok {
+				httpClient, err = utils.NewHttpClient(api.HttpClient, api.Tls)
+				if err != nil {
+					utils.LogError("pullApiResponses", "Error building HTTP client", err)
+					return nil, nil, nil, false
+				}
+				httpClients[clientKey] = httpClient
 			}
 
 			// Load the plugin and functions for this config file.
 			plug, err := plugin.Open(rootSettingsData.Plugin)
 			if err != nil {
-				utils.LogError("PullApiData", "Error opening plugin", err)
-				return []byte(nil)
+				utils.LogError("pullApiResponses", "Error opening plugin", err)
+				return nil, nil, nil, false
 			}
 
 			var PluginAuthFunction = new(*func(generic_structs.ApiRequest,
 				[]string) generic_structs.ApiRequest)
-			authSymbol, err := plug.Lookup("PluginAuthFunction")
-			*PluginAuthFunction = authSymbol.(*func(generic_structs.ApiRequest,
-				[]string) generic_structs.ApiRequest)
-			if err != nil {
-				utils.LogError("PullApiData", "Error looking up plugin Auth function", err)
-				return []byte(nil)
+			if api.AuthType != "" {
+				// A built-in auth helper selected in the YAML overrides the
+				//    plugin's own auth.
+				authFunction, ok := utils.AuthFunctions[api.AuthType]
+				if !ok {
+					utils.LogError("pullApiResponses", "Unknown auth_type", api.AuthType)
+					return nil, nil, nil, false
+				}
+				*PluginAuthFunction = &authFunction
+			} else {
+				authSymbol, err := plug.Lookup("PluginAuthFunction")
+				if err != nil {
+					utils.LogError("pullApiResponses", "Error looking up plugin Auth function", err)
+					return nil, nil, nil, false
+				}
+				*PluginAuthFunction = authSymbol.(*func(generic_structs.ApiRequest,
+					[]string) generic_structs.ApiRequest)
 			}
 
 			var PluginResponseToJsonFunction = new(*func(map[string]string, []byte) []byte)
 			rtjSymbol, err := plug.Lookup("PluginResponseToJsonFunction")
 			*PluginResponseToJsonFunction = rtjSymbol.(*func(map[string]string, []byte) []byte)
 			if err != nil {
-				utils.LogError("PullApiData", "Error looking up plugin ResponseToJson function", err)
-				return []byte(nil)
+				utils.LogError("pullApiResponses", "Error looking up plugin ResponseToJson function", err)
+				return nil, nil, nil, false
 			}
 
 			// We only take the post processing from the first YAML we pull.
@@ -200,8 +325,8 @@ func PullApiData(configLocation string, authParams []string, peekParams []string
 				ppSymbol, err := plug.Lookup("PluginPostProcessFunction")
 				*PluginPostProcessFunction = ppSymbol.(*func(map[generic_structs.ComparableApiRequest][]uint8, []map[string]string, []string) []uint8)
 				if err != nil {
-					utils.LogError("PullApiData", "Error looking up plugin PostProcess function", err)
-					return []byte(nil)
+					utils.LogError("pullApiResponses", "Error looking up plugin PostProcess function", err)
+					return nil, nil, nil, false
 				}
 			}
 
@@ -209,74 +334,111 @@ func PullApiData(configLocation string, authParams []string, peekParams []string
 			paPSymbol, err := plug.Lookup("PluginPagingPeekFunction")
 			*PluginPagingPeekFunction = paPSymbol.(*func([]uint8, []string, interface{}, []string) (interface{}, bool))
 			if err != nil {
-				utils.LogError("PullApiData", "Error looking up plugin PagingPeek function", err)
-				return []byte(nil)
+				utils.LogError("pullApiResponses", "Error looking up plugin PagingPeek function", err)
+				return nil, nil, nil, false
 			}
 
 			// TODO: This doesn't work with a sub endpoint that uses a different plugin.
-			holderResponseList, holderJsonKeys := runThroughEndpoints(api.Endpoints, rootSettingsData, additionalParams, PluginAuthFunction, PluginResponseToJsonFunction, PluginPagingPeekFunction, true, 0, connectionOnly, reporter, pluginID)
-			for k, v := range holderResponseList {
-				responseList[k] = v
+			runEndpoints := func(endpoints []generic_structs.ApiEndpoint, settings generic_structs.ApiRequestInheritableSettings) {
+				holderResponseList, holderJsonKeys := runThroughEndpoints(endpoints, settings, additionalParams, PluginAuthFunction, PluginResponseToJsonFunction, PluginPagingPeekFunction, true, 0, connectionOnly, reporter, pluginID, httpClient)
+				// Converted now, while this config's plugin is loaded.
+				if convertResponses {
+					keySets := make(map[string]map[string]string)
+					for _, keys := range holderJsonKeys {
+						keySets[keys["api_call_uuid"]] = keys
+					}
+					for k, v := range holderResponseList {
+						holderResponseList[k] = responseToJson(keySets[k.Uuid], v, PluginResponseToJsonFunction)
+					}
+				}
+				for k, v := range holderResponseList {
+					responseList[k] = v
+				}
+				jsonKeys = append(jsonKeys, holderJsonKeys...)
 			}
-			jsonKeys = append(jsonKeys, holderJsonKeys...)
-		}
-	}
 
-	checkResult := make(map[string]string)
-	checkResult["Errors"] = "Invalid Credentials"
-	errorJson, _ := json.Marshal(checkResult)
-	if len(responseList) == 0 {
-		return errorJson
-	}
+			// vars_from values are discovered with the root's plugin and auth,
+			//    then expanded like vars_data.
+			discoveredApis := []generic_structs.ApiRoot{api}
+			if len(api.VarsFrom) > 0 {
+				discovered, err := discoverVarsFrom(api.VarsFrom, rootSettingsData, PluginAuthFunction, PluginResponseToJsonFunction, PluginPagingPeekFunction, reporter, pluginID, httpClient)
+				if err != nil {
+					utils.LogError("pullApiResponses", "Error discovering vars_from", err)
+					return nil, nil, nil, false
+				}
+				discoveredApis, err = utils.ExpandVarsFrom(api, discovered)
+				if err != nil {
+					utils.LogError("pullApiResponses", "Error expanding vars_from", err)
+					return nil, nil, nil, false
+				}
+			}
 
-	if connectionOnly {
-		respCodeSuccess := false
-		finalListElement := make(map[generic_structs.ComparableApiRequest][]byte)
+			for _, api := range discoveredApis {
+				rootSettingsData := rootSettingsData
+				rootSettingsData.Name = api.Name
+				rootSettingsData.Vars = api.Vars
+				rootSettingsData.Paging = api.Paging
+				rootSettingsData.GlobalVars = api.GlobalVars
+				rootSettingsData.MergeStrategy = api.MergeStrategy
+				rootSettingsData.Provenance = api.Provenance
+				// Each is down to one value once expanded.
+				rootSettingsData.VarsData = make(map[string]string, len(api.VarsData))
+				for k, v := range api.VarsData {
+					if len(v) == 1 {
+						rootSettingsData.VarsData[k] = v[0]
+					}
+				}
 
-		for k := range responseList {
-			if k.ResponseCode >= 200 && k.ResponseCode <= 299 {
-				respCodeSuccess = true
-				finalListElement[k] = responseList[k]
-			}
-		}
-		if !respCodeSuccess {
-			for k := range responseList {
-				response := string(responseList[k])
-				if response == "[]" || strings.Contains(response,"</html>") || len(response)==0{
-					return errorJson
+				combinations, err := utils.ExpandFanOut(api.FanOut, func(account generic_structs.FanOutAccount) ([]string, error) {
+					return discoverFanOutRegions(api.FanOut.RegionsFrom, account, rootSettingsData, PluginAuthFunction, PluginResponseToJsonFunction, httpClient)
+				})
+				if err != nil {
+					utils.LogError("pullApiResponses", "Error expanding fan_out", err)
+					return nil, nil, nil, false
 				}
-				//responses neither xml nor json
-				if !strings.Contains(response,"{") || !strings.Contains(response,"<"){
-					checkResult["Errors"] = response
-					errorJson, _ := json.Marshal(checkResult)
-					return errorJson
-				}else {
-					finalListElement[k] = responseList[k]
-					break
+				if len(combinations) == 0 {
+					runEndpoints(api.Endpoints, rootSettingsData)
+					continue
+				}
+
+				for _, combination := range combinations {
+					combinationSettings := rootSettingsData
+					combinationSettings.Vars = make(map[string]string)
+					for k, v := range rootSettingsData.Vars {
+						combinationSettings.Vars[k] = v
+					}
+					combinationSettings.GlobalVars = make(map[string]string)
+					for k, v := range rootSettingsData.GlobalVars {
+						combinationSettings.GlobalVars[k] = v
+					}
+					for k, v := range combination.Vars() {
+						combinationSettings.GlobalVars[k] = v
+					}
+					combinationSettings.RecordTags = combination.Tags()
+
+					runEndpoints(api.Endpoints, combinationSettings)
 				}
 			}
 		}
-		responseList = finalListElement
 	}
 
-	// Theoretically we could send each response to its own post-processing,
-	//    but that kind of breaks the idea that we would return everything from
-	//    a single external call as a single JSON blob.  So instead, we're just
-	//    going to use the one provided in a general configuration file.
-	var finalResponseValueList []reflect.Value
-	finalResponseValueList = append(finalResponseValueList,
-		reflect.ValueOf(responseList), reflect.ValueOf(jsonKeys),
-		reflect.ValueOf(postParams))
-	finalResponse := reflect.ValueOf(**PluginPostProcessFunction).Call(
-		finalResponseValueList)
 
-	return finalResponse[0].Bytes()
+	return responseList, jsonKeys, PluginPostProcessFunction, true
 }
 
-func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsData generic_structs.ApiRequestInheritableSettings, additionalParams map[string]map[string]map[string]string, PluginAuthFunction **func(generic_structs.ApiRequest, []string) generic_structs.ApiRequest, PluginResponseToJsonFunction **func(map[string]string, []byte) []byte, PluginPagingPeekFunction **func([]uint8, []string, interface{}, []string) (interface{}, bool), runSubEndpoints bool, depth int, connectionOnly bool, reporter dashboard_reporter.Reporter, pluginID int) (map[generic_structs.ComparableApiRequest][]byte, []map[string]string) {
+func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsData generic_structs.ApiRequestInheritableSettings, additionalParams map[string]map[string]map[string]string, PluginAuthFunction **func(generic_structs.ApiRequest, []string) generic_structs.ApiRequest, PluginResponseToJsonFunction **func(map[string]string, []byte) []byte, PluginPagingPeekFunction **func([]uint8, []string, interface{}, []string) (interface{}, bool), runSubEndpoints bool, depth int, connectionOnly bool, reporter dashboard_reporter.Reporter, pluginID int, httpClient *http.Client) (map[generic_structs.ComparableApiRequest][]byte, []map[string]string) {
 	responseList := make(map[generic_structs.ComparableApiRequest][]byte)
 	var jsonKeys []map[string]string
 
+	endpoints, err := expandTimeWindows(endpoints, rootSettingsData, connectionOnly)
+	if err != nil {
+		utils.LogError("runThroughEndpoints", "Invalid time_window", err)
+		return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+	}
+	// Checkpoints of time windows that weren't fetched in full, so later
+	//    windows don't move the checkpoint past them.
+	failedCheckpoints := make(map[string]bool)
+
 	for _, ep := range endpoints {
 		// Clone and adjust settings map
 		if connectionOnly {
@@ -296,10 +458,9 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 		params := generic_structs.ApiParams{}
 
 		// Pull substitution vars first so we can substitute while saving other variables
-		if len(rootSettingsData.Vars) != 0 {
-			vars = rootSettingsData.Vars
-		} else {
-			vars = make(map[string]string)
+		vars = make(map[string]string)
+		for k, v := range rootSettingsData.Vars {
+			vars[k] = v
 		}
 		doEndpointSubs := false
 		if len(ep.Vars) != 0 {
@@ -330,33 +491,50 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 		} else {
 			name = rootSettingsData.Name
 		}
-		if len(ep.Paging) != 0 {
+		if len(ep.Paging) != 0 && onlyPagingLimits(ep.Paging) {
+			// Endpoints can limit the root's paging without repeating it.
+			paging = make(map[string]string)
+			for k, v := range rootSettingsData.Paging {
+				paging[k] = v
+			}
+			for k, v := range ep.Paging {
+				paging[k] = v
+			}
+		} else if len(ep.Paging) != 0 {
 			paging = ep.Paging
 		} else {
 			paging = rootSettingsData.Paging
 		}
+		// Keys and params are copied since substitutions are made in place,
+		//    and the same endpoint may be run more than once (e.g. per time
+		//    window).
 		if len(ep.CurrentBaseKey) > 0 {
-			currentBaseKey = ep.CurrentBaseKey
+			currentBaseKey = append([]string(nil), ep.CurrentBaseKey...)
 		} else {
 			currentBaseKey = []string(nil)
 		}
 		if len(ep.DesiredBaseKey) > 0 {
-			desiredBaseKey = ep.DesiredBaseKey
+			desiredBaseKey = append([]string(nil), ep.DesiredBaseKey...)
 		} else {
 			desiredBaseKey = []string(nil)
 		}
 		if len(ep.CurrentErrorKey) > 0 {
-			currentErrorKey = ep.CurrentErrorKey
+			currentErrorKey = append([]string(nil), ep.CurrentErrorKey...)
 		} else {
 			currentErrorKey = []string(nil)
 		}
 		if len(ep.DesiredErrorKey) > 0 {
-			desiredErrorKey = ep.DesiredErrorKey
+			desiredErrorKey = append([]string(nil), ep.DesiredErrorKey...)
 		} else {
 			desiredErrorKey = []string(nil)
 		}
+		// GraphQL errors come back with a 200, so collect them by default.
+		if ep.Graphql.Query != "" && len(currentErrorKey) == 0 && len(desiredErrorKey) == 0 {
+			currentErrorKey = []string{"errors"}
+			desiredErrorKey = []string{"errors"}
+		}
 		if len(ep.Params.QueryString) != 0 || len(ep.Params.Body) != 0 || len(ep.Params.Header) != 0 {
-			params = ep.Params
+			params = ep.Params.Copy()
 		} else {
 			params = generic_structs.ApiParams{
 				QueryString: make(map[string][]string),
@@ -365,39 +543,6 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 			}
 		}
 
-		timeRegex, err := regexp.Compile("^{{time:(\\-?.+)}}$")
-		if err != nil {
-			utils.LogError("runThroughEndpoints", "Failed to parse time regex", err)
-			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
-		}
-
-		for k, v := range ep.Params.QueryString {
-			for index, value := range v {
-				if !strings.Contains(value, "{{") {
-					continue
-				}
-
-				if strings.Contains(value, "{{time:") {
-					matches := timeRegex.FindStringSubmatch(value)
-					if matches == nil || len(matches[1]) == 0 {
-						utils.LogError("runThroughEndpoints", "Invalid param value", value)
-						return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
-					}
-					if matches[1] == "now" {
-						ep.Params.QueryString[k][index] = strconv.Itoa(int(time.Now().Unix()))
-						continue
-					}
-
-					duration, err := time.ParseDuration(matches[1])
-					if err != nil {
-						utils.LogError("runThroughEndpoints", fmt.Sprintf("Failed to parse duration %s", value), err)
-						return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
-					}
-					ep.Params.QueryString[k][index] = strconv.Itoa(int(time.Now().Add(duration).Unix()))
-				}
-			}
-		}
-
 		// Merge runtime params.
 		for t, m := range additionalParams[ep.Name] {
 			if t == "header" {
@@ -409,55 +554,127 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 					params.QueryString[k] = append(params.QueryString[k], v)
 				}
 			} else if t == "body" {
-				// TODO
+				for k, v := range m {
+					params.Body[k] = append(params.Body[k], v)
+				}
 			}
 		}
 
-		// If we have substitution vars, do the substitutions.
-		if doEndpointSubs {
-			for k, v := range vars {
-				if len(currentBaseKey) != len(desiredBaseKey) || len(currentErrorKey) != len(desiredErrorKey) {
-					utils.LogError("runThroughEndpoints", "Current and desired key lists must be the same length")
-					return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
-				} else {
-					name = strings.Replace(name, "{{"+k+"}}", v, -1)
-					for i := range currentBaseKey {
-						currentBaseKey[i] = strings.Replace(currentBaseKey[i], "{{"+k+"}}", v, -1)
-						desiredBaseKey[i] = strings.Replace(desiredBaseKey[i], "{{"+k+"}}", v, -1)
-					}
-					for i := range currentErrorKey {
-						currentErrorKey[i] = strings.Replace(currentErrorKey[i], "{{"+k+"}}", v, -1)
-						desiredErrorKey[i] = strings.Replace(desiredErrorKey[i], "{{"+k+"}}", v, -1)
-					}
-					for pk, pv := range params.Header {
-						for li, item := range pv {
-							params.Header[pk][li] = strings.Replace(item, "{{"+k+"}}", v, -1)
-						}
-					}
-					for pk, pv := range params.QueryString {
-						for li, item := range pv {
-							params.QueryString[pk][li] = strings.Replace(item, "{{"+k+"}}", v, -1)
-						}
-					}
-					for pk, pv := range params.Body {
-						for li, item := range pv {
-							params.Body[pk][li] = strings.Replace(item, "{{"+k+"}}", v, -1)
-						}
-					}
-					ep.Endpoint = strings.Replace(ep.Endpoint, "{{"+k+"}}", v, -1)
-					if len(additionalParams["*"]) > 0 && len(additionalParams["*"]["var_params"]) > 0 {
-						for varKey, varValue := range additionalParams["*"]["var_params"] {
-							if strings.ToLower(varKey) == strings.ToLower(k) {
-								ep.Endpoint = strings.Replace(ep.Endpoint, strings.ToUpper(k), varValue, -1)
-							}
-						}
+		// Runtime var_params override the vars of the same name.
+		for varKey, varValue := range additionalParams["*"]["var_params"] {
+			for k := range vars {
+				if strings.EqualFold(varKey, k) {
+					vars[k] = varValue
+				}
+			}
+		}
+
+		if err := utils.ValidateTransform(ep.Transform); err != nil {
+			utils.LogError("runThroughEndpoints", "["+ep.Name+"]", "Invalid transform", err)
+			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+		}
+		mergeStrategy := rootSettingsData.MergeStrategy
+		if ep.MergeStrategy != "" {
+			mergeStrategy = ep.MergeStrategy
+		}
+		if err := utils.ValidateMergeStrategy(mergeStrategy); err != nil {
+			utils.LogError("runThroughEndpoints", "["+ep.Name+"]", err)
+			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+		}
+		if err := utils.ValidateResponseFormat(ep.ResponseFormat); err != nil {
+			utils.LogError("runThroughEndpoints", "["+ep.Name+"]", err)
+			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+		}
+		if err := utils.ValidatePaging(paging); err != nil {
+			utils.LogError("runThroughEndpoints", "["+ep.Name+"]", "Invalid paging", err)
+			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+		}
+
+		if doEndpointSubs && (len(currentBaseKey) != len(desiredBaseKey) || len(currentErrorKey) != len(desiredErrorKey)) {
+			utils.LogError("runThroughEndpoints", "Current and desired key lists must be the same length")
+			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+		}
+
+		// Render every templated string, with sub-endpoints also able to use
+		//    the parent values named in endpoint_key_names and the fields of
+		//    their parents as {{parent.field}}.
+		templateVars := make(map[string]string)
+		for k, v := range ep.EndpointKeyValues {
+			templateVars[k] = utils.SubEndpointKeyString(v)
+		}
+		utils.AddParentTemplateVars(templateVars, ep.ParentContext)
+		for k, v := range vars {
+			templateVars[k] = v
+		}
+		now := time.Now()
+		var templateErr error
+		renderWith := func(renderTemplate func(string, map[string]string, time.Time) (string, error), value string) string {
+			rendered, err := renderTemplate(value, templateVars, now)
+			if err != nil {
+				if templateErr == nil {
+					templateErr = fmt.Errorf("%q: %v", value, err)
+				}
+				return value
+			}
+			return rendered
+		}
+		render := func(value string) string {
+			return renderWith(utils.RenderTemplate, value)
+		}
+
+		// Secrets are only resolved in the params' own strings, before
+		//    rendering, and are passed in as vars so that neither they nor
+		//    values from API data are read as templates or secret references.
+		secretCount := 0
+		for _, p := range []map[string][]string{params.Header, params.QueryString, params.Body} {
+			for k, v := range p {
+				for i := range v {
+					resolved, err := secrets.ResolveFunc(v[i], func(reference string, secret string) string {
+						// Not a valid var name, so it can't clash with one.
+						name := "secret:" + strconv.Itoa(secretCount)
+						secretCount++
+						templateVars[name] = secret
+						return "{{var " + strconv.Quote(name) + "}}"
+					})
+					if err != nil {
+						utils.LogError("runThroughEndpoints", "Error resolving param secrets", err)
+						return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
 					}
-					ep.Documentation = strings.Replace(ep.Documentation, "{{"+k+"}}", v, -1)
+					p[k][i] = resolved
+				}
+			}
+		}
+		name = render(name)
+		for _, keys := range [][]string{currentBaseKey, desiredBaseKey, currentErrorKey, desiredErrorKey} {
+			for i := range keys {
+				keys[i] = render(keys[i])
+			}
+		}
+		for _, p := range []map[string][]string{params.Header, params.QueryString, params.Body} {
+			for k, v := range p {
+				for i := range v {
+					p[k][i] = render(v[i])
 				}
 			}
 		}
+		// Values in the URL are escaped for the part of it they're in.
+		ep.Endpoint = renderWith(utils.RenderUrlTemplate, ep.Endpoint)
+		ep.Graphql.Query = render(ep.Graphql.Query)
+		ep.Graphql.Variables = utils.MapGraphqlVariables(ep.Graphql.Variables, render)
+		ep.Documentation = render(ep.Documentation)
+		if templateErr != nil {
+			utils.LogError("runThroughEndpoints", "["+ep.Name+"]", "Invalid template", templateErr)
+			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+		}
 
-		tempRequest, err := http.NewRequest("GET", ep.Endpoint, nil)
+		var tempRequest *http.Request
+		var graphqlVariables map[string]interface{}
+		if ep.Graphql.Query != "" {
+			graphqlVariables = utils.GraphqlVariables(ep.Graphql.Variables, params.Body)
+			tempRequest, err = utils.NewGraphqlRequest(ep.Endpoint, ep.Graphql, graphqlVariables)
+		} else {
+			tempRequest, err = http.NewRequest("GET", ep.Endpoint, nil)
+		}
 		if err != nil {
 			utils.LogError("runThroughEndpoints", "Error creating API request object", err)
 			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
@@ -489,6 +706,27 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 			newKeySet["current_error_key_"+strconv.Itoa(i)] = currentErrorKey[i]
 			newKeySet["desired_error_key_"+strconv.Itoa(i)] = desiredErrorKey[i]
 		}
+		if mergeStrategy != "" {
+			newKeySet["merge_strategy"] = mergeStrategy
+		}
+		// Responses decoded from a response_format are JSON already, so skip
+		//    the plugin's conversion.
+		if ep.ResponseFormat != "" {
+			newKeySet["response_format"] = ep.ResponseFormat
+		}
+		if rootSettingsData.Provenance {
+			newKeySet["provenance"] = "true"
+			newKeySet["api_name"] = rootSettingsData.Name
+		}
+		// The transform is applied to the records in the post process.
+		if len(ep.Transform) > 0 {
+			transform, err := json.Marshal(ep.Transform)
+			if err != nil {
+				utils.LogError("runThroughEndpoints", "Unable to encode transform", err)
+				return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+			}
+			newKeySet["transform"] = string(transform)
+		}
 
 		// TODO: This seems dreadfully inefficient...
 		// Only add a new keyset if one like it doesn't exist
@@ -502,6 +740,19 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 			jsonKeys = append(jsonKeys, newKeySet)
 		}
 
+		// Fan out tags ride along with the endpoint key values so they are
+		//    added to every record in the post process.
+		endpointKeyValues := ep.EndpointKeyValues
+		if len(rootSettingsData.RecordTags) > 0 {
+			endpointKeyValues = make(map[string]interface{})
+			for k, v := range ep.EndpointKeyValues {
+				endpointKeyValues[k] = v
+			}
+			for k, v := range rootSettingsData.RecordTags {
+				endpointKeyValues[k] = v
+			}
+		}
+
 		// Create our new ApiRequest object with the extrapolated data
 		newApiRequest := generic_structs.ApiRequest{
 			Settings: generic_structs.ApiRequestInheritableSettings{
@@ -510,6 +761,7 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 				Vars:            vars,
 				Paging:          paging,
 				SkipContentType: rootSettingsData.SkipContentType,
+				AuthTokenTtl:    rootSettingsData.AuthTokenTtl,
 			},
 			Endpoint:          ep.Endpoint,
 			CurrentBaseKey:    currentBaseKey,
@@ -518,7 +770,8 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 			DesiredErrorKey:   desiredErrorKey,
 			Params:            params,
 			FullRequest:       tempRequest,
-			EndpointKeyValues: ep.EndpointKeyValues,
+			Client:            httpClient,
+			EndpointKeyValues: endpointKeyValues,
 		}
 
 		// Apply our passed vars to the header/qs/body.
@@ -538,6 +791,10 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 				q.Add(k, v[0])
 			}
 		}
+		// Counted paging modes can send their page size as well.
+		if newApiRequest.Settings.Paging["page_size_field"] != "" && newApiRequest.Settings.Paging["page_size"] != "" {
+			q.Set(newApiRequest.Settings.Paging["page_size_field"], newApiRequest.Settings.Paging["page_size"])
+		}
 
 		if(!connectionOnly){
 			var scanLogs []dashboard_reporter.ScanLog
@@ -558,20 +815,36 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 		// From there we will see if there are more before adding more.
 		newApiRequest.FullRequest.URL.RawQuery = q.Encode()
 
-		var requestValue []reflect.Value
+		// Paging limits and cycle detection apply to every paging mode.
+		pagingLimits, err := utils.ParsePagingLimits(newApiRequest.Settings.Paging)
+		if err != nil {
+			utils.LogError("runThroughEndpoints", "Invalid paging limits", err)
+			return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+		}
+		var itemKeys []string
+		if len(currentBaseKey) > 0 {
+			itemKeys = strings.Split(currentBaseKey[0], ".")
+		}
+		pagingGuard := utils.NewPagingGuard(pagingLimits, itemKeys)
+
 		newApiRequest.Time = time.Now()
-		requestValue = append(requestValue, reflect.ValueOf(newApiRequest), reflect.ValueOf(rootSettingsData.AuthParams))
-		finalRequest := reflect.ValueOf(**PluginAuthFunction).Call(requestValue)
-		statusCode, response, responseHeaders := runApiRequest(finalRequest[0].Interface().(generic_structs.ApiRequest))
+		requestUrl := newApiRequest.FullRequest.URL.String()
+		statusCode, response, responseHeaders, requestErr := authAndRunApiRequest(newApiRequest, rootSettingsData.AuthParams, PluginAuthFunction)
 		if statusCode < 200 || statusCode > 299 {
 			utils.LogWarning("runThroughEndpoints", "[" + ep.Name + "]", fmt.Sprintf("Expected response status 2xx, got %d", statusCode))
+			utils.AddRequestFailure(newKeySet, requestUrl, statusCode, response, requestErr)
+			failedCheckpoints[vars["window_checkpoint"]] = true
 			if !connectionOnly {
 				continue
 			}
+		} else if response, err = decodeEndpointResponse(ep, newKeySet, requestUrl, statusCode, response); err != nil {
+			failedCheckpoints[vars["window_checkpoint"]] = true
+			continue
 		}
 
 		comRequest := newApiRequest.ToComparableApiRequest()
 		comRequest.Uuid = newUuid.String()
+		comRequest.Url = utils.RedactUrl(requestUrl)
 		if connectionOnly {
 			comRequest.ResponseCode = statusCode
 		}
@@ -586,123 +859,121 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 			}
 		}
 		// Add the first response to our new response list (map). Now check if we need to page.
-
-		// Here we handle multipart keys - response.key.key1 etc.
-		var responseKeys []string
-		if newApiRequest.Settings.Paging["indicator_from_structure"] ==
-			"calculated" {
-			// If this is a calculated paging var, then it should be a
-			//    list with the results per page first and total
-			//    results second. Since the multipart keys could be of
-			//    different lengths, we store where the split is to
-			//    break it up in the peek func.
-			separateKeys := strings.Split(newApiRequest.Settings.Paging["indicator_from_field"], ",")
-			if len(separateKeys) != 3 {
-				utils.LogError("runThroughEndpoints", "Calculated paging requires three values - current page number, results per page, total results")
+		// Sub-endpoint keys are pulled from these responses.
+		parentResponses := [][]byte{response}
+		pagingGuard.AddPage(response)
+		// Set to why paging was cut short, if it was.
+		var pagingStopped string
+
+		if ep.Graphql.Query != "" {
+			var graphqlPages [][]byte
+			graphqlPages, pagingStopped = runGraphqlPages(ep, newApiRequest, graphqlVariables, newKeySet, response, responseList, pagingGuard, rootSettingsData.AuthParams, PluginAuthFunction)
+			parentResponses = append(parentResponses, graphqlPages...)
+		} else {
+			// Here we handle multipart keys - response.key.key1 etc.
+			responseKeys, ok := pagingResponseKeys(newApiRequest.Settings.Paging, currentBaseKey)
+			if !ok {
 				return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
 			}
-			responseKeys = []string{strconv.Itoa(len(strings.Split(separateKeys[0], "."))) + "," + strconv.Itoa(len(strings.Split(separateKeys[1], ".")))}
-			for _, v := range separateKeys {
-				responseKeys = append(responseKeys, strings.Split(v, ".")...)
+
+			// Call our peek function to see if we have a paging value.
+			startPageValue := firstPageValue(newApiRequest)
+			if _, ok := startPageValue.(*interface{}); !ok {
+				pagingGuard.Seen(startPageValue)
 			}
-		} else {
-			responseKeys = strings.Split(newApiRequest.Settings.Paging["indicator_from_field"], ".")
-		}
-
-		// Call our peek function to see if we have a paging value.
-		var pagingData reflect.Value
-		if newApiRequest.Settings.Paging["location_from"] == "header" {
-			pagingData = reflect.ValueOf(responseHeaders)
-		} else { // Default: response body.
-			pagingData = reflect.ValueOf(response)
-		}
-		var finalPeekValueList []reflect.Value
-		finalPeekValueList = append(finalPeekValueList, pagingData, reflect.ValueOf(responseKeys), reflect.ValueOf((*interface{})(nil)), reflect.ValueOf(rootSettingsData.PagingParams))
-		peekValue := reflect.ValueOf(**PluginPagingPeekFunction).Call(finalPeekValueList)
-		pageValue := peekValue[0].Interface()
-		morePages := peekValue[1].Bool()
-
-		for morePages {
-			oldPageValue := pageValue
-			nextApiRequest := newApiRequest
-			// Handle passing the paging indicator.
-			// TODO: Handle "body"
-			if nextApiRequest.Settings.Paging["location_to"] == "querystring" {
-				// TODO: Change to 'case'
-				if nextApiRequest.Settings.Paging["indicator_from_structure"] == "full_url" {
-					nextApiRequest.FullRequest.URL, err = nextApiRequest.FullRequest.URL.Parse(oldPageValue.(string))
-					if err != nil {
-						utils.LogError("runThroughEndpoints", "Error parsing paging URL returned", err)
-						return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
-					}
-				} else if nextApiRequest.Settings.Paging["indicator_from_structure"] == "calculated" {
-					q := nextApiRequest.FullRequest.URL.Query()
-					q.Set(nextApiRequest.Settings.Paging["indicator_to_field"], strconv.FormatFloat(oldPageValue.(float64), 'f', -1, 64))
-					nextApiRequest.FullRequest.URL.RawQuery = q.Encode()
-				} else {
-					// By default they just give us a param back.
-					q := nextApiRequest.FullRequest.URL.Query()
-					q.Set(nextApiRequest.Settings.Paging["indicator_to_field"], oldPageValue.(string))
-					nextApiRequest.FullRequest.URL.RawQuery = q.Encode()
+			// The page URL is changed in place, so keep the first.
+			firstUrl := *newApiRequest.FullRequest.URL
+			pageValue, morePages := peekPage(newApiRequest.Settings.Paging, ep.ResponseFormat != "", response, responseHeaders, responseKeys, startPageValue, rootSettingsData.PagingParams, PluginPagingPeekFunction)
+
+			page := 0
+			for morePages {
+				if pagingStopped = pagingGuard.Stop(pageValue); pagingStopped != "" {
+					utils.LogWarning("runThroughEndpoints", "["+ep.Name+"]", pagingGuard.Describe(pagingStopped))
+					break
 				}
 
-			} // TODO: Handle more options here then just QS?
-
-			var newRequestValue []reflect.Value
-			nextApiRequest.Time = time.Now()
-			newRequestValue = append(newRequestValue, reflect.ValueOf(nextApiRequest), reflect.ValueOf(rootSettingsData.AuthParams))
-			newFinalRequest := reflect.ValueOf(**PluginAuthFunction).Call(newRequestValue)
-			newStatusCode, newResponse, newResponseHeaders := runApiRequest(newFinalRequest[0].Interface().(generic_structs.ApiRequest))
-			if newStatusCode < 200 || newStatusCode > 299 {
-				utils.LogWarning("runThroughEndpoints", "[" + ep.Name + "]", fmt.Sprintf("Expected new response status 2xx, got %d", newStatusCode))
-			}
+				oldPageValue := pageValue
+				nextApiRequest := newApiRequest
+				// Handle passing the paging indicator.
+				// TODO: Handle "body"
+				// Link headers always hold the full URL of the next page.
+				if nextApiRequest.Settings.Paging["location_to"] == "querystring" || nextApiRequest.Settings.Paging["indicator_from_structure"] == "link_header" {
+					// TODO: Change to 'case'
+					if nextApiRequest.Settings.Paging["indicator_from_structure"] == "full_url" || nextApiRequest.Settings.Paging["indicator_from_structure"] == "link_header" {
+						nextUrl, err := nextApiRequest.FullRequest.URL.Parse(oldPageValue.(string))
+						if err != nil {
+							utils.LogError("runThroughEndpoints", "Error parsing paging URL returned", err)
+							return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+						}
+						if nextApiRequest.Settings.Paging["indicator_from_structure"] == "link_header" && !utils.SameUrlOrigin(&firstUrl, nextUrl) {
+							utils.LogWarning("runThroughEndpoints", "["+ep.Name+"]", "Not following a Link header to another host", utils.RedactUrl(nextUrl.String()))
+							pagingStopped = "cross_host_link"
+							break
+						}
+						nextApiRequest.FullRequest.URL = nextUrl
+					} else if nextApiRequest.Settings.Paging["indicator_from_structure"] == "calculated" || nextApiRequest.Settings.Paging["indicator_from_structure"] == "offset" || nextApiRequest.Settings.Paging["indicator_from_structure"] == "page_number" {
+						q := nextApiRequest.FullRequest.URL.Query()
+						q.Set(nextApiRequest.Settings.Paging["indicator_to_field"], strconv.FormatFloat(oldPageValue.(float64), 'f', -1, 64))
+						nextApiRequest.FullRequest.URL.RawQuery = q.Encode()
+					} else {
+						// By default they just give us a param back.
+						q := nextApiRequest.FullRequest.URL.Query()
+						q.Set(nextApiRequest.Settings.Paging["indicator_to_field"], oldPageValue.(string))
+						nextApiRequest.FullRequest.URL.RawQuery = q.Encode()
+					}
 
-			comRequest = nextApiRequest.ToComparableApiRequest()
-			comRequest.Uuid = newUuid.String()
-			if ep.Return != "false" {
-				if _, ok := responseList[comRequest]; ok {
-					responseList[comRequest] = append(responseList[comRequest], newResponse...)
+				} // TODO: Handle more options here then just QS?
+
+				page++
+				nextApiRequest.Time = time.Now()
+				requestUrl = nextApiRequest.FullRequest.URL.String()
+				newStatusCode, newResponse, newResponseHeaders, requestErr := authAndRunApiRequest(nextApiRequest, rootSettingsData.AuthParams, PluginAuthFunction)
+				if newStatusCode < 200 || newStatusCode > 299 {
+					utils.LogWarning("runThroughEndpoints", "[" + ep.Name + "]", fmt.Sprintf("Expected new response status 2xx, got %d", newStatusCode))
+					utils.AddRequestFailure(newKeySet, requestUrl, newStatusCode, newResponse, requestErr)
+					failedCheckpoints[vars["window_checkpoint"]] = true
+					// The error body isn't data, and the next page can't be
+					//    found from it.
+					pagingStopped = "request_failed"
+					break
+				} else if newResponse, err = decodeEndpointResponse(ep, newKeySet, requestUrl, newStatusCode, newResponse); err != nil {
+					// The page's failure is recorded, but the next page
+					//    can't be found without it.
+					failedCheckpoints[vars["window_checkpoint"]] = true
+					break
 				} else {
-					responseList[comRequest] = append(make([]byte, 0), newResponse...)
+					parentResponses = append(parentResponses, newResponse)
 				}
-			}
 
-			var newResponseKeys []string
-			if nextApiRequest.Settings.Paging["indicator_from_structure"] ==
-				"calculated" {
-				// See above.
-				separateKeys := strings.Split(nextApiRequest.Settings.Paging["indicator_from_field"], ",")
-				if len(separateKeys) != 3 {
-					utils.LogError("runThroughEndpoints", "Calculated paging requires three values - current page number, results per page, total results")
-					return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+				comRequest = nextApiRequest.ToComparableApiRequest()
+				comRequest.Uuid = newUuid.String()
+				comRequest.Url = utils.RedactUrl(requestUrl)
+				comRequest.Page = page
+				if ep.Return != "false" {
+					if _, ok := responseList[comRequest]; ok {
+						responseList[comRequest] = append(responseList[comRequest], newResponse...)
+					} else {
+						responseList[comRequest] = append(make([]byte, 0), newResponse...)
+					}
 				}
 
-				newResponseKeys = []string{strconv.Itoa(len(strings.Split(separateKeys[0], "."))) + "," + strconv.Itoa(len(strings.Split(separateKeys[1], ".")))}
+				pagingGuard.AddPage(newResponse)
 
-				for _, v := range separateKeys {
-					newResponseKeys = append(newResponseKeys, strings.Split(v, ".")...)
-				}
-			} else {
-				newResponseKeys = strings.Split(nextApiRequest.Settings.Paging["indicator_from_field"], ".")
+				// Call our peek function to see if we have a paging value.
+				pageValue, morePages = peekPage(newApiRequest.Settings.Paging, ep.ResponseFormat != "", newResponse, newResponseHeaders, responseKeys, oldPageValue, rootSettingsData.PagingParams, PluginPagingPeekFunction)
 			}
-
-			// Call our peek function to see if we have a paging value.
-			var pagingData reflect.Value
-			if newApiRequest.Settings.Paging["location_from"] == "header" {
-				pagingData = reflect.ValueOf(newResponseHeaders)
-			} else { // Default: response body.
-				pagingData = reflect.ValueOf(newResponse)
+		}
+		// Mark the results as partial so the post process can report it.
+		if pagingStopped != "" {
+			newKeySet["partial_result"] = pagingStopped
+			failedCheckpoints[vars["window_checkpoint"]] = true
+		}
+		// Checkpoint each time window fetched in full, up to the first that
+		//    wasn't.
+		if checkpointKey := vars["window_checkpoint"]; checkpointKey != "" && !failedCheckpoints[checkpointKey] {
+			if err := utils.GetStateStore().SetState(checkpointKey, vars["window_end"]); err != nil {
+				utils.LogWarning("runThroughEndpoints", "["+ep.Name+"]", "Unable to save time_window checkpoint", err)
 			}
-
-			var finalPeekValueList []reflect.Value
-			finalPeekValueList = append(
-				finalPeekValueList, pagingData,
-				reflect.ValueOf(newResponseKeys),
-				reflect.ValueOf(oldPageValue),
-				reflect.ValueOf(rootSettingsData.PagingParams))
-			peekValue := reflect.ValueOf(**PluginPagingPeekFunction).Call(finalPeekValueList)
-			pageValue = peekValue[0].Interface()
-			morePages = peekValue[1].Bool()
 		}
 
 		// How do we expand variables into sub endpoints (e.g. main endpoint is for us-east-1 but sub endpoint should do all)
@@ -712,46 +983,31 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 			//     create new endpoint epHolder
 			//     expand endpoint_key into epHolder properties
 			//     run calls on subendpoint
-			var jsonConversionValue []reflect.Value
-			jsonConversionValue = append(jsonConversionValue, reflect.ValueOf(ep.Vars), reflect.ValueOf(response))
-			finalJsonResponse := reflect.ValueOf(**PluginResponseToJsonFunction).Call(jsonConversionValue)
-
-			pagingData := finalJsonResponse[0].Bytes()
-
-			responseKeys = strings.Split(key, ".")
 			var unparsedArrayStructure []map[string]interface{}
-			var unparsedStructure map[string]interface{}
-			if err := json.Unmarshal(pagingData, &unparsedArrayStructure); err != nil {
-				if err := json.Unmarshal(pagingData, &unparsedStructure); err != nil {
-					utils.LogError("runThroughEndpoints:SubEndpoints", "Error unmarshaling JSON", err)
-					return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+			for _, parentResponse := range parentResponses {
+				pagingData := responseToJson(newKeySet, parentResponse, PluginResponseToJsonFunction)
+
+				var unparsedPageStructure []map[string]interface{}
+				var unparsedStructure map[string]interface{}
+				if err := json.Unmarshal(pagingData, &unparsedPageStructure); err != nil {
+					if err := json.Unmarshal(pagingData, &unparsedStructure); err != nil {
+						utils.LogError("runThroughEndpoints:SubEndpoints", "Error unmarshaling JSON", err)
+						return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
+					}
+					unparsedPageStructure = append(unparsedPageStructure, unparsedStructure)
 				}
-				unparsedArrayStructure = append(unparsedArrayStructure, unparsedStructure)
+				unparsedArrayStructure = append(unparsedArrayStructure, unparsedPageStructure...)
 			}
-			keyValues := utils.ParseJsonSubStructure(responseKeys, 0, unparsedArrayStructure)
+			keyValues := utils.SubEndpointKeys(unparsedArrayStructure, key)
 
 			var epHolder []generic_structs.ApiEndpoint
 			for _, endpoint := range sEp {
 				// For each ID key returned, create a new endpoint and append
-				for keyValueIndex, value := range keyValues {
+				for _, keyValue := range keyValues {
 					var newSubEp generic_structs.ApiEndpoint
 					newSubEp = endpoint.Copy()
-					var endpointKey string
-					switch tp := value.(type) {
-					case string:
-						endpointKey = value.(string)
-					case float64:
-						endpointKey = strconv.FormatFloat(value.(float64), 'f', -1, 64)
-					case float32:
-						endpointKey = strconv.FormatFloat(float64(value.(float32)), 'f', -1, 32)
-					case int:
-						endpointKey = strconv.Itoa(value.(int))
-					case int64:
-						endpointKey = strconv.FormatInt(value.(int64), 10)
-					default:
-						utils.LogError("runThroughEndpoints:SubEndpoints:EndpointKey", fmt.Sprintf("Unrecognized value type: %#v", tp))
-						return map[generic_structs.ComparableApiRequest][]byte{}, []map[string]string{}
-					}
+					// Numbers, booleans and objects (as JSON) can be keys too.
+					endpointKey := utils.SubEndpointKeyString(keyValue.Value)
 					newSubEp.EndpointKeyValues = make(map[string]interface{})
 					for endpointSourceKeyName, endpointTargetKeyName := range endpoint.EndpointKeyNames {
 						if endpointSourceKeyName == "{{endpoint_key}}" {
@@ -759,10 +1015,8 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 							continue
 						}
 
-						// Not quite optimal, because it's being regenerated on every iteration in parent endpoint results
-						subStructure := utils.ParseJsonSubStructure(strings.Split(endpointSourceKeyName, "."), 0, unparsedArrayStructure)
-						if len(subStructure) > 0 {
-							newSubEp.EndpointKeyValues[endpointTargetKeyName] = subStructure[keyValueIndex]
+						if fieldValue, ok := keyValue.Field(endpointSourceKeyName); ok {
+							newSubEp.EndpointKeyValues[endpointTargetKeyName] = fieldValue
 						} else if len(ep.EndpointKeyValues) > 0 { // Trying to take value from parent if any
 							value, ok := ep.EndpointKeyValues[endpointTargetKeyName]
 							if ok {
@@ -771,26 +1025,14 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 						}
 					}
 
-					for k, v := range newSubEp.EndpointKeyValues {
-						if v == nil {
-							continue
-						}
-
-						strValue, ok := v.(string)
-						if !ok {
-							continue
-						}
-
-						newSubEp.Endpoint = strings.Replace(newSubEp.Endpoint, "{{"+k+"}}", strValue, -1)
-					}
-
+					newSubEp.ParentContext = keyValue.ParentContext(ep.ParentContext)
 					newSubEp.Vars["endpoint_key"] = endpointKey
 					epHolder = append(epHolder, newSubEp)
 				}
 			}
 
 			// Recursively call this method for each sub endpoint.
-			subResponseList, subJsonKeys := runThroughEndpoints(epHolder, rootSettingsData, additionalParams, PluginAuthFunction, PluginResponseToJsonFunction, PluginPagingPeekFunction, false, depth+1, connectionOnly, reporter, pluginID )
+			subResponseList, subJsonKeys := runThroughEndpoints(epHolder, rootSettingsData, additionalParams, PluginAuthFunction, PluginResponseToJsonFunction, PluginPagingPeekFunction, false, depth+1, connectionOnly, reporter, pluginID, httpClient)
 			for k, v := range subResponseList {
 				responseList[k] = v
 			}
@@ -801,7 +1043,441 @@ func runThroughEndpoints(endpoints []generic_structs.ApiEndpoint, rootSettingsDa
 	return responseList, jsonKeys
 }
 
-func runApiRequest(apiRequest generic_structs.ApiRequest) (int, []byte, []byte) {
+// Replaces each time_window endpoint with one copy per window, setting the
+//    window_start and window_end vars.  Checkpointed endpoints resume from the
+//    end of the last window fetched in full and are given the
+//    window_checkpoint var to save their progress under.  Connection checks
+//    only run the latest window.
+func expandTimeWindows(endpoints []generic_structs.ApiEndpoint, rootSettingsData generic_structs.ApiRequestInheritableSettings, connectionOnly bool) ([]generic_structs.ApiEndpoint, error) {
+	var expandedEndpoints []generic_structs.ApiEndpoint
+	now := time.Now().UTC()
+
+	for _, ep := range endpoints {
+		if ep.TimeWindow.Start == "" && ep.TimeWindow.Size == "" {
+			expandedEndpoints = append(expandedEndpoints, ep)
+			continue
+		}
+
+		vars := make(map[string]string)
+		for _, m := range []map[string]string{rootSettingsData.Vars, ep.Vars, rootSettingsData.GlobalVars} {
+			for k, v := range m {
+				vars[k] = v
+			}
+		}
+		checkpointKey, checkpoint := "", ""
+		if ep.TimeWindow.Checkpoint && !connectionOnly {
+			checkpointKey = utils.TimeWindowStateKey(rootSettingsData.Name, ep.Name, rootSettingsData.RecordTags, rootSettingsData.VarsData)
+			checkpoint, _ = utils.GetStateStore().GetState(checkpointKey)
+		}
+
+		windows, err := utils.TimeWindows(ep.TimeWindow, now, vars, checkpoint)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ep.Name, err)
+		}
+		if len(windows) == 0 {
+			utils.LogInfo("expandTimeWindows", "["+ep.Name+"]", "No new time windows to fetch")
+			continue
+		}
+		if connectionOnly {
+			windows = windows[len(windows)-1:]
+		}
+
+		for _, window := range windows {
+			windowEp := ep
+			windowEp.Vars = make(map[string]string)
+			for k, v := range ep.Vars {
+				windowEp.Vars[k] = v
+			}
+			windowEp.Vars["window_start"] = utils.FormatTime(window.Start, "rfc3339nano")
+			windowEp.Vars["window_end"] = utils.FormatTime(window.End, "rfc3339nano")
+			if checkpointKey != "" {
+				windowEp.Vars["window_checkpoint"] = checkpointKey
+			}
+			expandedEndpoints = append(expandedEndpoints, windowEp)
+		}
+	}
+
+	return expandedEndpoints, nil
+}
+
+// Splits the paging indicator_from_field into the keys passed to the peek
+//    function.  The offset and page_number modes count the items at the
+//    first current_base_key unless indicator_from_field says otherwise.
+func pagingResponseKeys(paging map[string]string, currentBaseKey []string) ([]string, bool) {
+	switch paging["indicator_from_structure"] {
+	case "calculated":
+		// If this is a calculated paging var, then it should be a
+		//    list with the results per page first and total
+		//    results second. Since the multipart keys could be of
+		//    different lengths, we store where the split is to
+		//    break it up in the peek func.
+		separateKeys := strings.Split(paging["indicator_from_field"], ",")
+		if len(separateKeys) != 3 {
+			utils.LogError("runThroughEndpoints", "Calculated paging requires three values - current page number, results per page, total results")
+			return nil, false
+		}
+		responseKeys := []string{strconv.Itoa(len(strings.Split(separateKeys[0], "."))) + "," + strconv.Itoa(len(strings.Split(separateKeys[1], ".")))}
+		for _, v := range separateKeys {
+			responseKeys = append(responseKeys, strings.Split(v, ".")...)
+		}
+		return responseKeys, true
+	case "offset", "page_number":
+		if paging["indicator_from_field"] == "" && len(currentBaseKey) > 0 {
+			return strings.Split(currentBaseKey[0], "."), true
+		}
+	}
+
+	return strings.Split(paging["indicator_from_field"], "."), true
+}
+
+// Returns whether the paging settings only hold the paging limits.
+func onlyPagingLimits(paging map[string]string) bool {
+	for k := range paging {
+		if k != "max_pages" && k != "max_items" && k != "max_duration" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the page value the first request was made with.  For the offset
+//    and page_number modes this is the indicator_to_field value already in
+//    the querystring, if any, or where those modes count from (0 and 1).
+//    For link_header it is the URL of the first page.
+func firstPageValue(apiRequest generic_structs.ApiRequest) interface{} {
+	paging := apiRequest.Settings.Paging
+	var start float64
+	switch paging["indicator_from_structure"] {
+	case "link_header":
+		return apiRequest.FullRequest.URL.String()
+	case "offset":
+		start = 0
+	case "page_number":
+		start = 1
+	default:
+		return (*interface{})(nil)
+	}
+
+	if value := apiRequest.FullRequest.URL.Query().Get(paging["indicator_to_field"]); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return parsed
+		}
+		utils.LogWarning("firstPageValue", "Ignoring non-numeric starting "+paging["indicator_to_field"], value)
+	}
+
+	return start
+}
+
+// Peeks at a response for the next page value.  Paging modes with a built-in
+//    peek use it, while the rest go through the plugin's peek function - or
+//    the JSON one if the body was decoded from a response_format.
+// Vars:
+// paging          = The endpoint's paging settings.
+// decoded         = Whether the body was decoded from a response_format.
+// response        = The response body.
+// responseHeaders = The JSON marshaled response headers.
+// responseKeys    = The split list of keys to find the paging value.
+// oldPageValue    = The previous page value.
+// pagingParams    = Plugin-specific paging params.
+func peekPage(paging map[string]string, decoded bool, response []byte, responseHeaders []byte, responseKeys []string, oldPageValue interface{}, pagingParams []string, PluginPagingPeekFunction **func([]uint8, []string, interface{}, []string) (interface{}, bool)) (interface{}, bool) {
+	switch paging["indicator_from_structure"] {
+	case "link_header":
+		return utils.LinkHeaderPagingPeek(responseHeaders, responseKeys, oldPageValue, pagingParams)
+	case "offset":
+		return utils.OffsetPagingPeek(response, responseKeys, oldPageValue, []string{paging["page_size"]})
+	case "page_number":
+		return utils.PageNumberPagingPeek(response, responseKeys, oldPageValue, []string{paging["page_size"]})
+	}
+
+	if decoded && paging["location_from"] != "header" {
+		return utils.DefaultJsonPagingPeek(response, responseKeys, oldPageValue, pagingParams)
+	}
+	var pagingData reflect.Value
+	if paging["location_from"] == "header" {
+		pagingData = reflect.ValueOf(responseHeaders)
+	} else { // Default: response body.
+		pagingData = reflect.ValueOf(response)
+	}
+
+	var finalPeekValueList []reflect.Value
+	finalPeekValueList = append(
+		finalPeekValueList, pagingData,
+		reflect.ValueOf(responseKeys),
+		reflect.ValueOf(oldPageValue),
+		reflect.ValueOf(pagingParams))
+	peekValue := reflect.ValueOf(**PluginPagingPeekFunction).Call(finalPeekValueList)
+	return peekValue[0].Interface(), peekValue[1].Bool()
+}
+
+// Follows Relay pageInfo paging for a GraphQL endpoint, adding each further
+//    page to the response list and returning them for the sub-endpoints,
+//    along with why paging was cut short if the paging guard stopped it, or
+//    "request_failed" if a page couldn't be fetched.  Paging also stops when
+//    hasNextPage is false.
+func runGraphqlPages(ep generic_structs.ApiEndpoint, apiRequest generic_structs.ApiRequest, variables map[string]interface{}, keySet map[string]string, response []byte, responseList map[generic_structs.ComparableApiRequest][]byte, pagingGuard *utils.PagingGuard, authParams []string, PluginAuthFunction **func(generic_structs.ApiRequest, []string) generic_structs.ApiRequest) ([][]byte, string) {
+	cursorVariable := ep.Graphql.CursorVariable
+	if cursorVariable == "" {
+		cursorVariable = utils.DefaultGraphqlCursorVariable
+	}
+	if cursor, ok := variables[cursorVariable]; ok {
+		pagingGuard.Seen(cursor)
+	}
+
+	var pages [][]byte
+	for {
+		if graphqlErrors := utils.GraphqlErrors(response); len(graphqlErrors) > 0 {
+			utils.LogWarning("runGraphqlPages", "["+ep.Name+"]", "GraphQL errors returned", strings.Join(graphqlErrors, "; "))
+		}
+		hasNextPage, endCursor := utils.GraphqlPageInfo(response, ep.Graphql.PageInfo)
+		if !hasNextPage {
+			return pages, ""
+		}
+		if stopped := pagingGuard.Stop(endCursor); stopped != "" {
+			utils.LogWarning("runGraphqlPages", "["+ep.Name+"]", pagingGuard.Describe(stopped))
+			return pages, stopped
+		}
+		variables[cursorVariable] = endCursor
+
+		nextApiRequest := apiRequest
+		nextApiRequest.FullRequest = apiRequest.FullRequest.Clone(apiRequest.FullRequest.Context())
+		if err := utils.SetGraphqlBody(nextApiRequest.FullRequest, ep.Graphql, variables); err != nil {
+			utils.LogError("runGraphqlPages", "Error creating GraphQL request body", err)
+			return pages, "request_failed"
+		}
+
+		nextApiRequest.Time = time.Now()
+		statusCode, nextResponse, _, err := authAndRunApiRequest(nextApiRequest, authParams, PluginAuthFunction)
+		if statusCode < 200 || statusCode > 299 {
+			utils.LogWarning("runGraphqlPages", "["+ep.Name+"]", fmt.Sprintf("Expected new response status 2xx, got %d", statusCode))
+			utils.AddRequestFailure(keySet, apiRequest.FullRequest.URL.String(), statusCode, nextResponse, err)
+			return pages, "request_failed"
+		}
+
+		comRequest := nextApiRequest.ToComparableApiRequest()
+		comRequest.Uuid = keySet["api_call_uuid"]
+		comRequest.Url = utils.RedactUrl(nextApiRequest.FullRequest.URL.String())
+		comRequest.Page = len(pages) + 1
+		if ep.Return != "false" {
+			responseList[comRequest] = append(responseList[comRequest], nextResponse...)
+		}
+		pages = append(pages, nextResponse)
+		pagingGuard.AddPage(nextResponse)
+		response = nextResponse
+	}
+}
+
+// Passes a response through the plugin's ResponseToJson function, unless it
+//    was decoded from the endpoint's response_format already.
+// Vars:
+// keySet   = The key set of the call the response is for.
+// response = The response body.
+func responseToJson(keySet map[string]string, response []byte, PluginResponseToJsonFunction **func(map[string]string, []byte) []byte) []byte {
+	if keySet["response_format"] != "" {
+		return response
+	}
+
+	return reflect.ValueOf(**PluginResponseToJsonFunction).Call([]reflect.Value{reflect.ValueOf(keySet), reflect.ValueOf(response)})[0].Bytes()
+}
+
+// Decodes a response in the endpoint's response_format to JSON, recording a
+//    failure in the key set if it can't be.
+func decodeEndpointResponse(ep generic_structs.ApiEndpoint, keySet map[string]string, requestUrl string, statusCode int, response []byte) ([]byte, error) {
+	decoded, err := utils.DecodeResponse(response, ep.ResponseFormat, ep.ResponseDecoding)
+	if err != nil {
+		utils.LogError("runThroughEndpoints", "["+ep.Name+"]", "Unable to decode "+ep.ResponseFormat+" response", err)
+		utils.AddRequestFailure(keySet, requestUrl, statusCode, response, err)
+		return response, err
+	}
+
+	return decoded, nil
+}
+
+// Queries a fan_out regions_from endpoint for the regions to run an API root
+//    in, authenticating as the given account.
+func discoverFanOutRegions(regionsFrom generic_structs.FanOutRegionsFrom, account generic_structs.FanOutAccount, rootSettingsData generic_structs.ApiRequestInheritableSettings, PluginAuthFunction **func(generic_structs.ApiRequest, []string) generic_structs.ApiRequest, PluginResponseToJsonFunction **func(map[string]string, []byte) []byte, httpClient *http.Client) ([]string, error) {
+	if regionsFrom.Key == "" {
+		return nil, fmt.Errorf("regions_from requires a key")
+	}
+	region := regionsFrom.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	service := regionsFrom.Service
+	if service == "" {
+		service = "ec2"
+	}
+	vars := utils.FanOutCombination{
+		AccountId:  account.Id,
+		RoleArn:    account.RoleArn,
+		ExternalId: account.ExternalId,
+		Region:     region,
+	}.Vars()
+	vars["aws_service"] = service
+
+	request, err := http.NewRequest("GET", regionsFrom.Endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	apiRequest := generic_structs.ApiRequest{
+		Settings: generic_structs.ApiRequestInheritableSettings{
+			Name:            rootSettingsData.Name,
+			Vars:            vars,
+			SkipContentType: rootSettingsData.SkipContentType,
+			AuthTokenTtl:    rootSettingsData.AuthTokenTtl,
+		},
+		Endpoint:    regionsFrom.Endpoint,
+		FullRequest: request,
+		Client:      httpClient,
+		Time:        time.Now(),
+	}
+	statusCode, response, _, err := authAndRunApiRequest(apiRequest, rootSettingsData.AuthParams, PluginAuthFunction)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode < 200 || statusCode > 299 {
+		return nil, fmt.Errorf("regions_from returned status %d", statusCode)
+	}
+
+	jsonResponse := reflect.ValueOf(**PluginResponseToJsonFunction).Call([]reflect.Value{reflect.ValueOf(vars), reflect.ValueOf(response)})[0].Bytes()
+	var structure interface{}
+	if err := json.Unmarshal(jsonResponse, &structure); err != nil {
+		return nil, err
+	}
+
+	var regions []string
+	for _, value := range utils.ParseJsonSubStructure(strings.Split(regionsFrom.Key, "."), 0, structure) {
+		if regionName, ok := value.(string); ok {
+			regions = append(regions, regionName)
+		}
+	}
+
+	return regions, nil
+}
+
+// Runs each vars_from endpoint (paging as usual) and returns the values found
+//    at its key, using the values cached in the state store while fresh.
+func discoverVarsFrom(varsFrom map[string]generic_structs.VarsFrom, rootSettingsData generic_structs.ApiRequestInheritableSettings, PluginAuthFunction **func(generic_structs.ApiRequest, []string) generic_structs.ApiRequest, PluginResponseToJsonFunction **func(map[string]string, []byte) []byte, PluginPagingPeekFunction **func([]uint8, []string, interface{}, []string) (interface{}, bool), reporter dashboard_reporter.Reporter, pluginID int, httpClient *http.Client) (map[string][]string, error) {
+	discovered := make(map[string][]string)
+	for name, settings := range varsFrom {
+		if settings.Key == "" {
+			return nil, fmt.Errorf("vars_from %s requires a key", name)
+		}
+		var cacheTtl time.Duration
+		cacheKey := utils.VarsFromStateKey(rootSettingsData.Name, name, settings.Endpoint)
+		if settings.CacheTtl != "" {
+			var err error
+			if cacheTtl, err = time.ParseDuration(settings.CacheTtl); err != nil {
+				return nil, fmt.Errorf("vars_from %s has an invalid cache_ttl: %v", name, err)
+			}
+			if values, ok := utils.GetCachedVarsFrom(cacheKey, time.Now()); ok {
+				discovered[name] = values
+				continue
+			}
+		}
+
+		ep := settings.ApiEndpoint
+		if ep.Name == "" {
+			ep.Name = "vars_from_" + name
+		}
+		ep.Return = ""
+		ep.Endpoints = nil
+		responseList, jsonKeys := runThroughEndpoints([]generic_structs.ApiEndpoint{ep}, rootSettingsData, nil, PluginAuthFunction, PluginResponseToJsonFunction, PluginPagingPeekFunction, false, 0, false, reporter, pluginID, httpClient)
+		if len(responseList) == 0 {
+			return nil, fmt.Errorf("vars_from %s returned no responses", name)
+		}
+		// Part of the values would silently leave combinations out, so they're
+		//    neither used nor cached.
+		if failures := utils.RequestFailures(jsonKeys); len(failures) > 0 {
+			return nil, fmt.Errorf("vars_from %s failed: %s", name, failures[0].Error)
+		}
+		for _, keys := range jsonKeys {
+			if keys["partial_result"] != "" {
+				return nil, fmt.Errorf("vars_from %s only found part of its values: paging stopped (%s)", name, keys["partial_result"])
+			}
+		}
+
+		// Keep the values in page order.
+		requests := make([]generic_structs.ComparableApiRequest, 0, len(responseList))
+		for request := range responseList {
+			requests = append(requests, request)
+		}
+		sort.Slice(requests, func(i, j int) bool { return requests[i].Time.Before(requests[j].Time) })
+
+		values := []string{}
+		keySets := make(map[string]map[string]string)
+		for _, keys := range jsonKeys {
+			keySets[keys["api_call_uuid"]] = keys
+		}
+		seen := make(map[string]bool)
+		for _, request := range requests {
+			jsonResponse := responseToJson(keySets[request.Uuid], responseList[request], PluginResponseToJsonFunction)
+			pageValues, err := utils.VarsFromValues(jsonResponse, settings.Key)
+			if err != nil {
+				return nil, fmt.Errorf("vars_from %s: %v", name, err)
+			}
+			for _, value := range pageValues {
+				if !seen[value] {
+					seen[value] = true
+					values = append(values, value)
+				}
+			}
+		}
+		utils.LogInfo("discoverVarsFrom", fmt.Sprintf("[%s] Found %d values", name, len(values)))
+
+		if cacheTtl > 0 {
+			if err := utils.SetCachedVarsFrom(cacheKey, values, time.Now().Add(cacheTtl)); err != nil {
+				utils.LogWarning("discoverVarsFrom", "["+name+"]", "Unable to cache vars_from values", err)
+			}
+		}
+		discovered[name] = values
+	}
+
+	return discovered, nil
+}
+
+// Passes the request through the plugin auth function and runs it.  If the
+//    API rejects a cached token with a 401, the token is dropped and we
+//    authenticate and run the request once more before giving up.
+func authAndRunApiRequest(apiRequest generic_structs.ApiRequest, authParams []string, PluginAuthFunction **func(generic_structs.ApiRequest, []string) generic_structs.ApiRequest) (int, []byte, []byte, error) {
+	authenticate := func() generic_structs.ApiRequest {
+		var requestValue []reflect.Value
+		requestValue = append(requestValue, reflect.ValueOf(apiRequest), reflect.ValueOf(authParams))
+		finalRequest := reflect.ValueOf(**PluginAuthFunction).Call(requestValue)
+		return finalRequest[0].Interface().(generic_structs.ApiRequest)
+	}
+
+	finalRequest := authenticate()
+	if finalRequest.FullRequest == nil {
+		utils.LogError("authAndRunApiRequest", "Auth function did not return a request", apiRequest.Endpoint)
+		return http.StatusUnauthorized, []byte("[]"), []byte("[]"), errors.New("auth function did not return a request")
+	}
+	statusCode, response, responseHeaders, err := runApiRequest(finalRequest)
+	if statusCode == http.StatusUnauthorized && finalRequest.AuthCacheKey != "" {
+		utils.LogWarning("authAndRunApiRequest", "Cached token rejected - re-authenticating", apiRequest.Endpoint)
+		utils.InvalidateCachedToken(finalRequest.AuthCacheKey)
+		// The first attempt used up the body, so rewind it for the retry.
+		if apiRequest.FullRequest.GetBody != nil {
+			body, err := apiRequest.FullRequest.GetBody()
+			if err != nil {
+				utils.LogError("authAndRunApiRequest", "Unable to rewind request body", err)
+				return statusCode, response, responseHeaders, err
+			}
+			apiRequest.FullRequest.Body = body
+		}
+		finalRequest = authenticate()
+		if finalRequest.FullRequest == nil {
+			utils.LogError("authAndRunApiRequest", "Auth function did not return a request", apiRequest.Endpoint)
+			return http.StatusUnauthorized, []byte("[]"), []byte("[]"), errors.New("auth function did not return a request")
+		}
+		statusCode, response, responseHeaders, err = runApiRequest(finalRequest)
+	}
+
+	return statusCode, response, responseHeaders, err
+}
+
+func runApiRequest(apiRequest generic_structs.ApiRequest) (int, []byte, []byte, error) {
 	logRequest := os.Getenv("EPICO_LOG_REQUEST")
 	if logRequest == "true" {
 		utils.LogInfo("runApiRequest", "Request", apiRequest.FullRequest.Method + " " + apiRequest.FullRequest.URL.String())
@@ -814,13 +1490,17 @@ func runApiRequest(apiRequest generic_structs.ApiRequest) (int, []byte, []byte)
 
 	logRequestBody := os.Getenv("EPICO_LOG_REQUEST_BODY")
 	if logRequestBody == "true" {
-		body, _ := ioutil.ReadAll(apiRequest.FullRequest.Body)
+		var body []byte
+		if apiRequest.FullRequest.Body != nil {
+			body, _ = ioutil.ReadAll(apiRequest.FullRequest.Body)
+			apiRequest.FullRequest.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
 		utils.LogInfo("runApiRequest", "Request Body", string(body))
 	}
 
 	var client *http.Client
 	if apiRequest.Client == nil {
-		client = &http.Client{}
+		client = utils.DefaultHttpClient()
 	} else {
 		client = apiRequest.Client
 	}
@@ -828,7 +1508,7 @@ func runApiRequest(apiRequest generic_structs.ApiRequest) (int, []byte, []byte)
 	resp, err := client.Do(apiRequest.FullRequest)
 	if err != nil {
 		utils.LogError("runApiRequest", "Error running the request", err)
-		return 400, []byte("[]"), []byte("[]")
+		return 400, []byte("[]"), []byte("[]"), err
 	}
 	defer resp.Body.Close()
 	// TODO: Handle failed connections better / handle retry? Golang "Context"?
@@ -838,7 +1518,7 @@ func runApiRequest(apiRequest generic_structs.ApiRequest) (int, []byte, []byte)
 	headers, err := json.Marshal(resp.Header)
 	if err != nil {
 		utils.LogError("runApiRequest", "Error reading request headers", err)
-		return resp.StatusCode, []byte("[]"), []byte("[]")
+		return resp.StatusCode, []byte("[]"), []byte("[]"), err
 	}
 
 	logResponseHeaders := os.Getenv("EPICO_LOG_RESPONSE_HEADERS")
@@ -849,7 +1529,7 @@ func runApiRequest(apiRequest generic_structs.ApiRequest) (int, []byte, []byte)
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		utils.LogError("runApiRequest", "Error reading response body", err)
-		return resp.StatusCode, []byte("[]"), []byte("[]")
+		return resp.StatusCode, []byte("[]"), []byte("[]"), err
 	}
 	if resp.StatusCode == 204 && len(body) == 0 {
 		body = []byte("[]")
@@ -860,7 +1540,7 @@ func runApiRequest(apiRequest generic_structs.ApiRequest) (int, []byte, []byte)
 		utils.LogInfo("runApiRequest", "Response", string(body))
 	}
 
-	return resp.StatusCode, body, headers
+	return resp.StatusCode, body, headers, nil
 }
 
 func humanize(value string) (humanized string){
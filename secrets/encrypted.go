@@ -0,0 +1,244 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Key derivation settings for new encrypted secrets files.
+const (
+	EncryptedFileKdf        = "pbkdf2-sha256"
+	EncryptedFileIterations = 600000
+	encryptedFileSaltLength = 16
+	// Files with fewer iterations than this are refused.
+	minEncryptedFileIterations = 100000
+)
+
+// EncryptedFileProvider reads secrets from a local JSON file holding a header
+//    and a map of secret names to base64 encoded AES-256-GCM ciphertexts
+//    (nonce prepended).  The key is derived from the passphrase with
+//    PBKDF2-HMAC-SHA256 using the random salt and iteration count in the
+//    header.  Use EncryptSecretsFile to build the file.
+type EncryptedFileProvider struct {
+	// Location of the secrets file.  Defaults to EPICO_SECRETS_FILE.
+	Path string
+	// Passphrase used to decrypt entries.  Defaults to EPICO_SECRETS_KEY.
+	Passphrase string
+
+	mutex sync.Mutex
+	file  *encryptedFile
+	// Derived once, as derivation is deliberately slow.
+	key []byte
+}
+
+// The header of an encrypted secrets file, with what is needed to derive the
+//    key from the passphrase.
+type EncryptedFileHeader struct {
+	Kdf        string `json:"kdf"`
+	Salt       string `json:"salt"` // Base64 encoded
+	Iterations int    `json:"iterations"`
+}
+
+type encryptedFile struct {
+	Header  EncryptedFileHeader `json:"header"`
+	Secrets map[string]string   `json:"secrets"`
+}
+
+func (e *EncryptedFileProvider) GetSecret(path string) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.file == nil {
+		if err := e.load(); err != nil {
+			return "", err
+		}
+	}
+
+	encrypted, ok := e.file.Secrets[path]
+	if !ok {
+		return "", fmt.Errorf("Secret %s not found in encrypted secrets file", path)
+	}
+
+	if e.key == nil {
+		passphrase := e.Passphrase
+		if passphrase == "" {
+			passphrase = os.Getenv("EPICO_SECRETS_KEY")
+		}
+		if passphrase == "" {
+			return "", fmt.Errorf("No passphrase set for encrypted secrets file (EPICO_SECRETS_KEY)")
+		}
+		key, err := e.file.Header.Key(passphrase)
+		if err != nil {
+			return "", err
+		}
+		e.key = key
+	}
+
+	return DecryptSecret(e.key, encrypted)
+}
+
+func (e *EncryptedFileProvider) load() error {
+	path := e.Path
+	if path == "" {
+		path = os.Getenv("EPICO_SECRETS_FILE")
+	}
+	if path == "" {
+		return fmt.Errorf("No encrypted secrets file configured (EPICO_SECRETS_FILE)")
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	file := &encryptedFile{}
+	if err := json.Unmarshal(contents, file); err != nil {
+		return fmt.Errorf("Unable to parse encrypted secrets file: %s", err.Error())
+	}
+	if file.Header.Kdf == "" {
+		return fmt.Errorf("Encrypted secrets file has no header - rebuild it with EncryptSecretsFile")
+	}
+	e.file = file
+
+	return nil
+}
+
+// NewEncryptedFileHeader returns a header for a new encrypted secrets file,
+//    with a random salt.
+func NewEncryptedFileHeader() (EncryptedFileHeader, error) {
+	salt := make([]byte, encryptedFileSaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return EncryptedFileHeader{}, err
+	}
+
+	return EncryptedFileHeader{
+		Kdf:        EncryptedFileKdf,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Iterations: EncryptedFileIterations,
+	}, nil
+}
+
+// Key derives the file's AES-256 key from the passphrase.
+func (h EncryptedFileHeader) Key(passphrase string) ([]byte, error) {
+	if h.Kdf != EncryptedFileKdf {
+		return nil, fmt.Errorf("Unsupported encrypted secrets file kdf %q", h.Kdf)
+	}
+	if h.Iterations < minEncryptedFileIterations {
+		return nil, fmt.Errorf("Encrypted secrets file iterations must be at least %d", minEncryptedFileIterations)
+	}
+	salt, err := base64.StdEncoding.DecodeString(h.Salt)
+	if err != nil || len(salt) < encryptedFileSaltLength {
+		return nil, fmt.Errorf("Invalid encrypted secrets file salt")
+	}
+
+	return pbkdf2Sha256([]byte(passphrase), salt, h.Iterations, 32), nil
+}
+
+// EncryptSecretsFile builds the contents of an encrypted secrets file holding
+//    the secrets given, with a new random salt.
+func EncryptSecretsFile(passphrase string, secrets map[string]string) ([]byte, error) {
+	header, err := NewEncryptedFileHeader()
+	if err != nil {
+		return nil, err
+	}
+	key, err := header.Key(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	file := encryptedFile{Header: header, Secrets: make(map[string]string, len(secrets))}
+	for name, plaintext := range secrets {
+		if file.Secrets[name], err = EncryptSecret(key, plaintext); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// EncryptSecret encrypts a secret value with a key from
+//    EncryptedFileHeader.Key, for adding to an encrypted secrets file.
+func EncryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret.
+func DecryptSecret(key []byte, encrypted string) (string, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("Invalid encrypted secret encoding: %s", err.Error())
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("Encrypted secret is too short")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("Unable to decrypt secret: %s", err.Error())
+	}
+
+	return string(plaintext), nil
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// PBKDF2 (RFC 8018) with HMAC-SHA256, which the standard library only has from
+//    Go 1.24.
+func pbkdf2Sha256(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	key := make([]byte, 0, keyLength+prf.Size())
+	block := make([]byte, 4)
+	for blockIndex := uint32(1); len(key) < keyLength; blockIndex++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(block, blockIndex)
+		prf.Write(block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:keyLength]
+}
@@ -0,0 +1,35 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileProvider reads secrets from individual files, as mounted by Docker
+//    swarm (/run/secrets) or Kubernetes secret volumes.  A single trailing
+//    newline is stripped since most tooling writes one.
+type FileProvider struct {
+	// Directory relative paths are resolved from.  Defaults to the
+	//    EPICO_SECRETS_DIR environment variable, then the working directory.
+	BaseDir string
+}
+
+func (f FileProvider) GetSecret(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		baseDir := f.BaseDir
+		if baseDir == "" {
+			baseDir = os.Getenv("EPICO_SECRETS_DIR")
+		}
+		path = filepath.Join(baseDir, path)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimSuffix(string(contents), "\n")
+	return strings.TrimSuffix(secret, "\r"), nil
+}
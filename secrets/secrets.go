@@ -0,0 +1,152 @@
+// Package secrets resolves `{{secret:provider:path}}` references found in
+//    Epico configs and runtime params so credentials do not need to live in
+//    the YAML or be passed on the command line.
+//
+// Built-in providers:
+// env       = {{secret:env:VAR_NAME}} reads an environment variable.
+// file      = {{secret:file:/run/secrets/token}} reads a file (Docker/K8s
+//             secrets).  Relative paths are resolved from EPICO_SECRETS_DIR.
+// encrypted = {{secret:encrypted:name}} reads an entry from the local
+//             encrypted secrets file at EPICO_SECRETS_FILE, decrypted with
+//             the passphrase in EPICO_SECRETS_KEY.
+//
+// Other backends (Vault, cloud secret managers, etc) can be plugged in by
+//    implementing Provider and calling RegisterProvider.
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// Provider looks up the value of a secret by its provider-specific path.
+type Provider interface {
+	GetSecret(path string) (string, error)
+}
+
+// ProviderFunc allows a plain function to be used as a Provider - handy for
+//    stubbing out remote backends in tests.
+type ProviderFunc func(path string) (string, error)
+
+func (f ProviderFunc) GetSecret(path string) (string, error) {
+	return f(path)
+}
+
+var secretRegex = regexp.MustCompile(`{{secret:([^:}]+):([^}]+)}}`)
+
+var (
+	providersMutex sync.RWMutex
+	providers      = map[string]Provider{
+		"env":       EnvProvider{},
+		"file":      FileProvider{},
+		"encrypted": &EncryptedFileProvider{},
+	}
+)
+
+// RegisterProvider makes a provider available under the given name, replacing
+//    any provider previously registered with that name.
+func RegisterProvider(name string, provider Provider) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	providers[name] = provider
+}
+
+// GetProvider returns the provider registered under the given name.
+func GetProvider(name string) (Provider, bool) {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+	provider, ok := providers[name]
+	return provider, ok
+}
+
+// ContainsSecret reports whether the value holds any secret references.
+func ContainsSecret(value string) bool {
+	return secretRegex.MatchString(value)
+}
+
+// Resolve replaces every secret reference in the value with the secret it
+//    points to.
+func Resolve(value string) (string, error) {
+	return ResolveFunc(value, func(reference string, secret string) string {
+		return secret
+	})
+}
+
+// ResolveFunc replaces every secret reference in the value with what replace
+//    returns for it, given the reference and the secret it points to.
+func ResolveFunc(value string, replace func(reference string, secret string) string) (string, error) {
+	var resolveErr error
+	resolved := secretRegex.ReplaceAllStringFunc(value, func(match string) string {
+		if resolveErr != nil {
+			return match
+		}
+		parts := secretRegex.FindStringSubmatch(match)
+		provider, ok := GetProvider(parts[1])
+		if !ok {
+			resolveErr = fmt.Errorf("Unknown secrets provider %q", parts[1])
+			return match
+		}
+		secret, err := provider.GetSecret(parts[2])
+		if err != nil {
+			resolveErr = fmt.Errorf("Unable to resolve secret %s:%s: %s", parts[1], parts[2], err.Error())
+			return match
+		}
+		return replace(match, secret)
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+
+	return resolved, nil
+}
+
+// ResolveSlice returns a copy of the slice with all secret references
+//    resolved.
+func ResolveSlice(values []string) ([]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+	resolved := make([]string, len(values))
+	for i, v := range values {
+		secret, err := Resolve(v)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = secret
+	}
+
+	return resolved, nil
+}
+
+// ResolveParams resolves secret references in place for a header,
+//    querystring or body params map.
+func ResolveParams(params map[string][]string) error {
+	for k, v := range params {
+		for i, item := range v {
+			if !ContainsSecret(item) {
+				continue
+			}
+			secret, err := Resolve(item)
+			if err != nil {
+				return err
+			}
+			params[k][i] = secret
+		}
+	}
+
+	return nil
+}
+
+// EnvProvider reads secrets from environment variables.
+type EnvProvider struct{}
+
+func (EnvProvider) GetSecret(path string) (string, error) {
+	value, ok := os.LookupEnv(path)
+	if !ok {
+		return "", fmt.Errorf("Environment variable %s is not set", path)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,170 @@
+package secrets
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveEnv(t *testing.T) {
+	os.Setenv("EPICO_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("EPICO_TEST_SECRET")
+
+	resolved, err := Resolve("token {{secret:env:EPICO_TEST_SECRET}}")
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := "token hunter2", resolved; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	if _, err := Resolve("{{secret:env:EPICO_TEST_SECRET_MISSING}}"); err == nil {
+		t.Errorf("expect error for unset variable")
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epico-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "api_key"), []byte("abc123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := Resolve("{{secret:file:" + filepath.Join(dir, "api_key") + "}}")
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := "abc123", resolved; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	secret, err := FileProvider{BaseDir: dir}.GetSecret("api_key")
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := "abc123", secret; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+func TestEncryptedFileProvider(t *testing.T) {
+	contents, err := EncryptSecretsFile("passphrase", map[string]string{"db_password": "s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := ioutil.TempFile("", "epico-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(contents)
+	file.Close()
+
+	provider := &EncryptedFileProvider{Path: file.Name(), Passphrase: "passphrase"}
+	secret, err := provider.GetSecret("db_password")
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := "s3cr3t", secret; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	if _, err := provider.GetSecret("missing"); err == nil {
+		t.Errorf("expect error for missing entry")
+	}
+
+	wrongKey := &EncryptedFileProvider{Path: file.Name(), Passphrase: "wrong"}
+	if _, err := wrongKey.GetSecret("db_password"); err == nil {
+		t.Errorf("expect error for wrong passphrase")
+	}
+
+	// Each file gets its own salt.
+	otherContents, err := EncryptSecretsFile("passphrase", map[string]string{"db_password": "s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header, otherHeader struct{ Header EncryptedFileHeader }
+	json.Unmarshal(contents, &header)
+	json.Unmarshal(otherContents, &otherHeader)
+	if header.Header.Salt == otherHeader.Header.Salt || header.Header.Iterations != EncryptedFileIterations {
+		t.Errorf("expect a random salt and %d iterations, got %+v and %+v", EncryptedFileIterations, header.Header, otherHeader.Header)
+	}
+
+	// Files without a header, or with too few iterations, are refused.
+	for _, contents := range []string{
+		`{"db_password": "c2VjcmV0"}`,
+		`{"header": {"kdf": "pbkdf2-sha256", "salt": "` + header.Header.Salt + `", "iterations": 1}, "secrets": {"db_password": "c2VjcmV0"}}`,
+	} {
+		ioutil.WriteFile(file.Name(), []byte(contents), 0600)
+		if _, err := (&EncryptedFileProvider{Path: file.Name(), Passphrase: "passphrase"}).GetSecret("db_password"); err == nil {
+			t.Errorf("expect error for %s", contents)
+		}
+	}
+}
+
+func TestPbkdf2Sha256(t *testing.T) {
+	// RFC 7914 section 11.
+	expected := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if a := hex.EncodeToString(pbkdf2Sha256([]byte("passwd"), []byte("salt"), 1, 64)); a != expected {
+		t.Errorf("expect %v, got %v", expected, a)
+	}
+}
+
+func TestCustomProvider(t *testing.T) {
+	RegisterProvider("vault", ProviderFunc(func(path string) (string, error) {
+		if path == "secret/data/api#token" {
+			return "vault-token", nil
+		}
+		return "", errors.New("not found")
+	}))
+
+	resolved, err := ResolveSlice([]string{"Authorization", "Bearer {{secret:vault:secret/data/api#token}}"})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := "Bearer vault-token", resolved[1]; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	if _, err := Resolve("{{secret:unknown:foo}}"); err == nil {
+		t.Errorf("expect error for unknown provider")
+	}
+
+	params := map[string][]string{"api_key": {"{{secret:vault:secret/data/api#token}}", "plain"}}
+	if err := ResolveParams(params); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := "vault-token", params["api_key"][0]; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := "plain", params["api_key"][1]; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+func TestResolveFunc(t *testing.T) {
+	os.Setenv("EPICO_TEST_SECRET", "{{hunter2}}")
+	defer os.Unsetenv("EPICO_TEST_SECRET")
+
+	secrets := map[string]string{}
+	resolved, err := ResolveFunc("token {{secret:env:EPICO_TEST_SECRET}}", func(reference string, secret string) string {
+		secrets[reference] = secret
+		return "<ref>"
+	})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := "token <ref>", resolved; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := "{{hunter2}}", secrets["{{secret:env:EPICO_TEST_SECRET}}"]; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
@@ -0,0 +1,152 @@
+package v4
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// DefaultExpiryWindow is how long before expiry cached credentials are
+// refreshed, so a request isn't signed with credentials that lapse in flight.
+const DefaultExpiryWindow = 5 * time.Minute
+
+// CredentialsProvider supplies the credentials requests are signed with.
+// Retrieve returns the credentials and when they expire - a zero time means
+// they never do.
+type CredentialsProvider interface {
+	Retrieve() (generic_structs.ApiCredentials, time.Time, error)
+}
+
+// CredentialsCache wraps a provider and only calls it again when the cached
+// credentials are within ExpiryWindow of expiring. It is safe to share
+// between goroutines.
+type CredentialsCache struct {
+	Provider     CredentialsProvider
+	ExpiryWindow time.Duration
+
+	mutex       sync.Mutex
+	credentials generic_structs.ApiCredentials
+	expires     time.Time
+	retrieved   bool
+
+	// currentTimeFn returns the current time - only replaced in tests.
+	currentTimeFn func() time.Time
+}
+
+// NewCredentialsCache returns a CredentialsCache for the provider using the
+// DefaultExpiryWindow.
+func NewCredentialsCache(provider CredentialsProvider) *CredentialsCache {
+	return &CredentialsCache{Provider: provider, ExpiryWindow: DefaultExpiryWindow}
+}
+
+// Retrieve returns the cached credentials, refreshing them first if needed.
+func (c *CredentialsCache) Retrieve() (generic_structs.ApiCredentials, time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.retrieved && !c.expired() {
+		return c.credentials, c.expires, nil
+	}
+
+	credentials, expires, err := c.Provider.Retrieve()
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, err
+	}
+	c.credentials, c.expires, c.retrieved = credentials, expires, true
+
+	return credentials, expires, nil
+}
+
+// Expire forces the next Retrieve to go back to the provider.
+func (c *CredentialsCache) Expire() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.retrieved = false
+}
+
+func (c *CredentialsCache) expired() bool {
+	if c.expires.IsZero() {
+		return false
+	}
+	now := time.Now
+	if c.currentTimeFn != nil {
+		now = c.currentTimeFn
+	}
+
+	return !now().Add(c.ExpiryWindow).Before(c.expires)
+}
+
+// StaticProvider returns fixed credentials that never expire.
+type StaticProvider struct {
+	Value generic_structs.ApiCredentials
+}
+
+func (s StaticProvider) Retrieve() (generic_structs.ApiCredentials, time.Time, error) {
+	if s.Value.Id == "" || s.Value.Key == "" {
+		return generic_structs.ApiCredentials{}, time.Time{}, errors.New("static credentials are empty")
+	}
+
+	return s.Value, time.Time{}, nil
+}
+
+// EnvProvider reads credentials from the standard AWS environment variables -
+// AWS_ACCESS_KEY_ID (or AWS_ACCESS_KEY), AWS_SECRET_ACCESS_KEY (or
+// AWS_SECRET_KEY) and AWS_SESSION_TOKEN.
+type EnvProvider struct{}
+
+func (EnvProvider) Retrieve() (generic_structs.ApiCredentials, time.Time, error) {
+	credentials := generic_structs.ApiCredentials{
+		Id:    firstEnv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY"),
+		Key:   firstEnv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"),
+		Token: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.Id == "" || credentials.Key == "" {
+		return generic_structs.ApiCredentials{}, time.Time{}, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+	}
+
+	return credentials, time.Time{}, nil
+}
+
+// ChainProvider returns the credentials of the first provider that succeeds.
+type ChainProvider struct {
+	Providers []CredentialsProvider
+}
+
+func (c ChainProvider) Retrieve() (generic_structs.ApiCredentials, time.Time, error) {
+	var errs []string
+	for _, provider := range c.Providers {
+		credentials, expires, err := provider.Retrieve()
+		if err == nil {
+			return credentials, expires, nil
+		}
+		errs = append(errs, err.Error())
+	}
+
+	return generic_structs.ApiCredentials{}, time.Time{}, fmt.Errorf("no valid credentials found: %s", strings.Join(errs, "; "))
+}
+
+// NewDefaultCredentialsChain follows the usual AWS lookup order - environment
+// variables, a web identity token (AWS_WEB_IDENTITY_TOKEN_FILE and
+// AWS_ROLE_ARN) and then the shared config/credentials profile.
+func NewDefaultCredentialsChain() CredentialsProvider {
+	return NewCredentialsCache(ChainProvider{Providers: []CredentialsProvider{
+		EnvProvider{},
+		&WebIdentityProvider{},
+		&SharedConfigProvider{},
+	}})
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
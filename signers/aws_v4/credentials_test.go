@@ -0,0 +1,288 @@
+package v4
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// A local STS stub.  AssumeRole must be signed by SOURCEKEY with the expected
+// external ID, and web identity calls must carry the expected token.
+type stsStub struct {
+	*httptest.Server
+	calls      int32
+	expiration time.Time
+}
+
+func newStsStub(t *testing.T) *stsStub {
+	stub := &stsStub{expiration: time.Now().Add(time.Hour).UTC().Truncate(time.Second)}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := atomic.AddInt32(&stub.calls, 1)
+		r.ParseForm()
+
+		switch r.Form.Get("Action") {
+		case "AssumeRole":
+			if !strings.Contains(r.Header.Get("Authorization"), "Credential=SOURCEKEY/") {
+				stsError(w, "SignatureDoesNotMatch", "not signed with the source credentials")
+				return
+			}
+			if r.Form.Get("ExternalId") != "ext-123" {
+				stsError(w, "AccessDenied", "external ID mismatch")
+				return
+			}
+			fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <AssumedRoleUser><Arn>%s/session</Arn></AssumedRoleUser>
+    <Credentials>
+      <AccessKeyId>ROLEKEY%d</AccessKeyId>
+      <SecretAccessKey>ROLESECRET</SecretAccessKey>
+      <SessionToken>ROLETOKEN</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`, r.Form.Get("RoleArn"), call, stub.expiration.Format(time.RFC3339))
+		case "AssumeRoleWithWebIdentity":
+			if r.Form.Get("WebIdentityToken") != "oidc-token" {
+				stsError(w, "InvalidIdentityToken", "bad token")
+				return
+			}
+			fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>WEBKEY</AccessKeyId>
+      <SecretAccessKey>WEBSECRET</SecretAccessKey>
+      <SessionToken>WEBTOKEN</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, stub.expiration.Format(time.RFC3339))
+		default:
+			stsError(w, "InvalidAction", r.Form.Get("Action"))
+		}
+	}))
+
+	return stub
+}
+
+func stsError(w http.ResponseWriter, code, message string) {
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error></ErrorResponse>`, code, message)
+}
+
+var sourceCredentials = StaticProvider{Value: generic_structs.ApiCredentials{Id: "SOURCEKEY", Key: "SOURCESECRET"}}
+
+func TestAssumeRoleProvider(t *testing.T) {
+	stub := newStsStub(t)
+	defer stub.Close()
+
+	provider := &AssumeRoleProvider{
+		Source:     sourceCredentials,
+		RoleArn:    "arn:aws:iam::123456789012:role/audit",
+		ExternalId: "ext-123",
+		Endpoint:   stub.URL,
+	}
+	credentials, expires, err := provider.Retrieve()
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := (generic_structs.ApiCredentials{Id: "ROLEKEY1", Key: "ROLESECRET", Token: "ROLETOKEN"}), credentials; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if !expires.Equal(stub.expiration) {
+		t.Errorf("expect expiry %v, got %v", stub.expiration, expires)
+	}
+
+	provider.ExternalId = "wrong"
+	if _, _, err := provider.Retrieve(); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("expect AccessDenied error, got %v", err)
+	}
+}
+
+func TestCredentialsCacheRefreshesBeforeExpiry(t *testing.T) {
+	stub := newStsStub(t)
+	defer stub.Close()
+
+	now := time.Now()
+	cache := NewCredentialsCache(&AssumeRoleProvider{
+		Source:     sourceCredentials,
+		RoleArn:    "arn:aws:iam::123456789012:role/audit",
+		ExternalId: "ext-123",
+		Endpoint:   stub.URL,
+	})
+	cache.currentTimeFn = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		credentials, _, err := cache.Retrieve()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, a := "ROLEKEY1", credentials.Id; e != a {
+			t.Errorf("expect cached %v, got %v", e, a)
+		}
+	}
+
+	// Inside the expiry window, but before the credentials actually expire.
+	now = stub.expiration.Add(-DefaultExpiryWindow + time.Second)
+	credentials, _, err := cache.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "ROLEKEY2", credentials.Id; e != a {
+		t.Errorf("expect refreshed %v, got %v", e, a)
+	}
+	if e, a := int32(2), atomic.LoadInt32(&stub.calls); e != a {
+		t.Errorf("expect %v STS calls, got %v", e, a)
+	}
+}
+
+func TestWebIdentityProvider(t *testing.T) {
+	stub := newStsStub(t)
+	defer stub.Close()
+
+	dir, err := ioutil.TempDir("", "epico-aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	ioutil.WriteFile(tokenFile, []byte("oidc-token\n"), 0600)
+
+	provider := &WebIdentityProvider{RoleArn: "arn:aws:iam::123456789012:role/irsa", TokenFile: tokenFile, Endpoint: stub.URL}
+	credentials, _, err := provider.Retrieve()
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := (generic_structs.ApiCredentials{Id: "WEBKEY", Key: "WEBSECRET", Token: "WEBTOKEN"}), credentials; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	for k, v := range map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY", "AWS_SECRET_ACCESS_KEY": "ENVSECRET", "AWS_SESSION_TOKEN": "ENVTOKEN"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	credentials, _, err := EnvProvider{}.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := (generic_structs.ApiCredentials{Id: "ENVKEY", Key: "ENVSECRET", Token: "ENVTOKEN"}), credentials; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, _, err := (EnvProvider{}).Retrieve(); err == nil {
+		t.Errorf("expect error without a secret key")
+	}
+}
+
+func TestSharedConfigProvider(t *testing.T) {
+	stub := newStsStub(t)
+	defer stub.Close()
+
+	dir, err := ioutil.TempDir("", "epico-aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	ioutil.WriteFile(credentialsFile, []byte(`
+[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = DEFAULTSECRET
+
+[source]
+aws_access_key_id = SOURCEKEY
+aws_secret_access_key = SOURCESECRET
+`), 0600)
+	ioutil.WriteFile(configFile, []byte(`
+[default]
+region = us-east-1
+s3 =
+  max_concurrent_requests = 10
+
+[profile audit]
+role_arn = arn:aws:iam::123456789012:role/audit
+source_profile = source
+external_id = ext-123
+
+[profile loop]
+role_arn = arn:aws:iam::123456789012:role/loop
+source_profile = loop
+`), 0600)
+
+	provider := &SharedConfigProvider{CredentialsFile: credentialsFile, ConfigFile: configFile, StsEndpoint: stub.URL}
+	credentials, _, err := provider.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "DEFAULTKEY", credentials.Id; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	provider.Profile = "audit"
+	credentials, _, err = provider.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "ROLEKEY1", credentials.Id; e != a {
+		t.Errorf("expect assumed role %v, got %v", e, a)
+	}
+
+	provider.Profile = "loop"
+	if _, _, err := provider.Retrieve(); err == nil {
+		t.Errorf("expect error for a looping source_profile")
+	}
+	provider.Profile = "missing"
+	if _, _, err := provider.Retrieve(); err == nil {
+		t.Errorf("expect error for a missing profile")
+	}
+}
+
+func TestChainProvider(t *testing.T) {
+	chain := ChainProvider{Providers: []CredentialsProvider{StaticProvider{}, sourceCredentials}}
+	credentials, _, err := chain.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "SOURCEKEY", credentials.Id; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	if _, _, err := (ChainProvider{Providers: []CredentialsProvider{StaticProvider{}}}).Retrieve(); err == nil {
+		t.Errorf("expect error when no provider succeeds")
+	}
+}
+
+func TestSignWithCredentialsProvider(t *testing.T) {
+	req, body := buildRequest("dynamodb", "us-east-1", "{}")
+	signer := NewSigner(generic_structs.ApiCredentials{}, WithCredentialsProvider(StaticProvider{
+		Value: generic_structs.ApiCredentials{Id: "AKID", Key: "SECRET", Token: "SESSION"},
+	}))
+	if _, err := signer.Sign(req, body, "dynamodb", "us-east-1", time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same vector as TestSignRequest.
+	if a := req.Header.Get("Authorization"); !strings.HasSuffix(a, "Signature=a518299330494908a70222cec6899f6f32f297f8595f6df1776d998936652ad9") {
+		t.Errorf("expect provider credentials to be used, got %v", a)
+	}
+
+	req, body = buildRequest("dynamodb", "us-east-1", "{}")
+	signer = NewSigner(generic_structs.ApiCredentials{}, WithCredentialsProvider(StaticProvider{}))
+	if _, err := signer.Sign(req, body, "dynamodb", "us-east-1", time.Unix(0, 0)); err == nil {
+		t.Errorf("expect provider errors to be returned")
+	}
+}
@@ -5,3 +5,32 @@ package v4
 func WithUnsignedPayload(v4 *Signer) {
 	v4.UnsignedPayload = true
 }
+
+// WithCredentialsProvider sets the provider credentials are retrieved from
+// each time a request is signed.
+func WithCredentialsProvider(provider CredentialsProvider) func(*Signer) {
+	return func(v4 *Signer) {
+		v4.CredentialsProvider = provider
+	}
+}
+
+// WithSigV4a will enable and set the SigV4a field to true of the signer.
+func WithSigV4a(v4 *Signer) {
+	v4.SigV4a = true
+}
+
+// ServiceOptions returns the signer options the named service expects, to be
+// passed to NewSigner. S3 signs the URI path exactly as sent rather than
+// escaping it a second time, and accepts UNSIGNED-PAYLOAD so object bodies
+// aren't read twice to be hashed. Other services use the defaults.
+func ServiceOptions(service string) []func(*Signer) {
+	switch service {
+	case "s3", "s3-object-lambda", "s3-outposts", "s3express":
+		return []func(*Signer){
+			func(v4 *Signer) { v4.DisableURIPathEscaping = true },
+			WithUnsignedPayload,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package v4
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// Limits how far source_profile chains are followed, which also stops loops.
+const maxSourceProfileDepth = 5
+
+// SharedConfigProvider reads a profile from the shared credentials and config
+// files (~/.aws/credentials and ~/.aws/config). Profiles with a role_arn
+// assume that role using their source_profile or web_identity_token_file,
+// passing along any external_id. Blank fields fall back to
+// AWS_SHARED_CREDENTIALS_FILE, AWS_CONFIG_FILE and AWS_PROFILE.
+type SharedConfigProvider struct {
+	CredentialsFile string
+	ConfigFile      string
+	Profile         string
+
+	// Used for any STS calls made for role profiles.
+	StsEndpoint string
+	Client      *http.Client
+}
+
+func (s *SharedConfigProvider) Retrieve() (generic_structs.ApiCredentials, time.Time, error) {
+	profile := s.Profile
+	if profile == "" {
+		profile = firstEnv("AWS_PROFILE", "AWS_DEFAULT_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	profiles, err := s.loadProfiles()
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, err
+	}
+	provider, err := s.profileProvider(profiles, profile, 0)
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, err
+	}
+
+	return provider.Retrieve()
+}
+
+// Builds the provider for a profile, following source_profile for roles.
+func (s *SharedConfigProvider) profileProvider(profiles map[string]map[string]string, profile string, depth int) (CredentialsProvider, error) {
+	if depth > maxSourceProfileDepth {
+		return nil, fmt.Errorf("source_profile chain from %s is too deep or loops", profile)
+	}
+	values, ok := profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found in the shared config", profile)
+	}
+
+	if values["role_arn"] == "" {
+		return StaticProvider{Value: generic_structs.ApiCredentials{
+			Id:    values["aws_access_key_id"],
+			Key:   values["aws_secret_access_key"],
+			Token: values["aws_session_token"],
+		}}, nil
+	}
+
+	var duration time.Duration
+	if seconds, err := strconv.Atoi(values["duration_seconds"]); err == nil {
+		duration = time.Duration(seconds) * time.Second
+	}
+
+	if tokenFile := values["web_identity_token_file"]; tokenFile != "" {
+		return &WebIdentityProvider{
+			RoleArn:     values["role_arn"],
+			TokenFile:   tokenFile,
+			SessionName: values["role_session_name"],
+			Duration:    duration,
+			Region:      values["region"],
+			Endpoint:    s.StsEndpoint,
+			Client:      s.Client,
+		}, nil
+	}
+
+	if values["source_profile"] == "" {
+		return nil, fmt.Errorf("profile %s has a role_arn but no source_profile or web_identity_token_file", profile)
+	}
+	source, err := s.profileProvider(profiles, values["source_profile"], depth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AssumeRoleProvider{
+		Source:      source,
+		RoleArn:     values["role_arn"],
+		ExternalId:  values["external_id"],
+		SessionName: values["role_session_name"],
+		Duration:    duration,
+		Region:      values["region"],
+		Endpoint:    s.StsEndpoint,
+		Client:      s.Client,
+	}, nil
+}
+
+// Merges both files into profile name => key => value.  Values in the
+// credentials file win over the config file, as they do for the AWS CLI.
+func (s *SharedConfigProvider) loadProfiles() (map[string]map[string]string, error) {
+	home, _ := os.UserHomeDir()
+	configFile := s.ConfigFile
+	if configFile == "" {
+		configFile = firstEnv("AWS_CONFIG_FILE")
+	}
+	if configFile == "" && home != "" {
+		configFile = filepath.Join(home, ".aws", "config")
+	}
+	credentialsFile := s.CredentialsFile
+	if credentialsFile == "" {
+		credentialsFile = firstEnv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if credentialsFile == "" && home != "" {
+		credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profiles := make(map[string]map[string]string)
+	found := false
+	for _, file := range []struct {
+		path     string
+		isConfig bool
+	}{{configFile, true}, {credentialsFile, false}} {
+		if file.path == "" {
+			continue
+		}
+		loaded, err := parseIniFile(file.path, file.isConfig)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = true
+		for profile, values := range loaded {
+			if profiles[profile] == nil {
+				profiles[profile] = make(map[string]string)
+			}
+			for k, v := range values {
+				profiles[profile][k] = v
+			}
+		}
+	}
+	if !found {
+		return nil, errors.New("no shared config or credentials file found")
+	}
+
+	return profiles, nil
+}
+
+// Parses the INI subset used by the AWS shared files.  Config file sections
+// are named "profile <name>" except for "default".
+func parseIniFile(path string, isConfig bool) (map[string]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	profiles := make(map[string]map[string]string)
+	var current map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rawLine := scanner.Text()
+		line := strings.TrimSpace(rawLine)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.TrimLeft(rawLine, " \t") != rawLine {
+			// Indented lines belong to nested settings (e.g. under s3 =),
+			// which don't affect credentials.
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if isConfig {
+				name = strings.TrimSpace(strings.TrimPrefix(name, "profile "))
+			}
+			if profiles[name] == nil {
+				profiles[name] = make(map[string]string)
+			}
+			current = profiles[name]
+			continue
+		}
+		separator := strings.Index(line, "=")
+		if separator < 0 || current == nil {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:separator]))
+		current[key] = strings.TrimSpace(line[separator+1:])
+	}
+
+	return profiles, scanner.Err()
+}
@@ -0,0 +1,156 @@
+package v4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// streamingPayload is the payload hash for S3 uploads signed chunk by
+	// chunk.
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+
+	// streamingChunkAlgorithm starts the string to sign for each chunk.
+	streamingChunkAlgorithm = "AWS4-HMAC-SHA256-PAYLOAD"
+
+	// DefaultChunkSize is the chunk size used by SignStreaming when none is
+	// given. S3 requires chunks of at least 8KB, other than the last.
+	DefaultChunkSize = 64 * 1024
+
+	// chunk-signature= plus the hex signature.
+	chunkSignatureLength = 17 + 64
+)
+
+// SignStreaming signs an S3 upload whose payload is sent in aws-chunked
+// encoding with STREAMING-AWS4-HMAC-SHA256-PAYLOAD, so the body can be
+// streamed instead of hashed up front. The headers are signed as usual to
+// give the seed signature, then the request's Body is replaced with a reader
+// that frames and signs each chunk, chaining from the previous signature.
+//
+// decodedLength must be the exact length of body. The request's Content-Length
+// is set to the length of the encoded payload.
+func (v4 Signer) SignStreaming(r *http.Request, body io.Reader, decodedLength int64, chunkSize int, service, region string, signTime time.Time) (http.Header, error) {
+	if v4.SigV4a {
+		return nil, fmt.Errorf("streaming payloads can't be signed with SigV4a")
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	encodedLength := ChunkedContentLength(decodedLength, int64(chunkSize))
+	contentEncoding := "aws-chunked"
+	if existing := r.Header.Get("Content-Encoding"); existing != "" {
+		contentEncoding += "," + existing
+	}
+	r.Header.Set("Content-Encoding", contentEncoding)
+	r.Header.Set("Content-Length", strconv.FormatInt(encodedLength, 10))
+	r.Header.Set("X-Amz-Content-Sha256", streamingPayload)
+	r.Header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(decodedLength, 10))
+
+	v4.DisableRequestBodyOverwrite = true
+	ctx, err := v4.sign(r, nil, service, region, 0, false, signTime)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = ioutil.NopCloser(&chunkedPayloadReader{
+		source:        body,
+		chunk:         make([]byte, chunkSize),
+		signingKey:    ctx.signingKey(),
+		scope:         ctx.formattedTime + "\n" + ctx.credentialString,
+		prevSignature: ctx.signature,
+		remaining:     decodedLength,
+	})
+	r.GetBody = nil
+	r.ContentLength = encodedLength
+
+	return ctx.SignedHeaderVals, nil
+}
+
+// ChunkedContentLength returns the length of a payload of decodedLength bytes
+// once it is split into chunks of chunkSize and framed with chunk signatures,
+// including the final empty chunk.
+func ChunkedContentLength(decodedLength, chunkSize int64) int64 {
+	length := int64(0)
+	if full := decodedLength / chunkSize; full > 0 {
+		length += full * chunkFrameLength(chunkSize)
+	}
+	if rem := decodedLength % chunkSize; rem > 0 {
+		length += chunkFrameLength(rem)
+	}
+
+	return length + chunkFrameLength(0)
+}
+
+// chunkFrameLength is the length of one framed chunk:
+// hex(size);chunk-signature=<signature>\r\n<data>\r\n
+func chunkFrameLength(size int64) int64 {
+	return int64(len(strconv.FormatInt(size, 16))) + chunkSignatureLength + 2 + size + 2
+}
+
+// chunkedPayloadReader reads the source in chunks and returns each one
+// framed with its signature, followed by the final empty chunk.
+type chunkedPayloadReader struct {
+	source        io.Reader
+	chunk         []byte
+	signingKey    []byte
+	scope         string
+	prevSignature string
+	remaining     int64
+
+	framed bytes.Buffer
+	done   bool
+}
+
+func (c *chunkedPayloadReader) Read(p []byte) (int, error) {
+	for c.framed.Len() == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.framed.Read(p)
+}
+
+func (c *chunkedPayloadReader) nextChunk() error {
+	n, err := io.ReadFull(c.source, c.chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	c.remaining -= int64(n)
+	if c.remaining < 0 || (n == 0 && c.remaining != 0) {
+		return fmt.Errorf("streaming payload doesn't match its decoded content length")
+	}
+
+	data := c.chunk[:n]
+	signature := c.chunkSignature(data)
+	c.framed.WriteString(strconv.FormatInt(int64(n), 16) + ";chunk-signature=" + signature + "\r\n")
+	c.framed.Write(data)
+	c.framed.WriteString("\r\n")
+	c.prevSignature = signature
+	c.done = n == 0
+
+	return nil
+}
+
+func (c *chunkedPayloadReader) chunkSignature(data []byte) string {
+	stringToSign := strings.Join([]string{
+		streamingChunkAlgorithm,
+		c.scope,
+		c.prevSignature,
+		emptyStringSHA256,
+		hex.EncodeToString(makeSha256(data)),
+	}, "\n")
+
+	return hex.EncodeToString(makeHmac(c.signingKey, []byte(stringToSign)))
+}
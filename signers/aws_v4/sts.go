@@ -0,0 +1,228 @@
+package v4
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+const (
+	stsVersion            = "2011-06-15"
+	defaultStsRegion      = "us-east-1"
+	defaultRoleDuration   = 15 * time.Minute
+	defaultStsHttpTimeout = 30 * time.Second
+)
+
+// AssumeRoleProvider exchanges the Source credentials for temporary role
+// credentials with STS AssumeRole. Wrap it in a CredentialsCache so the role
+// is only assumed again as the credentials near expiry.
+type AssumeRoleProvider struct {
+	Source      CredentialsProvider
+	RoleArn     string
+	ExternalId  string        // Optional - required by most third-party roles
+	SessionName string        // Optional - defaults to epico-<unix time>
+	Duration    time.Duration // Optional - defaults to 15 minutes
+
+	// Region of the STS endpoint, defaulting to the global us-east-1
+	// endpoint. Endpoint overrides the URL entirely.
+	Region   string
+	Endpoint string
+	Client   *http.Client
+}
+
+func (a *AssumeRoleProvider) Retrieve() (generic_structs.ApiCredentials, time.Time, error) {
+	if a.Source == nil || a.RoleArn == "" {
+		return generic_structs.ApiCredentials{}, time.Time{}, errors.New("AssumeRole requires source credentials and a role ARN")
+	}
+	sourceCredentials, _, err := a.Source.Retrieve()
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, fmt.Errorf("unable to retrieve source credentials: %s", err.Error())
+	}
+
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {stsVersion},
+		"RoleArn":         {a.RoleArn},
+		"RoleSessionName": {sessionName(a.SessionName)},
+		"DurationSeconds": {durationSeconds(a.Duration)},
+	}
+	if a.ExternalId != "" {
+		params.Set("ExternalId", a.ExternalId)
+	}
+
+	region := a.Region
+	if region == "" {
+		region = defaultStsRegion
+	}
+	req, err := newStsRequest(stsEndpoint(a.Endpoint, a.Region), params)
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, err
+	}
+	body := strings.NewReader(params.Encode())
+	if _, err := NewSigner(sourceCredentials).Sign(req, body, "sts", region, time.Now()); err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, err
+	}
+
+	return doStsRequest(a.Client, req)
+}
+
+// WebIdentityProvider exchanges an OIDC token file (as mounted by EKS/IRSA
+// and similar) for role credentials with STS AssumeRoleWithWebIdentity. Blank
+// fields fall back to AWS_ROLE_ARN, AWS_WEB_IDENTITY_TOKEN_FILE and
+// AWS_ROLE_SESSION_NAME.
+type WebIdentityProvider struct {
+	RoleArn     string
+	TokenFile   string
+	SessionName string
+	Duration    time.Duration
+
+	Region   string
+	Endpoint string
+	Client   *http.Client
+}
+
+func (w *WebIdentityProvider) Retrieve() (generic_structs.ApiCredentials, time.Time, error) {
+	roleArn := w.RoleArn
+	if roleArn == "" {
+		roleArn = os.Getenv("AWS_ROLE_ARN")
+	}
+	tokenFile := w.TokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if roleArn == "" || tokenFile == "" {
+		return generic_structs.ApiCredentials{}, time.Time{}, errors.New("web identity requires a role ARN and token file")
+	}
+	session := w.SessionName
+	if session == "" {
+		session = os.Getenv("AWS_ROLE_SESSION_NAME")
+	}
+
+	// Re-read on every call - the token is rotated on disk.
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, fmt.Errorf("unable to read web identity token: %s", err.Error())
+	}
+
+	params := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {stsVersion},
+		"RoleArn":          {roleArn},
+		"RoleSessionName":  {sessionName(session)},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+		"DurationSeconds":  {durationSeconds(w.Duration)},
+	}
+	// AssumeRoleWithWebIdentity is an unsigned call - the token is the proof.
+	req, err := newStsRequest(stsEndpoint(w.Endpoint, w.Region), params)
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, err
+	}
+
+	return doStsRequest(w.Client, req)
+}
+
+type stsCredentials struct {
+	AccessKeyId     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+// The AssumeRole and AssumeRoleWithWebIdentity responses only differ in the
+// name of the result element.
+type stsResponse struct {
+	AssumeRole        stsCredentials `xml:"AssumeRoleResult>Credentials"`
+	AssumeRoleWithWeb stsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+type stsErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func stsEndpoint(endpoint string, region string) string {
+	if endpoint != "" {
+		return endpoint
+	}
+	if region == "" {
+		return "https://sts.amazonaws.com/"
+	}
+
+	return "https://sts." + region + ".amazonaws.com/"
+}
+
+func newStsRequest(endpoint string, params url.Values) (*http.Request, error) {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	return req, nil
+}
+
+func doStsRequest(client *http.Client, req *http.Request) (generic_structs.ApiCredentials, time.Time, error) {
+	if client == nil {
+		client = &http.Client{Timeout: defaultStsHttpTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var stsError stsErrorResponse
+		if xml.Unmarshal(body, &stsError) == nil && stsError.Code != "" {
+			return generic_structs.ApiCredentials{}, time.Time{}, fmt.Errorf("STS %s: %s", stsError.Code, stsError.Message)
+		}
+		return generic_structs.ApiCredentials{}, time.Time{}, fmt.Errorf("STS returned %d", resp.StatusCode)
+	}
+
+	var response stsResponse
+	if err := xml.Unmarshal(body, &response); err != nil {
+		return generic_structs.ApiCredentials{}, time.Time{}, fmt.Errorf("unable to parse STS response: %s", err.Error())
+	}
+	credentials := response.AssumeRole
+	if credentials.AccessKeyId == "" {
+		credentials = response.AssumeRoleWithWeb
+	}
+	if credentials.AccessKeyId == "" || credentials.SecretAccessKey == "" {
+		return generic_structs.ApiCredentials{}, time.Time{}, errors.New("STS response did not include credentials")
+	}
+
+	return generic_structs.ApiCredentials{
+		Id:    credentials.AccessKeyId,
+		Key:   credentials.SecretAccessKey,
+		Token: credentials.SessionToken,
+	}, credentials.Expiration, nil
+}
+
+func sessionName(name string) string {
+	if name != "" {
+		return name
+	}
+
+	return "epico-" + strconv.FormatInt(time.Now().Unix(), 10)
+}
+
+func durationSeconds(duration time.Duration) string {
+	if duration <= 0 {
+		duration = defaultRoleDuration
+	}
+
+	return strconv.Itoa(int(duration / time.Second))
+}
@@ -158,6 +158,11 @@ type Signer struct {
     // This value must be set to sign requests.
     Credentials generic_structs.ApiCredentials
 
+    // Supplies the credentials at signing time instead of Credentials, so
+    // temporary credentials can be refreshed. Wrap providers in a
+    // CredentialsCache to avoid fetching credentials for every request.
+    CredentialsProvider CredentialsProvider
+
     // Sets the log level the signer should use when reporting information to
     // the logger. If the logger is nil nothing will be logged. See
     // aws.LogLevelType for more information on available logging levels
@@ -202,6 +207,12 @@ type Signer struct {
     // UnsignedPayload will prevent signing of the payload. This will only
     // work for services that have support for this.
     UnsignedPayload bool
+
+    // SigV4a signs with AWS4-ECDSA-P256-SHA256 instead of AWS4-HMAC-SHA256.
+    // The region passed to Sign or Presign is then the region set the
+    // signature is valid for, e.g. "*" or "us-east-1,us-west-2", as used by
+    // S3 multi-region access points.
+    SigV4a bool
 }
 
 // NewSigner returns a Signer pointer configured with the credentials and optional
@@ -236,6 +247,7 @@ type signingCtx struct {
     formattedTime      string
     formattedShortTime string
     unsignedPayload    bool
+    sigV4a             bool
 
     bodyDigest       string
     signedHeaders    string
@@ -311,6 +323,17 @@ func (v4 Signer) Presign(r *http.Request, body io.ReadSeeker, service, region st
 }
 
 func (v4 Signer) signWithBody(r *http.Request, body io.ReadSeeker, service, region string, exp time.Duration, isPresign bool, signTime time.Time) (http.Header, error) {
+    ctx, err := v4.sign(r, body, service, region, exp, isPresign, signTime)
+    if err != nil {
+        return nil, err
+    }
+
+    return ctx.SignedHeaderVals, nil
+}
+
+// sign does the work of signWithBody, returning the signing context so
+// streaming payloads can chain their chunk signatures from the seed signature.
+func (v4 Signer) sign(r *http.Request, body io.ReadSeeker, service, region string, exp time.Duration, isPresign bool, signTime time.Time) (*signingCtx, error) {
     currentTimeFn := v4.currentTimeFn
     if currentTimeFn == nil {
         currentTimeFn = time.Now
@@ -327,6 +350,7 @@ func (v4 Signer) signWithBody(r *http.Request, body io.ReadSeeker, service, regi
         Region:                 region,
         DisableURIPathEscaping: v4.DisableURIPathEscaping,
         unsignedPayload:        v4.UnsignedPayload,
+        sigV4a:                 v4.SigV4a,
     }
 
     for key := range ctx.Query {
@@ -339,11 +363,17 @@ func (v4 Signer) signWithBody(r *http.Request, body io.ReadSeeker, service, regi
     }
 
     ctx.credValues = v4.Credentials
+    if v4.CredentialsProvider != nil {
+        credentials, _, err := v4.CredentialsProvider.Retrieve()
+        if err != nil {
+            return nil, err
+        }
+        ctx.credValues = credentials
+    }
 
     ctx.sanitizeHostForHeader()
     ctx.assignAmzQueryValues()
     if err := ctx.build(v4.DisableHeaderHoisting); err != nil {
-        fmt.Printf("Couldn't build!")
         return nil, err
     }
 
@@ -365,11 +395,62 @@ func (v4 Signer) signWithBody(r *http.Request, body io.ReadSeeker, service, regi
     //    v4.logSigningInfo(ctx)
     //}
 
-    return ctx.SignedHeaderVals, nil
+    return ctx, nil
 }
 
+// sanitizeHostForHeader removes default port from host and updates request.Host
 func (ctx *signingCtx) sanitizeHostForHeader() {
-//    request.SanitizeHostForHeader(ctx.Request)
+    r := ctx.Request
+    host := r.Host
+    if host == "" {
+        host = r.URL.Host
+    }
+    port := portOnly(host)
+    if port != "" && isDefaultPort(r.URL.Scheme, port) {
+        r.Host = stripPort(host)
+    }
+}
+
+// Copied from the Go 1.8 standard library (net/url)
+func stripPort(hostport string) string {
+    colon := strings.IndexByte(hostport, ':')
+    if colon == -1 {
+        return hostport
+    }
+    if i := strings.IndexByte(hostport, ']'); i != -1 {
+        return strings.TrimPrefix(hostport[:i], "[")
+    }
+    return hostport[:colon]
+}
+
+// Copied from the Go 1.8 standard library (net/url)
+func portOnly(hostport string) string {
+    colon := strings.IndexByte(hostport, ':')
+    if colon == -1 {
+        return ""
+    }
+    if i := strings.Index(hostport, "]:"); i != -1 {
+        return hostport[i+len("]:"):]
+    }
+    if strings.Contains(hostport, "]") {
+        return ""
+    }
+    return hostport[colon+len(":"):]
+}
+
+// Returns true if the specified URI is using the standard port
+// (i.e. port 80 for HTTP URIs or 443 for HTTPS URIs)
+func isDefaultPort(scheme, port string) bool {
+    if port == "" {
+        return true
+    }
+
+    lowerCaseScheme := strings.ToLower(scheme)
+    if (lowerCaseScheme == "http" && port == "80") || (lowerCaseScheme == "https" && port == "443") {
+        return true
+    }
+
+    return false
 }
 
 func (ctx *signingCtx) handlePresignRemoval() {
@@ -388,19 +469,33 @@ func (ctx *signingCtx) handlePresignRemoval() {
 
 func (ctx *signingCtx) assignAmzQueryValues() {
     if ctx.isPresign {
-        ctx.Query.Set("X-Amz-Algorithm", authHeaderPrefix)
-        //if ctx.credValues.SessionToken != "" {
-        //    ctx.Query.Set("X-Amz-Security-Token", ctx.credValues.SessionToken)
-        //} else {
-        ctx.Query.Del("X-Amz-Security-Token")
-        //}
+        ctx.Query.Set("X-Amz-Algorithm", ctx.algorithm())
+        if ctx.sigV4a {
+            ctx.Query.Set(amzRegionSetKey, ctx.Region)
+        }
+        if ctx.credValues.Token != "" {
+            ctx.Query.Set("X-Amz-Security-Token", ctx.credValues.Token)
+        } else {
+            ctx.Query.Del("X-Amz-Security-Token")
+        }
 
         return
     }
 
-    //if ctx.credValues.SessionToken != "" {
-    //    ctx.Request.Header.Set("X-Amz-Security-Token", ctx.credValues.SessionToken)
-    //}
+    if ctx.sigV4a {
+        ctx.Request.Header.Set(amzRegionSetKey, ctx.Region)
+    }
+    if ctx.credValues.Token != "" {
+        ctx.Request.Header.Set("X-Amz-Security-Token", ctx.credValues.Token)
+    }
+}
+
+// algorithm returns the signing algorithm named in the signature.
+func (ctx *signingCtx) algorithm() string {
+    if ctx.sigV4a {
+        return authHeaderPrefixV4a
+    }
+    return authHeaderPrefix
 }
 
 // SignRequestHandler is a named request handler the SDK will use to sign
@@ -442,13 +537,19 @@ func (ctx *signingCtx) build(disableHeaderHoisting bool) error {
     ctx.buildCanonicalHeaders(ignoredHeaders, unsignedHeaders)
     ctx.buildCanonicalString() // depends on canon headers / signed headers
     ctx.buildStringToSign()    // depends on canon string
-    ctx.buildSignature()       // depends on string to sign
+    if ctx.sigV4a {
+        if err := ctx.buildSignatureV4a(); err != nil { // depends on string to sign
+            return err
+        }
+    } else {
+        ctx.buildSignature() // depends on string to sign
+    }
 
     if ctx.isPresign {
         ctx.Request.URL.RawQuery += "&X-Amz-Signature=" + ctx.signature
     } else {
         parts := []string{
-            authHeaderPrefix + " Credential=" + ctx.credValues.Id + "/" + ctx.credentialString,
+            ctx.algorithm() + " Credential=" + ctx.credValues.Id + "/" + ctx.credentialString,
             "SignedHeaders=" + ctx.signedHeaders,
             "Signature=" + ctx.signature,
         }
@@ -472,12 +573,12 @@ func (ctx *signingCtx) buildTime() {
 }
 
 func (ctx *signingCtx) buildCredentialString() {
-    ctx.credentialString = strings.Join([]string{
-        ctx.formattedShortTime,
-        ctx.Region,
-        ctx.ServiceName,
-        "aws4_request",
-    }, "/")
+    scope := []string{ctx.formattedShortTime, ctx.Region, ctx.ServiceName, "aws4_request"}
+    if ctx.sigV4a {
+        // The region set is signed as a header instead of in the scope.
+        scope = []string{ctx.formattedShortTime, ctx.ServiceName, "aws4_request"}
+    }
+    ctx.credentialString = strings.Join(scope, "/")
 
     if ctx.isPresign {
         ctx.Query.Set("X-Amz-Credential", ctx.credValues.Id+"/"+ctx.credentialString)
@@ -549,9 +650,9 @@ func (ctx *signingCtx) buildCanonicalString() {
 
     uri := getURIPath(ctx.Request.URL)
 
-    //if !ctx.DisableURIPathEscaping {
-    //    uri = rest.EscapePath(uri, false)
-    //}
+    if !ctx.DisableURIPathEscaping {
+        uri = escapePath(uri, false)
+    }
     ctx.canonicalString = strings.Join([]string{
         ctx.Request.Method,
         uri,
@@ -565,7 +666,7 @@ func (ctx *signingCtx) buildCanonicalString() {
 func (ctx *signingCtx) buildStringToSign() {
 
     ctx.stringToSign = strings.Join([]string{
-        authHeaderPrefix,
+        ctx.algorithm(),
         ctx.formattedTime,
         ctx.credentialString,
         hex.EncodeToString(makeSha256([]byte(ctx.canonicalString))),
@@ -573,13 +674,17 @@ func (ctx *signingCtx) buildStringToSign() {
 }
 
 func (ctx *signingCtx) buildSignature() {
+    signature := makeHmac(ctx.signingKey(), []byte(ctx.stringToSign))
+    ctx.signature = hex.EncodeToString(signature)
+}
+
+// signingKey derives the HMAC key for the request's date, region and service.
+func (ctx *signingCtx) signingKey() []byte {
     secret := ctx.credValues.Key
     date := makeHmac([]byte("AWS4"+secret), []byte(ctx.formattedShortTime))
     region := makeHmac(date, []byte(ctx.Region))
     service := makeHmac(region, []byte(ctx.ServiceName))
-    credentials := makeHmac(service, []byte("aws4_request"))
-    signature := makeHmac(credentials, []byte(ctx.stringToSign))
-    ctx.signature = hex.EncodeToString(signature)
+    return makeHmac(service, []byte("aws4_request"))
 }
 
 func (ctx *signingCtx) buildBodyDigest() error {
@@ -597,9 +702,9 @@ func (ctx *signingCtx) buildBodyDigest() error {
         } else if ctx.Body == nil {
             hash = emptyStringSHA256
         } else {
-            //if !aws.IsReaderSeekable(ctx.Body) {
-            //    return fmt.Errorf("cannot use unseekable request body %T, for signed request with body", ctx.Body)
-            //}
+            if !isReaderSeekable(ctx.Body) {
+                return fmt.Errorf("cannot use unseekable request body %T, for signed request with body", ctx.Body)
+            }
             hash = hex.EncodeToString(makeSha256Reader(ctx.Body))
         }
 
@@ -633,6 +738,7 @@ func (ctx *signingCtx) removePresign() {
     ctx.Query.Del("X-Amz-Expires")
     ctx.Query.Del("X-Amz-Credential")
     ctx.Query.Del("X-Amz-SignedHeaders")
+    ctx.Query.Del(amzRegionSetKey)
 }
 
 func makeHmac(key []byte, data []byte) []byte {
@@ -656,6 +762,16 @@ func makeSha256Reader(reader io.ReadSeeker) []byte {
     return hash.Sum(nil)
 }
 
+// isReaderSeekable returns if the reader can be seeked to hash the body.
+// Wrapping readers can report they aren't seekable with an IsSeeker method.
+func isReaderSeekable(r io.Reader) bool {
+    if v, ok := r.(interface{ IsSeeker() bool }); ok {
+        return v.IsSeeker()
+    }
+    _, ok := r.(io.Seeker)
+    return ok
+}
+
 const doubleSpace = "  "
 
 // stripExcessSpaces will rewrite the passed in slice's string values to not
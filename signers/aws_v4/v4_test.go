@@ -12,10 +12,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/awstesting"
+	generic_structs "github.com/SREnity/epico/structs"
 )
 
 func TestStripExcessHeaders(t *testing.T) {
@@ -94,15 +91,29 @@ func buildRequestWithBodyReader(serviceName, region string, body io.Reader) (*ht
 	if sr, ok := body.(io.ReadSeeker); ok {
 		seeker = sr
 	} else {
-		seeker = aws.ReadSeekCloser(body)
+		seeker = unseekableReader{body}
 	}
 
 	return req, seeker
 }
 
+// unseekableReader satisfies io.ReadSeeker but reports it can't be seeked,
+// like a streamed request body.
+type unseekableReader struct {
+	io.Reader
+}
+
+func (unseekableReader) Seek(int64, int) (int64, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func (unseekableReader) IsSeeker() bool {
+	return false
+}
+
 func buildSigner() Signer {
 	return Signer{
-		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", "SESSION"),
+		Credentials: generic_structs.ApiCredentials{Id: "AKID", Key: "SECRET", Token: "SESSION"},
 	}
 }
 
@@ -311,237 +322,8 @@ func TestSignPrecomputedBodyChecksum(t *testing.T) {
 	}
 }
 
-func TestAnonymousCredentials(t *testing.T) {
-	svc := awstesting.NewClient(&aws.Config{Credentials: credentials.AnonymousCredentials})
-	r := svc.NewRequest(
-		&request.Operation{
-			Name:       "BatchGetItem",
-			HTTPMethod: "POST",
-			HTTPPath:   "/",
-		},
-		nil,
-		nil,
-	)
-	SignSDKRequest(r)
-
-	urlQ := r.HTTPRequest.URL.Query()
-	if a := urlQ.Get("X-Amz-Signature"); len(a) != 0 {
-		t.Errorf("expect %v to be empty, was not", a)
-	}
-	if a := urlQ.Get("X-Amz-Credential"); len(a) != 0 {
-		t.Errorf("expect %v to be empty, was not", a)
-	}
-	if a := urlQ.Get("X-Amz-SignedHeaders"); len(a) != 0 {
-		t.Errorf("expect %v to be empty, was not", a)
-	}
-	if a := urlQ.Get("X-Amz-Date"); len(a) != 0 {
-		t.Errorf("expect %v to be empty, was not", a)
-	}
-
-	hQ := r.HTTPRequest.Header
-	if a := hQ.Get("Authorization"); len(a) != 0 {
-		t.Errorf("expect %v to be empty, was not", a)
-	}
-	if a := hQ.Get("X-Amz-Date"); len(a) != 0 {
-		t.Errorf("expect %v to be empty, was not", a)
-	}
-}
-
-func TestIgnoreResignRequestWithValidCreds(t *testing.T) {
-	svc := awstesting.NewClient(&aws.Config{
-		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", "SESSION"),
-		Region:      aws.String("us-west-2"),
-	})
-	r := svc.NewRequest(
-		&request.Operation{
-			Name:       "BatchGetItem",
-			HTTPMethod: "POST",
-			HTTPPath:   "/",
-		},
-		nil,
-		nil,
-	)
-
-	SignSDKRequest(r)
-	sig := r.HTTPRequest.Header.Get("Authorization")
-
-	signSDKRequestWithCurrTime(r, func() time.Time {
-		// Simulate one second has passed so that signature's date changes
-		// when it is resigned.
-		return time.Now().Add(1 * time.Second)
-	})
-	if e, a := sig, r.HTTPRequest.Header.Get("Authorization"); e == a {
-		t.Errorf("expect %v to be %v, but was not", e, a)
-	}
-}
-
-func TestIgnorePreResignRequestWithValidCreds(t *testing.T) {
-	svc := awstesting.NewClient(&aws.Config{
-		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", "SESSION"),
-		Region:      aws.String("us-west-2"),
-	})
-	r := svc.NewRequest(
-		&request.Operation{
-			Name:       "BatchGetItem",
-			HTTPMethod: "POST",
-			HTTPPath:   "/",
-		},
-		nil,
-		nil,
-	)
-	r.ExpireTime = time.Minute * 10
-
-	SignSDKRequest(r)
-	sig := r.HTTPRequest.URL.Query().Get("X-Amz-Signature")
-
-	signSDKRequestWithCurrTime(r, func() time.Time {
-		// Simulate one second has passed so that signature's date changes
-		// when it is resigned.
-		return time.Now().Add(1 * time.Second)
-	})
-	if e, a := sig, r.HTTPRequest.URL.Query().Get("X-Amz-Signature"); e == a {
-		t.Errorf("expect %v to be %v, but was not", e, a)
-	}
-}
-
-func TestResignRequestExpiredCreds(t *testing.T) {
-	creds := credentials.NewStaticCredentials("AKID", "SECRET", "SESSION")
-	svc := awstesting.NewClient(&aws.Config{Credentials: creds})
-	r := svc.NewRequest(
-		&request.Operation{
-			Name:       "BatchGetItem",
-			HTTPMethod: "POST",
-			HTTPPath:   "/",
-		},
-		nil,
-		nil,
-	)
-	SignSDKRequest(r)
-	querySig := r.HTTPRequest.Header.Get("Authorization")
-	var origSignedHeaders string
-	for _, p := range strings.Split(querySig, ", ") {
-		if strings.HasPrefix(p, "SignedHeaders=") {
-			origSignedHeaders = p[len("SignedHeaders="):]
-			break
-		}
-	}
-	if a := origSignedHeaders; len(a) == 0 {
-		t.Errorf("expect not to be empty, but was")
-	}
-	if e, a := origSignedHeaders, "authorization"; strings.Contains(a, e) {
-		t.Errorf("expect %v to not be in %v, but was", e, a)
-	}
-	origSignedAt := r.LastSignedAt
-
-	creds.Expire()
-
-	signSDKRequestWithCurrTime(r, func() time.Time {
-		// Simulate one second has passed so that signature's date changes
-		// when it is resigned.
-		return time.Now().Add(1 * time.Second)
-	})
-	updatedQuerySig := r.HTTPRequest.Header.Get("Authorization")
-	if e, a := querySig, updatedQuerySig; e == a {
-		t.Errorf("expect %v to be %v, was not", e, a)
-	}
-
-	var updatedSignedHeaders string
-	for _, p := range strings.Split(updatedQuerySig, ", ") {
-		if strings.HasPrefix(p, "SignedHeaders=") {
-			updatedSignedHeaders = p[len("SignedHeaders="):]
-			break
-		}
-	}
-	if a := updatedSignedHeaders; len(a) == 0 {
-		t.Errorf("expect not to be empty, but was")
-	}
-	if e, a := updatedQuerySig, "authorization"; strings.Contains(a, e) {
-		t.Errorf("expect %v to not be in %v, but was", e, a)
-	}
-	if e, a := origSignedAt, r.LastSignedAt; e == a {
-		t.Errorf("expect %v to be %v, was not", e, a)
-	}
-}
-
-func TestPreResignRequestExpiredCreds(t *testing.T) {
-	provider := &credentials.StaticProvider{Value: credentials.Value{
-		AccessKeyID:     "AKID",
-		SecretAccessKey: "SECRET",
-		SessionToken:    "SESSION",
-	}}
-	creds := credentials.NewCredentials(provider)
-	svc := awstesting.NewClient(&aws.Config{Credentials: creds})
-	r := svc.NewRequest(
-		&request.Operation{
-			Name:       "BatchGetItem",
-			HTTPMethod: "POST",
-			HTTPPath:   "/",
-		},
-		nil,
-		nil,
-	)
-	r.ExpireTime = time.Minute * 10
-
-	SignSDKRequest(r)
-	querySig := r.HTTPRequest.URL.Query().Get("X-Amz-Signature")
-	signedHeaders := r.HTTPRequest.URL.Query().Get("X-Amz-SignedHeaders")
-	if a := signedHeaders; len(a) == 0 {
-		t.Errorf("expect not to be empty, but was")
-	}
-	origSignedAt := r.LastSignedAt
-
-	creds.Expire()
-
-	signSDKRequestWithCurrTime(r, func() time.Time {
-		// Simulate the request occurred 15 minutes in the past
-		return time.Now().Add(-48 * time.Hour)
-	})
-	if e, a := querySig, r.HTTPRequest.URL.Query().Get("X-Amz-Signature"); e == a {
-		t.Errorf("expect %v to be %v, was not", e, a)
-	}
-	resignedHeaders := r.HTTPRequest.URL.Query().Get("X-Amz-SignedHeaders")
-	if e, a := signedHeaders, resignedHeaders; e != a {
-		t.Errorf("expect %v, got %v", e, a)
-	}
-	if e, a := signedHeaders, "x-amz-signedHeaders"; strings.Contains(a, e) {
-		t.Errorf("expect %v to not be in %v, but was", e, a)
-	}
-	if e, a := origSignedAt, r.LastSignedAt; e == a {
-		t.Errorf("expect %v to be %v, was not", e, a)
-	}
-}
-
-func TestResignRequestExpiredRequest(t *testing.T) {
-	creds := credentials.NewStaticCredentials("AKID", "SECRET", "SESSION")
-	svc := awstesting.NewClient(&aws.Config{Credentials: creds})
-	r := svc.NewRequest(
-		&request.Operation{
-			Name:       "BatchGetItem",
-			HTTPMethod: "POST",
-			HTTPPath:   "/",
-		},
-		nil,
-		nil,
-	)
-
-	SignSDKRequest(r)
-	querySig := r.HTTPRequest.Header.Get("Authorization")
-	origSignedAt := r.LastSignedAt
-
-	signSDKRequestWithCurrTime(r, func() time.Time {
-		// Simulate the request occurred 15 minutes in the past
-		return time.Now().Add(15 * time.Minute)
-	})
-	if e, a := querySig, r.HTTPRequest.Header.Get("Authorization"); e == a {
-		t.Errorf("expect %v to be %v, was not", e, a)
-	}
-	if e, a := origSignedAt, r.LastSignedAt; e == a {
-		t.Errorf("expect %v to be %v, was not", e, a)
-	}
-}
-
 func TestSignWithRequestBody(t *testing.T) {
-	creds := credentials.NewStaticCredentials("AKID", "SECRET", "SESSION")
+	creds := generic_structs.ApiCredentials{Id: "AKID", Key: "SECRET", Token: "SESSION"}
 	signer := NewSigner(creds)
 
 	expectBody := []byte("abc123")
@@ -575,7 +357,7 @@ func TestSignWithRequestBody(t *testing.T) {
 }
 
 func TestSignWithRequestBody_Overwrite(t *testing.T) {
-	creds := credentials.NewStaticCredentials("AKID", "SECRET", "SESSION")
+	creds := generic_structs.ApiCredentials{Id: "AKID", Key: "SECRET", Token: "SESSION"}
 	signer := NewSigner(creds)
 
 	var expectBody []byte
@@ -631,7 +413,7 @@ func TestBuildCanonicalRequest(t *testing.T) {
 }
 
 func TestSignWithBody_ReplaceRequestBody(t *testing.T) {
-	creds := credentials.NewStaticCredentials("AKID", "SECRET", "SESSION")
+	creds := generic_structs.ApiCredentials{Id: "AKID", Key: "SECRET", Token: "SESSION"}
 	req, seekerBody := buildRequest("dynamodb", "us-east-1", "{}")
 	req.Body = ioutil.NopCloser(bytes.NewReader([]byte{}))
 
@@ -653,7 +435,7 @@ func TestSignWithBody_ReplaceRequestBody(t *testing.T) {
 }
 
 func TestSignWithBody_NoReplaceRequestBody(t *testing.T) {
-	creds := credentials.NewStaticCredentials("AKID", "SECRET", "SESSION")
+	creds := generic_structs.ApiCredentials{Id: "AKID", Key: "SECRET", Token: "SESSION"}
 	req, seekerBody := buildRequest("dynamodb", "us-east-1", "{}")
 	req.Body = ioutil.NopCloser(bytes.NewReader([]byte{}))
 
@@ -0,0 +1,136 @@
+package v4
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+const (
+	// authHeaderPrefixV4a is the SigV4a signing algorithm.
+	authHeaderPrefixV4a = "AWS4-ECDSA-P256-SHA256"
+
+	// amzRegionSetKey carries the regions a SigV4a signature is valid for.
+	amzRegionSetKey = "X-Amz-Region-Set"
+)
+
+var (
+	one           = big.NewInt(1)
+	nMinusTwoP256 = new(big.Int).Sub(elliptic.P256().Params().N, big.NewInt(2))
+)
+
+// Derived keys are cached per credential pair, as deriving one takes several
+// HMAC rounds.
+var v4aKeyCache = struct {
+	sync.Mutex
+	keys map[string]*ecdsa.PrivateKey
+}{keys: make(map[string]*ecdsa.PrivateKey)}
+
+// buildSignatureV4a signs the string to sign with the ECDSA key derived from
+// the credentials. Unlike SigV4 the signature is not deterministic.
+func (ctx *signingCtx) buildSignatureV4a() error {
+	key, err := v4aSigningKey(ctx.credValues.Id, ctx.credValues.Key)
+	if err != nil {
+		return err
+	}
+
+	digest := makeSha256([]byte(ctx.stringToSign))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		return err
+	}
+	signature, err := asn1.Marshal(v4aSignature{R: r, S: s})
+	if err != nil {
+		return err
+	}
+	ctx.signature = hex.EncodeToString(signature)
+
+	return nil
+}
+
+// v4aSignature is the ASN.1 DER form SigV4a signatures are sent in.
+type v4aSignature struct {
+	R, S *big.Int
+}
+
+func v4aSigningKey(accessKey, secretKey string) (*ecdsa.PrivateKey, error) {
+	cacheKey := hex.EncodeToString(makeSha256([]byte(accessKey + "\x00" + secretKey)))
+
+	v4aKeyCache.Lock()
+	defer v4aKeyCache.Unlock()
+	if key, ok := v4aKeyCache.keys[cacheKey]; ok {
+		return key, nil
+	}
+
+	key, err := deriveKeyFromAccessKeyPair(accessKey, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	v4aKeyCache.keys[cacheKey] = key
+
+	return key, nil
+}
+
+// deriveKeyFromAccessKeyPair derives the P-256 private key for a credential
+// pair as SigV4a specifies: candidates come from an NIST SP 800-108 HMAC
+// counter mode KDF keyed with "AWS4A" + secret, and the first one below n-1
+// is used as d-1.
+func deriveKeyFromAccessKeyPair(accessKey, secretKey string) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	inputKey := []byte("AWS4A" + secretKey)
+
+	d := new(big.Int)
+	for counter := 1; ; counter++ {
+		if counter > 0xFF {
+			return nil, fmt.Errorf("exhausted single byte external counter deriving SigV4a key")
+		}
+
+		context := append([]byte(accessKey), byte(counter))
+		candidate := hmacKeyDerivation(inputKey, []byte(authHeaderPrefixV4a), context, curve.Params().BitSize)
+		d.SetBytes(candidate)
+		if d.Cmp(nMinusTwoP256) < 0 {
+			break
+		}
+	}
+	d.Add(d, one)
+
+	key := new(ecdsa.PrivateKey)
+	key.PublicKey.Curve = curve
+	key.D = d
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+
+	return key, nil
+}
+
+// hmacKeyDerivation is the NIST SP 800-108 KDF in counter mode using
+// HMAC-SHA256: HMAC(key, i || label || 0x00 || context || bitLen) for each
+// block i, truncated to bitLen bits.
+func hmacKeyDerivation(key, label, context []byte, bitLen int) []byte {
+	fixedInput := new(bytes.Buffer)
+	fixedInput.Write([]byte{0, 0, 0, 0}) // counter
+	fixedInput.Write(label)
+	fixedInput.WriteByte(0)
+	fixedInput.Write(context)
+	binary.Write(fixedInput, binary.BigEndian, int32(bitLen))
+
+	var out []byte
+	mac := hmac.New(sha256.New, key)
+	for i := uint32(1); len(out) < bitLen/8; i++ {
+		buf := fixedInput.Bytes()
+		binary.BigEndian.PutUint32(buf[:4], i)
+		mac.Reset()
+		mac.Write(buf)
+		out = mac.Sum(out)
+	}
+
+	return out[:bitLen/8]
+}
@@ -0,0 +1,58 @@
+package azure_shared_key
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAccountKey = base64.StdEncoding.EncodeToString([]byte("storage-account-key"))
+
+func TestStringToSign(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "https://myaccount.blob.core.windows.net/mycontainer/my%20blob?comp=block&blockid=AAAA&Timeout=30", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("X-Ms-Meta-Name", "  some   value ")
+
+	signer := Signer{AccountName: "myaccount", AccountKey: testAccountKey}
+	if err := signer.Sign(req, time.Date(2021, 4, 20, 2, 7, 55, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "PUT\n\n\n5\n\ntext/plain\n\n\n\n\n\n\n" +
+		"x-ms-blob-type:BlockBlob\n" +
+		"x-ms-date:Tue, 20 Apr 2021 02:07:55 GMT\n" +
+		"x-ms-meta-name:some value\n" +
+		"x-ms-version:" + DefaultVersion + "\n" +
+		"/myaccount/mycontainer/my%20blob\nblockid:AAAA\ncomp:block\ntimeout:30"
+	stringToSign := signer.StringToSign(req)
+	if stringToSign != expected {
+		t.Errorf("expect\n%q\nactual\n%q", expected, stringToSign)
+	}
+
+	mac := hmac.New(sha256.New, []byte("storage-account-key"))
+	mac.Write([]byte(expected))
+	if e, a := "SharedKey myaccount:"+base64.StdEncoding.EncodeToString(mac.Sum(nil)), req.Header.Get("Authorization"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+func TestSignEmptyBodyAndBadKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://myaccount.queue.core.windows.net/", nil)
+	req.Header.Set("x-ms-version", "2019-12-12")
+	signer := Signer{AccountName: "myaccount", AccountKey: testAccountKey}
+	if err := signer.Sign(req, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if a := signer.StringToSign(req); !strings.HasPrefix(a, "GET\n\n\n\n") || !strings.HasSuffix(a, "x-ms-version:2019-12-12\n/myaccount/") {
+		t.Errorf("expect an empty content length and the request's own version, got %q", a)
+	}
+
+	if err := (Signer{AccountName: "myaccount", AccountKey: "not base64!"}).Sign(req, time.Unix(0, 0)); err == nil {
+		t.Errorf("expect error for an invalid account key")
+	}
+}
@@ -0,0 +1,230 @@
+// Package cloud_hmac implements the HMAC-SHA256 request signatures used by
+// Alibaba Cloud (ACS3-HMAC-SHA256) and Tencent Cloud (TC3-HMAC-SHA256) APIs.
+// Both are modelled on AWS SigV4: a canonical request is hashed into a string
+// to sign, which is signed with the secret or a key derived from it.
+package cloud_hmac
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SREnity/epico/signers"
+)
+
+const (
+	acs3Algorithm = "ACS3-HMAC-SHA256"
+	tc3Algorithm  = "TC3-HMAC-SHA256"
+)
+
+// Acs3Signer signs Alibaba Cloud API requests with ACS3-HMAC-SHA256. Any
+// x-acs-* headers already on the request, such as x-acs-action and
+// x-acs-version, are signed along with host and content-type.
+type Acs3Signer struct {
+	AccessKeyId     string
+	AccessKeySecret string
+	SecurityToken   string
+
+	// Nonce returns the x-acs-signature-nonce for each request. Defaults to
+	// a random value.
+	Nonce func() string
+}
+
+// Sign signs the request, setting the x-acs-* headers and Authorization.
+func (s Acs3Signer) Sign(r *http.Request, signTime time.Time) error {
+	body, err := signers.ReadBody(r)
+	if err != nil {
+		return err
+	}
+
+	nonce := s.Nonce
+	if nonce == nil {
+		nonce = randomNonce
+	}
+	r.Header.Set("x-acs-date", signTime.UTC().Format("2006-01-02T15:04:05Z"))
+	r.Header.Set("x-acs-signature-nonce", nonce())
+	r.Header.Set("x-acs-content-sha256", signers.Sha256Hex(body))
+	if s.SecurityToken != "" {
+		r.Header.Set("x-acs-security-token", s.SecurityToken)
+	}
+
+	canonicalHeaders, signedHeaders := canonicalizeHeaders(r, "x-acs-", false)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		canonicalUri(r.URL),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		r.Header.Get("x-acs-content-sha256"),
+	}, "\n")
+	stringToSign := acs3Algorithm + "\n" + signers.Sha256Hex([]byte(canonicalRequest))
+	signature := hex.EncodeToString(makeHmac([]byte(s.AccessKeySecret), []byte(stringToSign)))
+
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s,SignedHeaders=%s,Signature=%s",
+		acs3Algorithm, s.AccessKeyId, signedHeaders, signature))
+
+	return nil
+}
+
+// Tc3Signer signs Tencent Cloud API 3.0 requests with TC3-HMAC-SHA256. The
+// X-TC-Action, X-TC-Version and X-TC-Region headers are expected to be set on
+// the request already.
+type Tc3Signer struct {
+	SecretId  string
+	SecretKey string
+	Token     string
+
+	// Service is the product the request is for, e.g. cvm. Defaults to the
+	// first label of the host, as in cvm.tencentcloudapi.com.
+	Service string
+}
+
+// Sign signs the request, setting X-TC-Timestamp and Authorization.
+func (s Tc3Signer) Sign(r *http.Request, signTime time.Time) error {
+	body, err := signers.ReadBody(r)
+	if err != nil {
+		return err
+	}
+
+	service := s.Service
+	if service == "" {
+		service = strings.SplitN(requestHost(r), ".", 2)[0]
+	}
+	date := signTime.UTC().Format("2006-01-02")
+	r.Header.Set("X-TC-Timestamp", strconv.FormatInt(signTime.Unix(), 10))
+	if s.Token != "" {
+		r.Header.Set("X-TC-Token", s.Token)
+	}
+
+	canonicalHeaders, signedHeaders := canonicalizeHeaders(r, "", true)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		"/",
+		r.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		signers.Sha256Hex(body),
+	}, "\n")
+	scope := date + "/" + service + "/tc3_request"
+	stringToSign := strings.Join([]string{
+		tc3Algorithm,
+		strconv.FormatInt(signTime.Unix(), 10),
+		scope,
+		signers.Sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	secretDate := makeHmac([]byte("TC3"+s.SecretKey), []byte(date))
+	secretService := makeHmac(secretDate, []byte(service))
+	secretSigning := makeHmac(secretService, []byte("tc3_request"))
+	signature := hex.EncodeToString(makeHmac(secretSigning, []byte(stringToSign)))
+
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		tc3Algorithm, s.SecretId, scope, signedHeaders, signature))
+
+	return nil
+}
+
+// canonicalizeHeaders returns the host and content-type headers, plus any
+// starting with prefix, as sorted name:value lines and the list of their
+// names. TC3 lowercases the values as well as the names.
+func canonicalizeHeaders(r *http.Request, prefix string, lowerValues bool) (string, string) {
+	values := map[string]string{"host": requestHost(r)}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		values["content-type"] = contentType
+	}
+	if prefix != "" {
+		for k, v := range r.Header {
+			if name := strings.ToLower(k); strings.HasPrefix(name, prefix) {
+				values[name] = strings.Join(v, ",")
+			}
+		}
+	}
+
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		value := strings.TrimSpace(values[name])
+		if lowerValues {
+			value = strings.ToLower(value)
+		}
+		canonical.WriteString(name + ":" + value + "\n")
+	}
+
+	return canonical.String(), strings.Join(names, ";")
+}
+
+func canonicalUri(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		segments[i] = percentEncode(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, percentEncode(k)+"="+percentEncode(v))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// percentEncode escapes everything but RFC 3986 unreserved characters.
+func percentEncode(s string) string {
+	escaped := url.QueryEscape(s)
+	escaped = strings.Replace(escaped, "+", "%20", -1)
+	escaped = strings.Replace(escaped, "*", "%2A", -1)
+	return strings.Replace(escaped, "%7E", "~", -1)
+}
+
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
+}
+
+func makeHmac(key []byte, data []byte) []byte {
+	hash := hmac.New(sha256.New, key)
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+func randomNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,82 @@
+package cloud_hmac
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The signing example from the Tencent Cloud API 3.0 documentation, which
+// signs with the masked key exactly as printed.
+func TestTc3SignerDocumentationExample(t *testing.T) {
+	payload := `{"Limit": 1, "Filters": [{"Values": ["\u672a\u547d\u540d"], "Name": "instance-name"}]}`
+	req, _ := http.NewRequest("POST", "https://cvm.tencentcloudapi.com/", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-TC-Action", "DescribeInstances")
+	req.Header.Set("X-TC-Version", "2017-03-12")
+	req.Header.Set("X-TC-Region", "ap-guangzhou")
+
+	signer := Tc3Signer{SecretId: "AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******", SecretKey: "Gu5t9xGARNpq86cd98joQYCN3*******"}
+	if err := signer.Sign(req, time.Unix(1551113065, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "TC3-HMAC-SHA256 Credential=AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******/2019-02-25/cvm/tc3_request, SignedHeaders=content-type;host, Signature=2230eefd229f582d8b1b891af7107b91597240707d778ab3738f756258d7652c"
+	if e, a := expected, req.Header.Get("Authorization"); e != a {
+		t.Errorf("expect\n%v\nactual\n%v", e, a)
+	}
+	if e, a := "1551113065", req.Header.Get("X-TC-Timestamp"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+	if e, a := payload, string(body); e != a {
+		t.Errorf("expect body to be kept, got %v", a)
+	}
+}
+
+func TestAcs3Signer(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://ecs.cn-hangzhou.aliyuncs.com/?RegionId=cn-hangzhou&ImageId=win2019_1809_x64_dtc_zh-cn_40G_alibase_20230811.vhd&Tag.1.Key=a b", nil)
+	req.Header.Set("x-acs-action", "RunInstances")
+	req.Header.Set("x-acs-version", "2014-05-26")
+
+	signer := Acs3Signer{AccessKeyId: "YourAccessKeyId", AccessKeySecret: "YourAccessKeySecret", SecurityToken: "sts-token", Nonce: func() string { return "3156853299f313e23d1673dc12e1703d" }}
+	if err := signer.Sign(req, time.Date(2023, 10, 26, 10, 22, 32, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	canonicalRequest := `POST
+/
+ImageId=win2019_1809_x64_dtc_zh-cn_40G_alibase_20230811.vhd&RegionId=cn-hangzhou&Tag.1.Key=a%20b
+host:ecs.cn-hangzhou.aliyuncs.com
+x-acs-action:RunInstances
+x-acs-content-sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+x-acs-date:2023-10-26T10:22:32Z
+x-acs-security-token:sts-token
+x-acs-signature-nonce:3156853299f313e23d1673dc12e1703d
+x-acs-version:2014-05-26
+
+host;x-acs-action;x-acs-content-sha256;x-acs-date;x-acs-security-token;x-acs-signature-nonce;x-acs-version
+e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855`
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	mac := hmac.New(sha256.New, []byte("YourAccessKeySecret"))
+	mac.Write([]byte("ACS3-HMAC-SHA256\n" + hex.EncodeToString(hashed[:])))
+
+	expected := "ACS3-HMAC-SHA256 Credential=YourAccessKeyId,SignedHeaders=host;x-acs-action;x-acs-content-sha256;x-acs-date;x-acs-security-token;x-acs-signature-nonce;x-acs-version,Signature=" + hex.EncodeToString(mac.Sum(nil))
+	if e, a := expected, req.Header.Get("Authorization"); e != a {
+		t.Errorf("expect\n%v\nactual\n%v", e, a)
+	}
+}
+
+func TestPercentEncode(t *testing.T) {
+	for in, e := range map[string]string{"a b": "a%20b", "a*b": "a%2Ab", "a~b": "a~b", "a/b": "a%2Fb", "中": "%E4%B8%AD"} {
+		if a := percentEncode(in); e != a {
+			t.Errorf("expect %v for %q, got %v", e, in, a)
+		}
+	}
+}
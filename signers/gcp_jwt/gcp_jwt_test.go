@@ -0,0 +1,95 @@
+package gcp_jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2/jws"
+)
+
+func newTestKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	keyJson, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "scanner@project.iam.gserviceaccount.com",
+	})
+
+	return key, keyJson
+}
+
+func TestSign(t *testing.T) {
+	key, keyJson := newTestKey(t)
+	serviceAccount, err := ParseServiceAccountKey(keyJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "https://compute.googleapis.com/compute/v1/projects/p/zones", nil)
+	signTime := time.Unix(1600000000, 0)
+	if err := (Signer{Key: serviceAccount}).Sign(req, signTime); err != nil {
+		t.Fatal(err)
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if err := jws.Verify(token, &key.PublicKey); err != nil {
+		t.Fatalf("expect token to verify: %v", err)
+	}
+	claims, _ := jws.Decode(token)
+	if e, a := "https://compute.googleapis.com/", claims.Aud; e != a {
+		t.Errorf("expect audience %v, got %v", e, a)
+	}
+	if claims.Iss != serviceAccount.ClientEmail || claims.Sub != serviceAccount.ClientEmail {
+		t.Errorf("expect issuer and subject to be the client email, got %+v", claims)
+	}
+	if e, a := signTime.Add(time.Hour).Unix(), claims.Exp; e != a {
+		t.Errorf("expect expiry %v, got %v", e, a)
+	}
+
+	header, _ := jwtHeader(token)
+	if e, a := "key-1", header["kid"]; e != a {
+		t.Errorf("expect kid %v, got %v", e, a)
+	}
+
+	// Scoped JWTs leave out the audience.
+	if err := (Signer{Key: serviceAccount, Scopes: "https://www.googleapis.com/auth/cloud-platform"}).Sign(req, signTime); err != nil {
+		t.Fatal(err)
+	}
+	claims, _ = jws.Decode(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	if claims.Aud != "" || claims.Scope != "https://www.googleapis.com/auth/cloud-platform" {
+		t.Errorf("expect a scope and no audience, got %+v", claims)
+	}
+}
+
+func TestParseServiceAccountKeyErrors(t *testing.T) {
+	if _, err := ParseServiceAccountKey([]byte(`{"type": "service_account"}`)); err == nil {
+		t.Errorf("expect error for a key without client_email")
+	}
+	if _, err := ParseServiceAccountKey([]byte(`not json`)); err == nil {
+		t.Errorf("expect error for invalid JSON")
+	}
+}
+
+func jwtHeader(token string) (map[string]string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.SplitN(token, ".", 2)[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var header map[string]string
+	err = json.Unmarshal(decoded, &header)
+	return header, err
+}
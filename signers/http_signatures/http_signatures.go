@@ -0,0 +1,421 @@
+// Package http_signatures implements HTTP Message Signatures, both the
+// widely deployed draft-cavage-http-signatures scheme and its successor,
+// RFC 9421.
+//
+// https://datatracker.ietf.org/doc/html/draft-cavage-http-signatures-12
+// https://www.rfc-editor.org/rfc/rfc9421
+package http_signatures
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SREnity/epico/signers"
+)
+
+// ParseKey returns the key for the algorithm from its text form: the shared
+// secret for hmac-* algorithms (base64 decoded if prefixed with "base64:"),
+// otherwise a PEM encoded private key.
+func ParseKey(algorithm string, key string) (interface{}, error) {
+	if strings.HasPrefix(algorithm, "hmac-") {
+		if strings.HasPrefix(key, "base64:") {
+			return base64.StdEncoding.DecodeString(strings.TrimPrefix(key, "base64:"))
+		}
+		return []byte(key), nil
+	}
+
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("signing key isn't PEM encoded")
+	}
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return parsed, nil
+	}
+
+	return nil, errors.New("unsupported signing key - expected a PKCS#8, PKCS#1 or EC private key")
+}
+
+// CavageSigner signs requests per draft-cavage-http-signatures-12.
+type CavageSigner struct {
+	KeyId string
+
+	// Algorithm is one of hmac-sha256, rsa-sha256, ecdsa-sha256 or hs2019.
+	// hs2019 picks the signature from the key: RSASSA-PSS with SHA-512,
+	// ECDSA or HMAC with SHA-512, or Ed25519.
+	Algorithm string
+
+	// Key is the []byte shared secret for HMAC, otherwise a private key.
+	Key interface{}
+
+	// Headers are the headers signed, lowercase, including pseudo headers
+	// such as (request-target) and (created). Defaults to (request-target)
+	// and date, or (created) in place of date for hs2019. Date and digest
+	// headers are added to the request if signed but missing.
+	Headers []string
+
+	// Expires adds an (expires) parameter this long after signing.
+	Expires time.Duration
+
+	// HeaderName is where the signature goes - Signature by default, or
+	// Authorization to send it as "Signature <params>".
+	HeaderName string
+}
+
+// Sign signs the request.
+func (s CavageSigner) Sign(r *http.Request, signTime time.Time) error {
+	headers := s.Headers
+	if len(headers) == 0 {
+		headers = []string{"(request-target)", "date"}
+		if s.Algorithm == "hs2019" {
+			headers = []string{"(request-target)", "(created)"}
+		}
+	}
+	if err := addDateAndDigest(r, headers, "date", "digest", signTime); err != nil {
+		return err
+	}
+
+	created := signTime.Unix()
+	expires := signTime.Add(s.Expires).Unix()
+	var lines []string
+	for _, name := range headers {
+		name = strings.ToLower(name)
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "(created)":
+			value = strconv.FormatInt(created, 10)
+		case "(expires)":
+			value = strconv.FormatInt(expires, 10)
+		default:
+			var ok bool
+			if value, ok = headerValue(r, name); !ok {
+				return fmt.Errorf("can't sign missing header %q", name)
+			}
+		}
+		lines = append(lines, name+": "+value)
+	}
+
+	algorithm := s.Algorithm
+	if algorithm == "hs2019" {
+		algorithm = hs2019Algorithm(s.Key)
+	}
+	signature, err := sign(algorithm, s.Key, []byte(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+
+	params := []string{`keyId="` + s.KeyId + `"`, `algorithm="` + s.Algorithm + `"`}
+	for _, name := range headers {
+		if name == "(created)" {
+			params = append(params, "created="+strconv.FormatInt(created, 10))
+		} else if name == "(expires)" {
+			params = append(params, "expires="+strconv.FormatInt(expires, 10))
+		}
+	}
+	params = append(params,
+		`headers="`+strings.ToLower(strings.Join(headers, " "))+`"`,
+		`signature="`+base64.StdEncoding.EncodeToString(signature)+`"`)
+
+	if s.HeaderName == "Authorization" {
+		r.Header.Set("Authorization", "Signature "+strings.Join(params, ","))
+	} else {
+		r.Header.Set("Signature", strings.Join(params, ","))
+	}
+
+	return nil
+}
+
+// MessageSigner signs requests per RFC 9421, setting the Signature-Input and
+// Signature headers.
+type MessageSigner struct {
+	KeyId string
+
+	// Algorithm is one of hmac-sha256, rsa-v1_5-sha256, rsa-pss-sha512,
+	// ecdsa-p256-sha256 or ed25519.
+	Algorithm string
+
+	// Key is the []byte shared secret for HMAC, otherwise a private key.
+	Key interface{}
+
+	// Components are the covered component identifiers, e.g. @method,
+	// @authority, @path, @query, @target-uri or a lowercase header name.
+	// Defaults to @method, @authority and @path, plus content-digest when
+	// the request has a body. date and content-digest are added to the
+	// request if covered but missing.
+	Components []string
+
+	// Label names the signature. Defaults to sig1.
+	Label string
+
+	// Expires adds an expires parameter this long after signing.
+	Expires time.Duration
+
+	// IncludeAlgorithm adds the alg parameter.
+	IncludeAlgorithm bool
+
+	// Tag is an optional application specific tag parameter.
+	Tag string
+}
+
+// Sign signs the request.
+func (s MessageSigner) Sign(r *http.Request, signTime time.Time) error {
+	components := s.Components
+	if len(components) == 0 {
+		components = []string{"@method", "@authority", "@path"}
+		if r.Body != nil && r.Body != http.NoBody {
+			components = append(components, "content-digest")
+		}
+	}
+	if err := addDateAndDigest(r, components, "date", "content-digest", signTime); err != nil {
+		return err
+	}
+
+	var quoted []string
+	for _, c := range components {
+		quoted = append(quoted, `"`+strings.ToLower(c)+`"`)
+	}
+	params := "(" + strings.Join(quoted, " ") + ");created=" + strconv.FormatInt(signTime.Unix(), 10)
+	if s.Expires > 0 {
+		params += ";expires=" + strconv.FormatInt(signTime.Add(s.Expires).Unix(), 10)
+	}
+	params += `;keyid="` + s.KeyId + `"`
+	if s.IncludeAlgorithm {
+		params += `;alg="` + s.Algorithm + `"`
+	}
+	if s.Tag != "" {
+		params += `;tag="` + s.Tag + `"`
+	}
+
+	base, err := SignatureBase(r, components, params)
+	if err != nil {
+		return err
+	}
+	signature, err := sign(s.Algorithm, s.Key, []byte(base))
+	if err != nil {
+		return err
+	}
+
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	r.Header.Set("Signature-Input", label+"="+params)
+	r.Header.Set("Signature", label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+
+	return nil
+}
+
+// SignatureBase builds the RFC 9421 signature base for the covered components
+// and serialized signature parameters.
+func SignatureBase(r *http.Request, components []string, params string) (string, error) {
+	var lines []string
+	for _, c := range components {
+		c = strings.ToLower(c)
+		var value string
+		switch c {
+		case "@method":
+			value = strings.ToUpper(r.Method)
+		case "@authority":
+			value = strings.ToLower(requestHost(r))
+		case "@scheme":
+			value = strings.ToLower(r.URL.Scheme)
+		case "@path":
+			value = r.URL.EscapedPath()
+			if value == "" {
+				value = "/"
+			}
+		case "@query":
+			value = "?" + r.URL.RawQuery
+		case "@request-target":
+			value = r.URL.RequestURI()
+		case "@target-uri":
+			value = r.URL.String()
+		default:
+			if strings.HasPrefix(c, "@") {
+				return "", fmt.Errorf("unsupported derived component %q", c)
+			}
+			var ok bool
+			if value, ok = headerValue(r, c); !ok {
+				return "", fmt.Errorf("can't sign missing header %q", c)
+			}
+		}
+		lines = append(lines, `"`+c+`": `+value)
+	}
+	lines = append(lines, `"@signature-params": `+params)
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// addDateAndDigest sets the date and body digest headers if they're signed
+// but not already on the request. The cavage digest header is
+// SHA-256=<base64>, RFC 9530's content-digest is sha-256=:<base64>:.
+func addDateAndDigest(r *http.Request, covered []string, dateHeader, digestHeader string, signTime time.Time) error {
+	for _, name := range covered {
+		switch strings.ToLower(name) {
+		case dateHeader:
+			if r.Header.Get(dateHeader) == "" {
+				r.Header.Set(dateHeader, signTime.UTC().Format(http.TimeFormat))
+			}
+		case digestHeader:
+			if r.Header.Get(digestHeader) != "" {
+				continue
+			}
+			body, err := signers.ReadBody(r)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(body)
+			digest := base64.StdEncoding.EncodeToString(sum[:])
+			if digestHeader == "digest" {
+				r.Header.Set("Digest", "SHA-256="+digest)
+			} else {
+				r.Header.Set("Content-Digest", "sha-256=:"+digest+":")
+			}
+		}
+	}
+
+	return nil
+}
+
+// headerValue returns the header's values trimmed and joined with ", ".
+func headerValue(r *http.Request, name string) (string, bool) {
+	if name == "host" {
+		return requestHost(r), true
+	}
+	values, ok := r.Header[http.CanonicalHeaderKey(name)]
+	if !ok && name == "content-length" && r.ContentLength > 0 {
+		return strconv.FormatInt(r.ContentLength, 10), true
+	}
+	if !ok {
+		return "", false
+	}
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.TrimSpace(v)
+	}
+
+	return strings.Join(trimmed, ", "), true
+}
+
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
+}
+
+func hs2019Algorithm(key interface{}) string {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-pss-sha512"
+	case *ecdsa.PrivateKey:
+		return "ecdsa-sha512"
+	case ed25519.PrivateKey:
+		return "ed25519"
+	}
+	return "hmac-sha512"
+}
+
+// sign signs data with the named algorithm.
+func sign(algorithm string, key interface{}, data []byte) ([]byte, error) {
+	switch algorithm {
+	case "hmac-sha256", "hmac-sha512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a shared secret", algorithm)
+		}
+		newHash := sha256.New
+		if algorithm == "hmac-sha512" {
+			newHash = sha512.New
+		}
+		mac := hmac.New(newHash, secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case "rsa-sha256", "rsa-v1_5-sha256":
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an RSA key", algorithm)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest(sha256.New(), data))
+	case "rsa-pss-sha512":
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an RSA key", algorithm)
+		}
+		return rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA512, digest(sha512.New(), data), &rsa.PSSOptions{SaltLength: 64})
+	case "ecdsa-sha256", "ecdsa-sha512":
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an ECDSA key", algorithm)
+		}
+		h := sha256.New()
+		if algorithm == "ecdsa-sha512" {
+			h = sha512.New()
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest(h, data))
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(ecdsaSignature{R: r, S: s})
+	case "ecdsa-p256-sha256":
+		// RFC 9421 uses the fixed size r || s encoding rather than ASN.1.
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an ECDSA key", algorithm)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest(sha256.New(), data))
+		if err != nil {
+			return nil, err
+		}
+		return append(fixedBytes(r, 32), fixedBytes(s, 32)...), nil
+	case "ed25519":
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an Ed25519 key", algorithm)
+		}
+		return ed25519.Sign(edKey, data), nil
+	}
+
+	return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+}
+
+func digest(h hash.Hash, data []byte) []byte {
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// The ASN.1 DER form of an ECDSA signature, used by the Cavage ecdsa
+//    algorithms.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func fixedBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,94 @@
+// Package signers holds the request signing schemes available to auth
+// helpers, each in its own package, and the Signer interface they share.
+package signers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	v4 "github.com/SREnity/epico/signers/aws_v4"
+)
+
+// Signer signs a request in place, typically by setting its Authorization
+// header. signTime is the time the signature is made at, so signatures can be
+// checked against fixed vectors.
+type Signer interface {
+	Sign(r *http.Request, signTime time.Time) error
+}
+
+// SignerFunc adapts a function to the Signer interface.
+type SignerFunc func(r *http.Request, signTime time.Time) error
+
+// Sign calls f(r, signTime).
+func (f SignerFunc) Sign(r *http.Request, signTime time.Time) error {
+	return f(r, signTime)
+}
+
+// AwsV4 adapts the aws_v4 signer, which also needs the service and region, to
+// the Signer interface.
+type AwsV4 struct {
+	Signer  *v4.Signer
+	Service string
+	Region  string
+}
+
+// Sign signs the request with AWS Signature Version 4.
+func (a AwsV4) Sign(r *http.Request, signTime time.Time) error {
+	body, err := ReadBody(r)
+	if err != nil {
+		return err
+	}
+
+	var reader io.ReadSeeker
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	if _, err := a.Signer.Sign(r, reader, a.Service, a.Region, signTime); err != nil {
+		return err
+	}
+	ResetBody(r, body)
+
+	return nil
+}
+
+// ReadBody reads the request's body, if any, and puts it back so it can still
+// be sent after being hashed.
+func ReadBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	ResetBody(r, body)
+
+	return body, nil
+}
+
+// ResetBody sets the request's body to a fresh reader over body.
+func ResetBody(r *http.Request, body []byte) {
+	if body == nil {
+		return
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+}
+
+// Sha256Hex returns the hex encoded SHA-256 of data, as most HMAC schemes
+// use for the payload hash.
+func Sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
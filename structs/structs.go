@@ -8,15 +8,89 @@ import (
 
 type ApiRoot struct {
 	Name            string              `yaml:"name"` // Required
-	VarsData        map[string][]string `yaml:"vars_data",omitempty`
-	Vars            map[string]string   `yaml:"vars",omitempty`
-	Paging          map[string]string   `yaml:"paging"` // Required
-	Plugin          string              `yaml:"plugin"` // Required
+	VarsData        map[string][]string `yaml:"vars_data,omitempty"`
+	VarsExpansion   VarsDataSettings    `yaml:"vars_data_expansion,omitempty"` // How vars_data lists are combined (default every combination)
+	VarsFrom        map[string]VarsFrom `yaml:"vars_from,omitempty"`           // vars_data lists discovered from an API call
+	Vars            map[string]string   `yaml:"vars,omitempty"`
+	Paging          map[string]string   `yaml:"paging"`              // Required
+	Plugin          string              `yaml:"plugin"`              // Required
+	AuthType        string              `yaml:"auth_type,omitempty"` // Built-in auth helper to use instead of the plugin's
 	AuthParams      []string            `yaml:"auth_params"`
 	PagingParams    []string            `yaml:"paging_params"`
 	Endpoints       []ApiEndpoint       `yaml:"endpoints"`
-	GlobalVars      map[string]string   `yaml:"global_vars",omitempty`       // Needed for substitutions in all the endpoints
+	GlobalVars      map[string]string   `yaml:"global_vars,omitempty"`       // Needed for substitutions in all the endpoints
 	SkipContentType bool                `yaml:"skip_content_type,omitempty"` // Needed for skipping setting Content-Type header to application/json
+	AuthTokenTtl    string              `yaml:"auth_token_ttl,omitempty"`    // How long session tokens are reused if the auth response has no expires_in
+	HttpClient      HttpClientSettings  `yaml:"http_client,omitempty"`       // Transport tuning for every request under this root
+	Tls             TlsSettings         `yaml:"tls,omitempty"`               // TLS for every request under this root, including token fetches
+	FanOut          FanOutSettings      `yaml:"fan_out,omitempty"`           // Runs the root once per region/account combination
+	MergeStrategy   string              `yaml:"merge_strategy,omitempty"`    // How endpoints' records colliding in the results are merged (default append)
+	Provenance      bool                `yaml:"provenance,omitempty"`        // Adds _epico metadata to every record on where it came from
+}
+
+// How the vars_data lists are combined into expanded configs.  By default
+//    every combination of the lists is expanded.
+type VarsDataSettings struct {
+	Zip     [][]string          `yaml:"zip,omitempty"`     // Groups of lists stepped through together, e.g. [[account_id, role_arn]]
+	Include []map[string]string `yaml:"include,omitempty"` // If set, only combinations matching one of these are expanded
+	Exclude []map[string]string `yaml:"exclude,omitempty"` // Combinations matching any of these are skipped
+}
+
+// An endpoint whose response supplies the values of a vars_data var, so
+//    configs keep up with the upstream inventory (e.g. every org the token can
+//    see).  It is run like any other endpoint, with the root's auth.
+type VarsFrom struct {
+	ApiEndpoint `yaml:",inline"`
+	Key         string `yaml:"key"`                 // Dot separated path to the values in the JSON response
+	CacheTtl    string `yaml:"cache_ttl,omitempty"` // Reuse the values found for this long (e.g. 1h) via the state store
+}
+
+// Region and account dimensions an API root is run across.  Each combination
+//    gets the aws_region, aws_account_id, aws_role_arn and aws_external_id
+//    global vars, and its records are tagged with the account and region.
+type FanOutSettings struct {
+	Regions     []string          `yaml:"regions,omitempty"`
+	RegionsFrom FanOutRegionsFrom `yaml:"regions_from,omitempty"` // Discover regions instead of listing them
+	Accounts    []FanOutAccount   `yaml:"accounts,omitempty"`
+}
+
+// An endpoint queried (per account) for the list of regions to fan out to.
+type FanOutRegionsFrom struct {
+	Endpoint string   `yaml:"endpoint,omitempty"`
+	Key      string   `yaml:"key,omitempty"`     // Dot separated path to the region names in the JSON response
+	Region   string   `yaml:"region,omitempty"`  // Region the discovery call is made in (default us-east-1)
+	Service  string   `yaml:"service,omitempty"` // Service the discovery call is signed for (default ec2)
+	Exclude  []string `yaml:"exclude,omitempty"` // Regions to skip
+}
+
+type FanOutAccount struct {
+	Id         string `yaml:"id,omitempty"` // Defaults to the account in the role ARN
+	RoleArn    string `yaml:"role_arn,omitempty"`
+	ExternalId string `yaml:"external_id,omitempty"`
+}
+
+// Settings for the http.Client shared by all requests under an API root.
+//    Durations are Go duration strings (e.g. "30s").
+type HttpClientSettings struct {
+	Timeout             string `yaml:"timeout,omitempty"`                 // Whole request timeout (default 60s)
+	DialTimeout         string `yaml:"dial_timeout,omitempty"`            // Default 30s
+	TlsHandshakeTimeout string `yaml:"tls_handshake_timeout,omitempty"`   // Default 10s
+	IdleConnTimeout     string `yaml:"idle_conn_timeout,omitempty"`       // Default 90s
+	MaxIdleConns        int    `yaml:"max_idle_conns,omitempty"`          // Default 100
+	MaxIdleConnsPerHost int    `yaml:"max_idle_conns_per_host,omitempty"` // Default 10
+	MaxConnsPerHost     int    `yaml:"max_conns_per_host,omitempty"`      // Default unlimited
+	DisableHttp2        bool   `yaml:"disable_http2,omitempty"`
+	ProxyUrl            string `yaml:"proxy_url,omitempty"` // Defaults to HTTP(S)_PROXY/NO_PROXY from the environment
+}
+
+// TLS settings applied to every connection made for an API root.
+type TlsSettings struct {
+	CaFile             string `yaml:"ca_file,omitempty"`              // PEM bundle added to the system roots
+	CertFile           string `yaml:"cert_file,omitempty"`            // PEM client certificate for mTLS
+	KeyFile            string `yaml:"key_file,omitempty"`             // PEM client key for mTLS
+	ServerName         string `yaml:"server_name,omitempty"`          // Overrides the name verified against the server certificate
+	MinVersion         string `yaml:"min_version,omitempty"`          // "1.0", "1.1", "1.2" (default) or "1.3"
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"` // Disables certificate verification - testing only!
 }
 
 type ApiEndpoint struct {
@@ -26,6 +100,7 @@ type ApiEndpoint struct {
 	SkipEndpoint      map[string][]string `yaml:"skip_endpoint,omitempty"`            // Optional
 	Paging            map[string]string   `yaml:"paging,omitempty"`                   // Optional
 	Return            string              `yaml:"return,omitempty"`                   // Optional
+	MergeStrategy     string              `yaml:"merge_strategy,omitempty"`           // Optional - overrides the root's merge_strategy
 	UseForConnCheck   bool                `yaml:"use_for_connection_check,omitempty"` // Optional
 	SkipForScans      bool                `yaml:"skip_for_scans,omitempty"`           // Optional
 	Endpoint          string              `yaml:"endpoint"`                           // Required
@@ -35,9 +110,61 @@ type ApiEndpoint struct {
 	DesiredErrorKey   []string            `yaml:"desired_error_key,omitempty"`        // Managing APIs that return a dict => list
 	EndpointKeyNames  map[string]string   `yaml:"endpoint_key_names,omitempty"`       // Needed for adding endpoint key to sub-endpoint JSON
 	EndpointKeyValues map[string]interface{}
-	Documentation     string                   `yaml:"documentation,omitempty"` // Optional
-	Params            ApiParams                `yaml:"params,flow,omitempty"`   // Optional
-	Endpoints         map[string][]ApiEndpoint `yaml:"endpoints,omitempty"`     // Iterating Key => Endpoint
+	ParentContext     []map[string]interface{} `yaml:"-"`                           // Set on sub-endpoints - see utils.SubEndpointKey
+	Documentation     string                   `yaml:"documentation,omitempty"`     // Optional
+	Params            ApiParams                `yaml:"params,flow,omitempty"`       // Optional
+	Endpoints         map[string][]ApiEndpoint `yaml:"endpoints,omitempty"`         // Iterating Key => Endpoint
+	Graphql           GraphqlSettings          `yaml:"graphql,omitempty"`           // Sends a GraphQL query instead of a GET
+	TimeWindow        TimeWindowSettings       `yaml:"time_window,omitempty"`       // Runs the endpoint once per window of a time range
+	Transform         []TransformStep          `yaml:"transform,omitempty"`         // Reshapes the records at each base key before they're merged
+	ResponseFormat    string                   `yaml:"response_format,omitempty"`   // json (default), xml, csv, tsv, ndjson or yaml
+	ResponseDecoding  ResponseDecodingSettings `yaml:"response_decoding,omitempty"` // Options for decoding non-JSON responses
+}
+
+// One step of an endpoint's transform pipeline, which is applied in order to
+//    the records at each base key.  Each step sets one of the fields.  Fields
+//    are named by dot separated paths, and expressions are JMESPath
+//    (https://jmespath.org) evaluated against each record.
+type TransformStep struct {
+	Select   []string          `yaml:"select,omitempty"`   // Fields to keep, dropping the rest
+	Rename   map[string]string `yaml:"rename,omitempty"`   // Old field => new field
+	Flatten  string            `yaml:"flatten,omitempty"`  // Separator nested objects are flattened with, e.g. _ for owner_login
+	Coerce   map[string]string `yaml:"coerce,omitempty"`   // Field => string, int, float or bool
+	Compute  map[string]string `yaml:"compute,omitempty"`  // Field => expression setting it
+	Filter   string            `yaml:"filter,omitempty"`   // Expression records are kept if true for
+	Jmespath string            `yaml:"jmespath,omitempty"` // Expression applied to the list of records, returning the new list
+}
+
+// A time range an endpoint is run over in windows, e.g. a day at a time.  Each
+//    window sets the window_start and window_end vars (RFC3339), for use in
+//    {{time:window_start|format}} tokens.  Times are {{time:...}} expressions
+//    without the braces, e.g. -720h or 2024-01-01T00:00:00Z.
+type TimeWindowSettings struct {
+	Start      string `yaml:"start,omitempty"`
+	End        string `yaml:"end,omitempty"`        // Default now
+	Size       string `yaml:"size,omitempty"`       // Window length, e.g. 24h
+	Checkpoint bool   `yaml:"checkpoint,omitempty"` // Resume from the last completed window on later runs
+}
+
+// How an endpoint's responses are decoded to JSON when its response_format
+//    isn't json.  Fields are named by dot separated paths from the root
+//    element.
+type ResponseDecodingSettings struct {
+	XmlArrays          []string          `yaml:"xml_arrays,omitempty"`           // Elements always decoded as lists, even when there is only one
+	XmlAttributePrefix string            `yaml:"xml_attribute_prefix,omitempty"` // Prefix for attribute keys (default "-")
+	CsvColumns         []string          `yaml:"csv_columns,omitempty"`          // Column names, for responses without a header row
+	CsvHeaderMap       map[string]string `yaml:"csv_header_map,omitempty"`       // Renames columns, e.g. { "Account ID": account_id }
+}
+
+// A GraphQL query POSTed to the endpoint in place of a REST call.  Relay style
+//    connections are paged by passing pageInfo.endCursor back in as the
+//    cursor variable until pageInfo.hasNextPage is false.
+type GraphqlSettings struct {
+	Query          string                 `yaml:"query,omitempty"`
+	OperationName  string                 `yaml:"operation_name,omitempty"`
+	Variables      map[string]interface{} `yaml:"variables,omitempty"`       // Strings may use {{var}} substitutions
+	PageInfo       string                 `yaml:"page_info,omitempty"`       // Dot separated path to the pageInfo to page on (default: the first found)
+	CursorVariable string                 `yaml:"cursor_variable,omitempty"` // Variable the cursor is passed in (default "after")
 }
 
 type ApiRequest struct {
@@ -53,6 +180,10 @@ type ApiRequest struct {
 	FullRequest *http.Request
 	Client      *http.Client
 
+	// Set by auth functions that cache tokens so a rejected token can be
+	//    invalidated and the request retried.
+	AuthCacheKey string
+
 	AttemptTime time.Time
 	Time        time.Time
 }
@@ -65,17 +196,26 @@ type ComparableApiRequest struct {
 	AttemptTime       time.Time
 	Time              time.Time
 	ResponseCode      int
+	// The URL requested, without its querystring, and the page of the call
+	//    it was (from 0) - see utils.RecordProvenance.
+	Url  string
+	Page int
 }
 
 type ApiRequestInheritableSettings struct {
 	Name            string
-	Vars            map[string]string `yaml:"vars",omitempty`
+	Vars            map[string]string `yaml:"vars,omitempty"`
 	Paging          map[string]string
 	Plugin          string            `yaml:"plugin"` // Required
 	AuthParams      []string          `yaml:"auth_params"`
 	PagingParams    []string          `yaml:"paging_params"`
 	GlobalVars      map[string]string `yaml:"global_vars,omitempty"`       // Needed for substitutions in all the endpoints
 	SkipContentType bool              `yaml:"skip_content_type,omitempty"` // Skip setting content-type header to application/json
+	AuthTokenTtl    string            `yaml:"auth_token_ttl,omitempty"`    // Session token reuse duration
+	RecordTags      map[string]string // Added to every record returned, e.g. the fan out account and region
+	VarsData        map[string]string // The vars_data (and vars_from) values of this combination of the root
+	MergeStrategy   string            `yaml:"merge_strategy,omitempty"` // Default for the endpoints
+	Provenance      bool              `yaml:"provenance,omitempty"`     // Adds _epico metadata to every record
 }
 
 type ApiParams struct {
@@ -125,6 +265,7 @@ func (a ApiEndpoint) Copy() ApiEndpoint {
 		returnApiEndpoint.Paging[k] = v
 	}
 	returnApiEndpoint.Return = a.Return
+	returnApiEndpoint.MergeStrategy = a.MergeStrategy
 	returnApiEndpoint.Endpoint = a.Endpoint
 	for _, v := range a.CurrentBaseKey {
 		returnApiEndpoint.CurrentBaseKey = append(
@@ -144,6 +285,17 @@ func (a ApiEndpoint) Copy() ApiEndpoint {
 	}
 	returnApiEndpoint.Documentation = a.Documentation
 	returnApiEndpoint.Params = a.Params.Copy()
+	returnApiEndpoint.Graphql = a.Graphql
+	returnApiEndpoint.TimeWindow = a.TimeWindow
+	returnApiEndpoint.Transform = a.Transform
+	returnApiEndpoint.ResponseFormat = a.ResponseFormat
+	returnApiEndpoint.ResponseDecoding = a.ResponseDecoding
+	if a.Graphql.Variables != nil {
+		returnApiEndpoint.Graphql.Variables = make(map[string]interface{})
+		for k, v := range a.Graphql.Variables {
+			returnApiEndpoint.Graphql.Variables[k] = v
+		}
+	}
 	returnApiEndpoint.Endpoints = make(map[string][]ApiEndpoint)
 	for k, v := range a.Endpoints {
 		for _, sv := range v {
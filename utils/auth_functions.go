@@ -0,0 +1,33 @@
+package utils
+
+import (
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// AuthFunctions maps the auth_type names usable in YAML configs to the
+//    built-in auth helpers.  Setting auth_type on an API root uses the helper
+//    in place of the plugin's PluginAuthFunction.  Plugins and callers may
+//    register their own helpers here before running PullApiData.
+var AuthFunctions = map[string]func(generic_structs.ApiRequest, []string) generic_structs.ApiRequest{
+	"basic":                     BasicAuth,
+	"custom_header":             CustomHeaderAuth,
+	"custom_querystring":        CustomQuerystringAuth,
+	"custom_header_and_basic":   CustomHeaderAndBasicAuth,
+	"session_token":             SessionTokenAuth,
+	"onelogin":                  OneloginAuth,
+	"jwt":                       JwtAuth,
+	"oauth2_client_credentials": Oauth2TwoLegAuth,
+	"oauth2_refresh_token":      Oauth2RefreshTokenAuth,
+	"oauth2_password":           Oauth2PasswordAuth,
+	"oauth2_private_key_jwt":    Oauth2PrivateKeyJwtAuth,
+	"oauth2_device_code":        Oauth2DeviceCodeAuth,
+	"aws_v4":                    AwsV4Auth,
+	"aws_v4_assume_role":        AwsV4AssumeRoleAuth,
+	"aws_v4a":                   AwsV4aAuth,
+	"alibaba_acs3":              AlibabaAcs3Auth,
+	"tencent_tc3":               TencentTc3Auth,
+	"azure_shared_key":          AzureSharedKeyAuth,
+	"gcp_jwt":                   GcpJwtAuth,
+	"http_signature":            HttpSignatureAuth,
+	"http_message_signature":    HttpMessageSignatureAuth,
+}
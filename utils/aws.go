@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	v4 "github.com/SREnity/epico/signers/aws_v4"
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// Returns the time AWS requests are signed at.  Replaced in tests so
+//    signatures can be checked against fixed vectors.
+var awsSignTime = time.Now
+
+// AWS credential providers are kept for the life of the process so temporary
+//    credentials are reused across requests and only refreshed near expiry.
+var awsCredentialsCache = struct {
+	sync.Mutex
+	providers map[string]v4.CredentialsProvider
+}{providers: make(map[string]v4.CredentialsProvider)}
+
+// Returns the cached provider for the key, creating it with newProvider the
+//    first time.
+func cachedAwsCredentials(key string, newProvider func() v4.CredentialsProvider) v4.CredentialsProvider {
+	awsCredentialsCache.Lock()
+	defer awsCredentialsCache.Unlock()
+
+	provider, ok := awsCredentialsCache.providers[key]
+	if !ok {
+		provider = newProvider()
+		awsCredentialsCache.providers[key] = provider
+	}
+
+	return provider
+}
+
+// Auth function for AWS Signature Version 4.  Signs the request headers, or
+//    the query string if a presign expiry is given, and re-attaches any body
+//    so POST requests are sent with the payload that was signed.  Region and
+//    service fall back to the aws_region and aws_service vars so one root can
+//    be expanded across regions with vars_data.  If the access key and secret
+//    are blank, credentials come from the default chain - environment, web
+//    identity token, then the shared config profile.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = AWS params in the order of:
+//              [0] => access key ID (optional - see above)
+//              [1] => secret access key (optional - see above)
+//              [2] => session token (optional)
+//              [3] => region (optional if the aws_region var is set)
+//              [4] => service (optional if the aws_service var is set)
+//              [5] => presign expiry duration, e.g. 5m (optional - presigns
+//                     the URL instead of setting the Authorization header)
+func AwsV4Auth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	return awsV4Sign("AwsV4Auth", apiRequest, awsKeyOrDefaultCredentials(authParams), authParam(authParams, 3), authParam(authParams, 4), authParam(authParams, 5))
+}
+
+// Auth function for AWS SigV4a, e.g. for S3 multi-region access points.  Takes
+//    the same params as AwsV4Auth, but the region is the comma separated set
+//    of regions the signature is valid for, defaulting to all regions (*).
+func AwsV4aAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	regionSet := authParam(authParams, 3)
+	if regionSet == "" {
+		regionSet = "*"
+	}
+
+	return awsV4Sign("AwsV4aAuth", apiRequest, awsKeyOrDefaultCredentials(authParams), regionSet, authParam(authParams, 4), authParam(authParams, 5), v4.WithSigV4a)
+}
+
+// Auth function for AWS Signature Version 4 using temporary credentials from
+//    STS AssumeRole, e.g. for scanning other accounts.  The role's credentials
+//    are cached and re-assumed shortly before they expire.  Source credentials
+//    come from the named shared config profile, or the default chain.
+//    The role ARN and external ID fall back to the aws_role_arn and
+//    aws_external_id vars, which fan_out sets per account.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = AWS params in the order of:
+//              [0] => role ARN (optional if the aws_role_arn var is set)
+//              [1] => external ID (optional)
+//              [2] => region (optional if the aws_region var is set)
+//              [3] => service (optional if the aws_service var is set)
+//              [4] => source profile (optional)
+//              [5] => STS endpoint URL (optional - defaults to the regional
+//                     endpoint when a region is set)
+func AwsV4AssumeRoleAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	roleArn := authParam(authParams, 0)
+	externalId := authParam(authParams, 1)
+	if roleArn == "" {
+		roleArn = apiRequest.Settings.Vars["aws_role_arn"]
+		externalId = apiRequest.Settings.Vars["aws_external_id"]
+	}
+	if roleArn == "" {
+		LogError("AwsV4AssumeRoleAuth", "A role ARN is required in auth_params or the aws_role_arn var", nil)
+		return generic_structs.ApiRequest{}
+	}
+	region := authParam(authParams, 2)
+	if region == "" {
+		region = apiRequest.Settings.Vars["aws_region"]
+	}
+
+	client := httpClientFor(apiRequest)
+	key := tokenCacheKey(append([]string{"assume_role", roleArn, externalId, region, fmt.Sprintf("%p", client)}, authParams...)...)
+	provider := cachedAwsCredentials(key, func() v4.CredentialsProvider {
+		var source v4.CredentialsProvider
+		if profile := authParam(authParams, 4); profile != "" {
+			source = &v4.SharedConfigProvider{Profile: profile, Client: client}
+		} else {
+			source = cachedAwsCredentials("default", v4.NewDefaultCredentialsChain)
+		}
+		return v4.NewCredentialsCache(&v4.AssumeRoleProvider{
+			Source:     source,
+			RoleArn:    roleArn,
+			ExternalId: externalId,
+			Region:     region,
+			Endpoint:   authParam(authParams, 5),
+			Client:     client,
+		})
+	})
+
+	return awsV4Sign("AwsV4AssumeRoleAuth", apiRequest, provider, region, authParam(authParams, 3), "")
+}
+
+// Returns the access key, secret and session token in authParams [0]-[2], or
+//    the default chain if the key and secret are blank.
+func awsKeyOrDefaultCredentials(authParams []string) v4.CredentialsProvider {
+	if authParam(authParams, 0) == "" && authParam(authParams, 1) == "" {
+		return cachedAwsCredentials("default", v4.NewDefaultCredentialsChain)
+	}
+
+	return v4.StaticProvider{Value: generic_structs.ApiCredentials{
+		Id:    authParam(authParams, 0),
+		Key:   authParam(authParams, 1),
+		Token: authParam(authParams, 2),
+	}}
+}
+
+// Signs (or presigns) the request with credentials from the provider, keeping
+//    the body intact.  The service's own signer options (e.g. S3's path
+//    escaping) are applied before any passed in.
+func awsV4Sign(authName string, apiRequest generic_structs.ApiRequest, provider v4.CredentialsProvider, region string, service string, presignExpiry string, options ...func(*v4.Signer)) generic_structs.ApiRequest {
+	if apiRequest.FullRequest == nil {
+		LogError(authName, "No request to sign", nil)
+		return generic_structs.ApiRequest{}
+	}
+	if region == "" {
+		region = apiRequest.Settings.Vars["aws_region"]
+	}
+	if service == "" {
+		service = apiRequest.Settings.Vars["aws_service"]
+	}
+	if region == "" || service == "" {
+		LogError(authName, "Region and service are required in auth_params or the aws_region and aws_service vars", nil)
+		return generic_structs.ApiRequest{}
+	}
+
+	var body []byte
+	if apiRequest.FullRequest.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(apiRequest.FullRequest.Body)
+		apiRequest.FullRequest.Body.Close()
+		if err != nil {
+			LogError(authName, "Unable to read request body", err)
+			return generic_structs.ApiRequest{}
+		}
+	}
+	bodyReader := bytes.NewReader(body)
+
+	options = append(append(v4.ServiceOptions(service), v4.WithCredentialsProvider(provider)), options...)
+	signer := v4.NewSigner(generic_structs.ApiCredentials{}, options...)
+	var err error
+	if presignExpiry != "" {
+		var expiry time.Duration
+		expiry, err = time.ParseDuration(presignExpiry)
+		if err != nil {
+			LogError(authName, "Invalid presign expiry", err)
+			return generic_structs.ApiRequest{}
+		}
+		_, err = signer.Presign(apiRequest.FullRequest, bodyReader, service, region, expiry, awsSignTime())
+	} else {
+		_, err = signer.Sign(apiRequest.FullRequest, bodyReader, service, region, awsSignTime())
+	}
+	if err != nil {
+		LogError(authName, fmt.Sprintf("Unable to sign %s request", service), err)
+		return generic_structs.ApiRequest{}
+	}
+
+	// Presigning leaves the body alone and signing wraps the reader we've
+	//    already hashed, so reset it either way.
+	if body != nil {
+		apiRequest.FullRequest.Body = ioutil.NopCloser(bytes.NewReader(body))
+		apiRequest.FullRequest.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		apiRequest.FullRequest.ContentLength = int64(len(body))
+	}
+
+	return apiRequest
+}
+
+// Returns the auth param at index, or blank if there are fewer params.
+func authParam(authParams []string, index int) string {
+	if len(authParams) > index {
+		return authParams[index]
+	}
+
+	return ""
+}
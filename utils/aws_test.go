@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// Builds the same request as the aws_v4 signer tests so the helper can be
+//    checked against their vectors.
+func newTestAwsApiRequest(t *testing.T, body string) generic_structs.ApiRequest {
+	req, err := http.NewRequest("POST", "https://dynamodb.us-east-1.amazonaws.com", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.URL.Opaque = "//example.org/bucket/key-._~,!@#$%^&*()"
+	req.Header.Set("X-Amz-Target", "prefix.Operation")
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("Content-Length", "2")
+	req.Header.Set("X-Amz-Meta-Other-Header", "some-value=!@#$%^&* (+)")
+	req.Header.Add("X-Amz-Meta-Other-Header_With_Underscore", "some-value=!@#$%^&* (+)")
+	req.Header.Add("X-amz-Meta-Other-Header_With_Underscore", "some-value=!@#$%^&* (+)")
+
+	return generic_structs.ApiRequest{Endpoint: req.URL.String(), FullRequest: req}
+}
+
+func withAwsSignTime(t time.Time) func() {
+	awsSignTime = func() time.Time { return t }
+	return func() { awsSignTime = time.Now }
+}
+
+func TestAwsV4AuthSign(t *testing.T) {
+	defer withAwsSignTime(time.Unix(0, 0))()
+
+	apiRequest := AwsV4Auth(newTestAwsApiRequest(t, "{}"), []string{"AKID", "SECRET", "SESSION", "us-east-1", "dynamodb"})
+	if apiRequest.FullRequest == nil {
+		t.Fatalf("expect request to be signed")
+	}
+
+	expectedSig := "AWS4-HMAC-SHA256 Credential=AKID/19700101/us-east-1/dynamodb/aws4_request, SignedHeaders=content-length;content-type;host;x-amz-date;x-amz-meta-other-header;x-amz-meta-other-header_with_underscore;x-amz-security-token;x-amz-target, Signature=a518299330494908a70222cec6899f6f32f297f8595f6df1776d998936652ad9"
+	if e, a := expectedSig, apiRequest.FullRequest.Header.Get("Authorization"); e != a {
+		t.Errorf("expect\n%v\nactual\n%v", e, a)
+	}
+	if e, a := "SESSION", apiRequest.FullRequest.Header.Get("X-Amz-Security-Token"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	// The POST body must still be there to send after hashing it.
+	body, _ := ioutil.ReadAll(apiRequest.FullRequest.Body)
+	if e, a := "{}", string(body); e != a {
+		t.Errorf("expect body %v, got %v", e, a)
+	}
+}
+
+func TestAwsV4AuthPresign(t *testing.T) {
+	defer withAwsSignTime(time.Unix(0, 0))()
+
+	apiRequest := AwsV4Auth(newTestAwsApiRequest(t, "{}"), []string{"AKID", "SECRET", "SESSION", "us-east-1", "dynamodb", "300s"})
+	if apiRequest.FullRequest == nil {
+		t.Fatalf("expect request to be presigned")
+	}
+
+	q := apiRequest.FullRequest.URL.Query()
+	if e, a := "122f0b9e091e4ba84286097e2b3404a1f1f4c4aad479adda95b7dff0ccbe5581", q.Get("X-Amz-Signature"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := "AKID/19700101/us-east-1/dynamodb/aws4_request", q.Get("X-Amz-Credential"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := "300", q.Get("X-Amz-Expires"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if a := apiRequest.FullRequest.Header.Get("Authorization"); a != "" {
+		t.Errorf("expect no Authorization header when presigning, got %v", a)
+	}
+}
+
+func TestAwsV4AuthVarsFallback(t *testing.T) {
+	defer withAwsSignTime(time.Unix(0, 0))()
+
+	apiRequest := newTestAwsApiRequest(t, "{}")
+	apiRequest.Settings.Vars = map[string]string{"aws_region": "us-east-1", "aws_service": "dynamodb"}
+	apiRequest = AwsV4Auth(apiRequest, []string{"AKID", "SECRET", "SESSION"})
+	if apiRequest.FullRequest == nil {
+		t.Fatalf("expect request to be signed")
+	}
+	if a := apiRequest.FullRequest.Header.Get("Authorization"); !strings.HasSuffix(a, "a518299330494908a70222cec6899f6f32f297f8595f6df1776d998936652ad9") {
+		t.Errorf("expect vars region and service to give the same signature, got %v", a)
+	}
+
+	if apiRequest := AwsV4Auth(newTestAwsApiRequest(t, "{}"), []string{"AKID", "SECRET"}); apiRequest.FullRequest != nil {
+		t.Errorf("expect failure without a region and service")
+	}
+	if apiRequest := AwsV4Auth(newTestAwsApiRequest(t, "{}"), []string{"AKID", "SECRET", "", "us-east-1", "dynamodb", "soon"}); apiRequest.FullRequest != nil {
+		t.Errorf("expect failure with an invalid presign expiry")
+	}
+}
+
+func TestAwsV4AssumeRoleAuth(t *testing.T) {
+	var stsCalls int32
+	stsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&stsCalls, 1)
+		r.ParseForm()
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=SOURCEKEY/") || r.Form.Get("ExternalId") != "ext-123" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>ROLEKEY</AccessKeyId><SecretAccessKey>ROLESECRET</SecretAccessKey><SessionToken>ROLETOKEN</SessionToken><Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+			time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer stsServer.Close()
+
+	dir, err := ioutil.TempDir("", "epico-aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	credentialsFile := filepath.Join(dir, "credentials")
+	ioutil.WriteFile(credentialsFile, []byte("[source]\naws_access_key_id = SOURCEKEY\naws_secret_access_key = SOURCESECRET\n"), 0600)
+	defer os.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.Getenv("AWS_SHARED_CREDENTIALS_FILE"))
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+
+	authParams := []string{"arn:aws:iam::123456789012:role/audit", "ext-123", "us-west-2", "ec2", "source", stsServer.URL}
+	for i := 0; i < 2; i++ {
+		apiRequest := AwsV4AssumeRoleAuth(newTestApiRequest(t, "https://ec2.us-west-2.amazonaws.com/?Action=DescribeInstances"), authParams)
+		if apiRequest.FullRequest == nil {
+			t.Fatalf("expect request to be signed")
+		}
+		if a := apiRequest.FullRequest.Header.Get("Authorization"); !strings.Contains(a, "Credential=ROLEKEY/") || !strings.Contains(a, "/us-west-2/ec2/") {
+			t.Errorf("expect role credentials for us-west-2 ec2, got %v", a)
+		}
+		if e, a := "ROLETOKEN", apiRequest.FullRequest.Header.Get("X-Amz-Security-Token"); e != a {
+			t.Errorf("expect %v, got %v", e, a)
+		}
+	}
+	if e, a := int32(1), atomic.LoadInt32(&stsCalls); e != a {
+		t.Errorf("expect role to be assumed %v time, got %v", e, a)
+	}
+
+	authParams[1] = "wrong"
+	if apiRequest := AwsV4AssumeRoleAuth(newTestApiRequest(t, "https://ec2.us-west-2.amazonaws.com/"), authParams); apiRequest.FullRequest != nil {
+		t.Errorf("expect failure when the role can't be assumed")
+	}
+}
+
+func TestAwsV4AuthServiceOptions(t *testing.T) {
+	apiRequest := AwsV4Auth(newTestApiRequest(t, "https://examplebucket.s3.amazonaws.com/reports/2020%2F01.csv"), []string{"AKID", "SECRET", "", "us-east-1", "s3"})
+	if apiRequest.FullRequest == nil {
+		t.Fatalf("expect request to be signed")
+	}
+	if e, a := "UNSIGNED-PAYLOAD", apiRequest.FullRequest.Header.Get("X-Amz-Content-Sha256"); e != a {
+		t.Errorf("expect S3 requests to use %v, got %v", e, a)
+	}
+}
+
+func TestAwsV4aAuth(t *testing.T) {
+	apiRequest := AwsV4aAuth(newTestApiRequest(t, "https://mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com/object"), []string{"AKID", "SECRET", "", "", "s3"})
+	if apiRequest.FullRequest == nil {
+		t.Fatalf("expect request to be signed")
+	}
+	if a := apiRequest.FullRequest.Header.Get("Authorization"); !strings.HasPrefix(a, "AWS4-ECDSA-P256-SHA256 Credential=AKID/") || !strings.Contains(a, "/s3/aws4_request") {
+		t.Errorf("expect a SigV4a signature, got %v", a)
+	}
+	if e, a := "*", apiRequest.FullRequest.Header.Get("X-Amz-Region-Set"); e != a {
+		t.Errorf("expect region set %v, got %v", e, a)
+	}
+}
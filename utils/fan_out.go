@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// FanOutCombination is one account/region pair an API root is run for.
+type FanOutCombination struct {
+	AccountId  string
+	RoleArn    string
+	ExternalId string
+	Region     string
+}
+
+// Returns the global vars for the combination - aws_region, aws_account_id,
+//    aws_role_arn and aws_external_id - leaving out any that are blank.  The
+//    AWS auth helpers fall back to these when their params are blank.
+func (f FanOutCombination) Vars() map[string]string {
+	vars := make(map[string]string)
+	for k, v := range map[string]string{
+		"aws_region":      f.Region,
+		"aws_account_id":  f.AccountId,
+		"aws_role_arn":    f.RoleArn,
+		"aws_external_id": f.ExternalId,
+	} {
+		if v != "" {
+			vars[k] = v
+		}
+	}
+
+	return vars
+}
+
+// Returns the tags added to every record fetched for the combination.
+func (f FanOutCombination) Tags() map[string]string {
+	tags := make(map[string]string)
+	if f.AccountId != "" {
+		tags["aws_account_id"] = f.AccountId
+	}
+	if f.Region != "" {
+		tags["aws_region"] = f.Region
+	}
+
+	return tags
+}
+
+// Pulls the account ID out of an ARN such as
+//    arn:aws:iam::123456789012:role/audit - blank if it isn't an ARN.
+func AccountIdFromArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+
+	return parts[4]
+}
+
+// Expands the fan_out settings into every account/region combination.
+//    Regions are the listed ones plus any discovered for each account via
+//    discoverRegions when regions_from is set, less the excluded ones.
+//    Returns nothing if no fan out is configured so the root runs as usual.
+// Vars:
+// settings        = The fan_out settings from the API root.
+// discoverRegions = Called per account to query regions_from.
+func ExpandFanOut(settings generic_structs.FanOutSettings, discoverRegions func(generic_structs.FanOutAccount) ([]string, error)) ([]FanOutCombination, error) {
+	discover := settings.RegionsFrom.Endpoint != ""
+	if len(settings.Regions) == 0 && len(settings.Accounts) == 0 && !discover {
+		return nil, nil
+	}
+
+	accounts := settings.Accounts
+	if len(accounts) == 0 {
+		// Regions only - run them all with the root's own credentials.
+		accounts = []generic_structs.FanOutAccount{{}}
+	}
+
+	var combinations []FanOutCombination
+	for _, account := range accounts {
+		accountId := account.Id
+		if accountId == "" {
+			accountId = AccountIdFromArn(account.RoleArn)
+		}
+
+		regions := append([]string{}, settings.Regions...)
+		if discover {
+			discovered, err := discoverRegions(account)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to discover regions for account %q: %s", accountId, err.Error())
+			}
+			regions = append(regions, discovered...)
+		}
+
+		seen := make(map[string]bool)
+		accountCombinations := 0
+		for _, region := range regions {
+			if region == "" || seen[region] || StringInSlice(region, settings.RegionsFrom.Exclude) > -1 {
+				continue
+			}
+			seen[region] = true
+			accountCombinations++
+			combinations = append(combinations, FanOutCombination{
+				AccountId:  accountId,
+				RoleArn:    account.RoleArn,
+				ExternalId: account.ExternalId,
+				Region:     region,
+			})
+		}
+		if len(regions) == 0 {
+			// Accounts only (e.g. global services) - one run per account.
+			combinations = append(combinations, FanOutCombination{
+				AccountId:  accountId,
+				RoleArn:    account.RoleArn,
+				ExternalId: account.ExternalId,
+			})
+		} else if accountCombinations == 0 {
+			LogWarning("ExpandFanOut", fmt.Sprintf("No regions left to scan for account %q", accountId))
+		}
+	}
+
+	return combinations, nil
+}
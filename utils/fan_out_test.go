@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+func TestExpandFanOut(t *testing.T) {
+	noDiscovery := func(generic_structs.FanOutAccount) ([]string, error) {
+		t.Fatalf("expect no region discovery")
+		return nil, nil
+	}
+
+	combinations, err := ExpandFanOut(generic_structs.FanOutSettings{}, noDiscovery)
+	if err != nil || combinations != nil {
+		t.Errorf("expect no combinations without fan_out, got %v (%v)", combinations, err)
+	}
+
+	settings := generic_structs.FanOutSettings{
+		Regions: []string{"us-east-1", "eu-west-1", "us-east-1"},
+		Accounts: []generic_structs.FanOutAccount{
+			{RoleArn: "arn:aws:iam::111111111111:role/audit", ExternalId: "ext-1"},
+			{Id: "prod", RoleArn: "arn:aws:iam::222222222222:role/audit"},
+		},
+	}
+	combinations, err = ExpandFanOut(settings, noDiscovery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FanOutCombination{
+		{AccountId: "111111111111", RoleArn: "arn:aws:iam::111111111111:role/audit", ExternalId: "ext-1", Region: "us-east-1"},
+		{AccountId: "111111111111", RoleArn: "arn:aws:iam::111111111111:role/audit", ExternalId: "ext-1", Region: "eu-west-1"},
+		{AccountId: "prod", RoleArn: "arn:aws:iam::222222222222:role/audit", Region: "us-east-1"},
+		{AccountId: "prod", RoleArn: "arn:aws:iam::222222222222:role/audit", Region: "eu-west-1"},
+	}
+	if !reflect.DeepEqual(expected, combinations) {
+		t.Errorf("expect\n%v\ngot\n%v", expected, combinations)
+	}
+
+	// Accounts without regions still run once each.
+	combinations, _ = ExpandFanOut(generic_structs.FanOutSettings{Accounts: settings.Accounts}, noDiscovery)
+	if e, a := 2, len(combinations); e != a || combinations[0].Region != "" {
+		t.Errorf("expect %v region-less combinations, got %v", e, combinations)
+	}
+}
+
+func TestExpandFanOutDiscovery(t *testing.T) {
+	settings := generic_structs.FanOutSettings{
+		RegionsFrom: generic_structs.FanOutRegionsFrom{Endpoint: "https://ec2.amazonaws.com/", Key: "regions", Exclude: []string{"ap-east-1"}},
+		Accounts: []generic_structs.FanOutAccount{
+			{RoleArn: "arn:aws:iam::111111111111:role/audit"},
+			{RoleArn: "arn:aws:iam::222222222222:role/audit"},
+		},
+	}
+	// Each account can have different regions enabled.
+	enabled := map[string][]string{
+		"arn:aws:iam::111111111111:role/audit": {"us-east-1", "ap-east-1"},
+		"arn:aws:iam::222222222222:role/audit": {"us-east-1", "eu-west-1"},
+	}
+	combinations, err := ExpandFanOut(settings, func(account generic_structs.FanOutAccount) ([]string, error) {
+		return enabled[account.RoleArn], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, c := range combinations {
+		actual = append(actual, c.AccountId+"/"+c.Region)
+	}
+	if e := []string{"111111111111/us-east-1", "222222222222/us-east-1", "222222222222/eu-west-1"}; !reflect.DeepEqual(e, actual) {
+		t.Errorf("expect %v, got %v", e, actual)
+	}
+
+	_, err = ExpandFanOut(settings, func(generic_structs.FanOutAccount) ([]string, error) {
+		return nil, errors.New("AccessDenied")
+	})
+	if err == nil {
+		t.Errorf("expect discovery errors to be returned")
+	}
+}
+
+func TestFanOutCombinationVarsAndTags(t *testing.T) {
+	combination := FanOutCombination{AccountId: "111111111111", RoleArn: "arn:aws:iam::111111111111:role/audit", Region: "us-east-1"}
+	if e, a := map[string]string{"aws_account_id": "111111111111", "aws_role_arn": "arn:aws:iam::111111111111:role/audit", "aws_region": "us-east-1"}, combination.Vars(); !reflect.DeepEqual(e, a) {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := map[string]string{"aws_account_id": "111111111111", "aws_region": "us-east-1"}, combination.Tags(); !reflect.DeepEqual(e, a) {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	if e, a := "", AccountIdFromArn("not-an-arn"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+// Fan out tags are carried as endpoint key values, which the post process
+//    adds to every record.
+func TestFanOutTagsAddedToRecords(t *testing.T) {
+	request := generic_structs.ApiRequest{
+		Settings:          generic_structs.ApiRequestInheritableSettings{Name: "instances"},
+		EndpointKeyValues: map[string]interface{}{"aws_account_id": "111111111111", "aws_region": "eu-west-1"},
+	}.ToComparableApiRequest()
+	request.Uuid = "uuid-1"
+	jsonKeys := []map[string]string{{
+		"api_call_uuid":      "uuid-1",
+		"key_count":          "1",
+		"current_base_key_0": "items",
+		"desired_base_key_0": "instances",
+	}}
+
+	result := DefaultJsonPostProcess(map[generic_structs.ComparableApiRequest][]byte{
+		request: []byte(`{"items": [{"id": "i-1"}, {"id": "i-2"}]}`),
+	}, jsonKeys)
+	expected := `{"instances":[{"aws_account_id":"111111111111","aws_region":"eu-west-1","id":"i-1"},{"aws_account_id":"111111111111","aws_region":"eu-west-1","id":"i-2"}]}`
+	if e, a := expected, string(result); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
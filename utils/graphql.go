@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// The variable endCursor is passed back in when a GraphQL endpoint doesn't
+//    set cursor_variable - the Relay convention for forward paging.
+const DefaultGraphqlCursorVariable = "after"
+
+// Creates the POST request for a GraphQL endpoint.
+// Vars:
+// endpoint  = URL of the GraphQL API.
+// graphql   = The endpoint's GraphQL settings.
+// variables = Variables sent with the query.
+func NewGraphqlRequest(endpoint string, graphql generic_structs.GraphqlSettings, variables map[string]interface{}) (*http.Request, error) {
+	request, err := http.NewRequest("POST", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	return request, SetGraphqlBody(request, graphql, variables)
+}
+
+// Replaces the body of a GraphQL request with the query and variables given,
+//    so the same request can be re-sent for the next page.
+// Vars:
+// request   = The request to update.
+// graphql   = The endpoint's GraphQL settings.
+// variables = Variables sent with the query.
+func SetGraphqlBody(request *http.Request, graphql generic_structs.GraphqlSettings, variables map[string]interface{}) error {
+	document := map[string]interface{}{"query": graphql.Query}
+	if graphql.OperationName != "" {
+		document["operationName"] = graphql.OperationName
+	}
+	if len(variables) > 0 {
+		document["variables"] = variables
+	}
+	body, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	request.ContentLength = int64(len(body))
+
+	return nil
+}
+
+// Returns the variables to send with a GraphQL query - the endpoint's
+//    variables plus the first value of each body param, which take
+//    precedence.  YAML maps are converted so they can be marshaled to JSON.
+// Vars:
+// variables  = Variables from the endpoint's graphql settings.
+// bodyParams = The endpoint's body params.
+func GraphqlVariables(variables map[string]interface{}, bodyParams map[string][]string) map[string]interface{} {
+	finalVariables := make(map[string]interface{})
+	for k, v := range variables {
+		finalVariables[k] = yamlToJsonValue(v)
+	}
+	for k, v := range bodyParams {
+		if len(v) > 0 {
+			finalVariables[k] = v[0]
+		}
+	}
+
+	return finalVariables
+}
+
+// Passes every string within the GraphQL variables through mapping,
+//    returning a new map so the endpoint's own variables are left untouched.
+// Vars:
+// variables = Variables from the endpoint's graphql settings.
+// mapping   = Function returning the new value for each string.
+func MapGraphqlVariables(variables map[string]interface{}, mapping func(string) string) map[string]interface{} {
+	if variables == nil {
+		return nil
+	}
+
+	return mapStrings(variables, mapping).(map[string]interface{})
+}
+
+// Finds the Relay pageInfo in a GraphQL response and reports whether there is
+//    another page and the cursor to fetch it with.
+// Vars:
+// response = The GraphQL response.
+// path     = Dot separated path to the pageInfo object, e.g.
+//            data.organization.repositories.pageInfo.  If blank, the first
+//            pageInfo found (in key order) is used.
+func GraphqlPageInfo(response []byte, path string) (bool, string) {
+	var unparsedStructure interface{}
+	if err := json.Unmarshal(response, &unparsedStructure); err != nil {
+		LogError("GraphqlPageInfo", "Error unmarshaling JSON", err)
+		return false, ""
+	}
+
+	var pageInfo map[string]interface{}
+	if path != "" {
+		pageInfo, _ = jsonValueAtPath(unparsedStructure, strings.Split(path, ".")).(map[string]interface{})
+	} else {
+		pageInfo = findPageInfo(unparsedStructure)
+	}
+	if pageInfo == nil {
+		return false, ""
+	}
+
+	hasNextPage, _ := pageInfo["hasNextPage"].(bool)
+	endCursor, _ := pageInfo["endCursor"].(string)
+	return hasNextPage && endCursor != "", endCursor
+}
+
+// Returns the messages of any errors in a GraphQL response.  GraphQL APIs
+//    usually report these with a 200 status, so they need checking for
+//    separately.
+// Vars:
+// response = The GraphQL response.
+func GraphqlErrors(response []byte) []string {
+	var unparsedStructure struct {
+		Errors []struct {
+			Message string        `json:"message"`
+			Path    []interface{} `json:"path"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(response, &unparsedStructure); err != nil {
+		return []string{}
+	}
+
+	messages := []string{}
+	for _, e := range unparsedStructure.Errors {
+		if len(e.Path) > 0 {
+			path := make([]string, len(e.Path))
+			for i, p := range e.Path {
+				path[i] = fmt.Sprint(p)
+			}
+			messages = append(messages, e.Message+" ("+strings.Join(path, ".")+")")
+		} else {
+			messages = append(messages, e.Message)
+		}
+	}
+
+	return messages
+}
+
+// Walks a decoded JSON structure down the key set given, returning nil if any
+//    of the keys are missing.
+func jsonValueAtPath(structure interface{}, kSet []string) interface{} {
+	for _, k := range kSet {
+		structureMap, ok := structure.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		structure = structureMap[k]
+	}
+
+	return structure
+}
+
+// Searches a decoded JSON structure depth first for a pageInfo object,
+//    visiting keys in sorted order so the result is stable.
+func findPageInfo(structure interface{}) map[string]interface{} {
+	switch typedStructure := structure.(type) {
+	case map[string]interface{}:
+		if pageInfo, ok := typedStructure["pageInfo"].(map[string]interface{}); ok {
+			return pageInfo
+		}
+		keys := make([]string, 0, len(typedStructure))
+		for k := range typedStructure {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if pageInfo := findPageInfo(typedStructure[k]); pageInfo != nil {
+				return pageInfo
+			}
+		}
+	case []interface{}:
+		for _, v := range typedStructure {
+			if pageInfo := findPageInfo(v); pageInfo != nil {
+				return pageInfo
+			}
+		}
+	}
+
+	return nil
+}
+
+// Converts the map[interface{}]interface{} values yaml.v2 decodes nested maps
+//    to into map[string]interface{} so they can be marshaled to JSON.
+func yamlToJsonValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[interface{}]interface{}:
+		jsonMap := make(map[string]interface{})
+		for k, v := range typedValue {
+			jsonMap[fmt.Sprint(k)] = yamlToJsonValue(v)
+		}
+		return jsonMap
+	case map[string]interface{}:
+		jsonMap := make(map[string]interface{})
+		for k, v := range typedValue {
+			jsonMap[k] = yamlToJsonValue(v)
+		}
+		return jsonMap
+	case []interface{}:
+		jsonList := make([]interface{}, len(typedValue))
+		for i, v := range typedValue {
+			jsonList[i] = yamlToJsonValue(v)
+		}
+		return jsonList
+	}
+
+	return value
+}
+
+// Copies a decoded YAML/JSON value, passing its strings through mapping.
+func mapStrings(value interface{}, mapping func(string) string) interface{} {
+	switch typedValue := yamlToJsonValue(value).(type) {
+	case string:
+		return mapping(typedValue)
+	case map[string]interface{}:
+		for k, v := range typedValue {
+			typedValue[k] = mapStrings(v, mapping)
+		}
+		return typedValue
+	case []interface{}:
+		for i, v := range typedValue {
+			typedValue[i] = mapStrings(v, mapping)
+		}
+		return typedValue
+	default:
+		return typedValue
+	}
+}
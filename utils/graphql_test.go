@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	generic_structs "github.com/SREnity/epico/structs"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewGraphqlRequest(t *testing.T) {
+	var endpoint generic_structs.ApiEndpoint
+	err := yaml.Unmarshal([]byte(`
+name: repositories
+endpoint: https://api.github.com/graphql
+graphql:
+  query: "query($org: String!, $first: Int, $after: String) { organization(login: $org) { id } }"
+  variables:
+    org: "{{org}}"
+    first: 50
+    filter: { labels: [ "{{org}}-bug" ] }
+`), &endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables := MapGraphqlVariables(endpoint.Graphql.Variables, func(value string) string {
+		return strings.Replace(value, "{{org}}", "SREnity", -1)
+	})
+	if e, a := "{{org}}", endpoint.Graphql.Variables["org"]; e != a {
+		t.Errorf("expect the endpoint's variables to be left alone, got %v", a)
+	}
+
+	request, err := NewGraphqlRequest(endpoint.Endpoint, endpoint.Graphql, GraphqlVariables(variables, map[string][]string{"after": {"Y3Vyc29y"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Method != "POST" || request.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expect a JSON POST, got %v %v", request.Method, request.Header)
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var document map[string]interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		t.Fatalf("expect a JSON body, got %s: %v", body, err)
+	}
+	expected := map[string]interface{}{
+		"org":    "SREnity",
+		"first":  float64(50),
+		"after":  "Y3Vyc29y",
+		"filter": map[string]interface{}{"labels": []interface{}{"SREnity-bug"}},
+	}
+	if !reflect.DeepEqual(expected, document["variables"]) {
+		t.Errorf("expect variables\n%v\ngot\n%v", expected, document["variables"])
+	}
+	if e, a := endpoint.Graphql.Query, document["query"]; e != a {
+		t.Errorf("expect query %v, got %v", e, a)
+	}
+
+	// The body can be replaced for the next page and re-read for retries.
+	if err := SetGraphqlBody(request, endpoint.Graphql, map[string]interface{}{"after": "next"}); err != nil {
+		t.Fatal(err)
+	}
+	rewound, _ := request.GetBody()
+	body, _ = ioutil.ReadAll(rewound)
+	if e, a := `{"query":"`+endpoint.Graphql.Query+`","variables":{"after":"next"}}`, string(body); e != a || request.ContentLength != int64(len(body)) {
+		t.Errorf("expect\n%v\ngot\n%v", e, a)
+	}
+}
+
+func TestGraphqlPageInfo(t *testing.T) {
+	response := []byte(`{"data": {"organization": {
+		"members": {"pageInfo": {"hasNextPage": false, "endCursor": "m1"}},
+		"repositories": {"nodes": [{"id": "R_1"}], "pageInfo": {"hasNextPage": true, "endCursor": "r1"}}
+	}}}`)
+
+	// The first pageInfo in key order is used unless a path is given.
+	if hasNextPage, endCursor := GraphqlPageInfo(response, ""); hasNextPage || endCursor != "m1" {
+		t.Errorf("expect the members pageInfo, got %v %v", hasNextPage, endCursor)
+	}
+	if hasNextPage, endCursor := GraphqlPageInfo(response, "data.organization.repositories.pageInfo"); !hasNextPage || endCursor != "r1" {
+		t.Errorf("expect the repositories pageInfo, got %v %v", hasNextPage, endCursor)
+	}
+
+	for _, response := range []string{
+		`{"data": {"organization": {"id": "O_1"}}}`,
+		`{"data": null, "errors": [{"message": "Bad credentials"}]}`,
+		`{"data": {"pageInfo": {"hasNextPage": true, "endCursor": null}}}`,
+		`not json`,
+	} {
+		if hasNextPage, _ := GraphqlPageInfo([]byte(response), ""); hasNextPage {
+			t.Errorf("expect no next page for %v", response)
+		}
+	}
+}
+
+func TestGraphqlErrors(t *testing.T) {
+	response := []byte(`{"data": {"repository": null}, "errors": [
+		{"message": "Could not resolve to a Repository", "path": ["repository", 0]},
+		{"message": "Rate limited"}
+	]}`)
+	expected := []string{"Could not resolve to a Repository (repository.0)", "Rate limited"}
+	if a := GraphqlErrors(response); !reflect.DeepEqual(expected, a) {
+		t.Errorf("expect %v, got %v", expected, a)
+	}
+	if a := GraphqlErrors([]byte(`{"data": {}}`)); len(a) != 0 {
+		t.Errorf("expect no errors, got %v", a)
+	}
+
+	// With the default error keys, the errors end up in the output.
+	request := generic_structs.ComparableApiRequest{Uuid: "1"}
+	jsonKeys := []map[string]string{{
+		"api_call_uuid":       "1",
+		"key_count":           "1",
+		"current_base_key_0":  "data.repository",
+		"desired_base_key_0":  "repositories",
+		"current_error_key_0": "errors",
+		"desired_error_key_0": "errors",
+	}}
+	output := DefaultJsonPostProcess(map[generic_structs.ComparableApiRequest][]byte{request: response}, jsonKeys)
+	var parsedOutput map[string][]interface{}
+	if err := json.Unmarshal(output, &parsedOutput); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := 2, len(parsedOutput["errors"]); e != a {
+		t.Errorf("expect %v errors in the output, got %s", e, output)
+	}
+}
@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// Defaults used for any http_client settings left blank.
+const (
+	defaultClientTimeout       = 60 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+	defaultTlsHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+)
+
+var defaultHttpClient struct {
+	sync.Once
+	client *http.Client
+}
+
+// DefaultHttpClient returns the shared client used when a request or auth
+//    helper has not been handed one - the default http_client settings rather
+//    than Go's timeout-less http.DefaultClient.
+func DefaultHttpClient() *http.Client {
+	defaultHttpClient.Do(func() {
+		client, err := NewHttpClient(generic_structs.HttpClientSettings{}, generic_structs.TlsSettings{})
+		if err != nil {
+			// Can't happen without any files or URLs to load, but don't
+			//    leave ourselves without a client.
+			LogError("DefaultHttpClient", "Unable to build default client", err)
+			client = &http.Client{Timeout: defaultClientTimeout}
+		}
+		defaultHttpClient.client = client
+	})
+
+	return defaultHttpClient.client
+}
+
+// Returns the client attached to the request, falling back to the shared
+//    default client.  Auth helpers use this so token fetches go through the
+//    same transport (proxy, TLS, pooling) as the API calls themselves.
+func httpClientFor(apiRequest generic_structs.ApiRequest) *http.Client {
+	if apiRequest.Client != nil {
+		return apiRequest.Client
+	}
+
+	return DefaultHttpClient()
+}
+
+// NewHttpClient builds an http.Client with a pooled transport from the
+//    http_client and tls YAML settings.  Build one per run (or per API root)
+//    and share it between requests so connections are reused.
+// Vars:
+// settings    = The http_client settings from the API root.
+// tlsSettings = The tls settings from the API root.
+func NewHttpClient(settings generic_structs.HttpClientSettings, tlsSettings generic_structs.TlsSettings) (*http.Client, error) {
+	timeout, err := parseDurationSetting(settings.Timeout, defaultClientTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid timeout: %s", err.Error())
+	}
+	dialTimeout, err := parseDurationSetting(settings.DialTimeout, defaultDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid dial_timeout: %s", err.Error())
+	}
+	tlsHandshakeTimeout, err := parseDurationSetting(settings.TlsHandshakeTimeout, defaultTlsHandshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid tls_handshake_timeout: %s", err.Error())
+	}
+	idleConnTimeout, err := parseDurationSetting(settings.IdleConnTimeout, defaultIdleConnTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid idle_conn_timeout: %s", err.Error())
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     !settings.DisableHttp2,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		IdleConnTimeout:       idleConnTimeout,
+		MaxIdleConns:          defaultMaxIdleConns,
+		MaxIdleConnsPerHost:   defaultMaxIdleConnsPerHost,
+		MaxConnsPerHost:       settings.MaxConnsPerHost,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if settings.MaxIdleConns > 0 {
+		transport.MaxIdleConns = settings.MaxIdleConns
+	}
+	if settings.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = settings.MaxIdleConnsPerHost
+	}
+	if settings.DisableHttp2 {
+		// A non-nil, empty map is how net/http is told not to upgrade.
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if settings.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(settings.ProxyUrl)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy_url: %s", err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	tlsConfig, err := NewTlsConfig(tlsSettings)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// NewTlsConfig builds the TLS config for an API root's tls block - custom CA
+//    bundles, client certificates, server name and minimum version.
+// Vars:
+// settings = The tls settings from the API root.
+func NewTlsConfig(settings generic_structs.TlsSettings) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: settings.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if settings.MinVersion != "" {
+		version, ok := tlsVersions[settings.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("Invalid min_version %q - expected 1.0, 1.1, 1.2 or 1.3", settings.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if settings.CaFile != "" {
+		caBundle, err := ioutil.ReadFile(settings.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read ca_file: %s", err.Error())
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("No certificates found in ca_file %s", settings.CaFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if settings.CertFile != "" || settings.KeyFile != "" {
+		if settings.CertFile == "" || settings.KeyFile == "" {
+			return nil, fmt.Errorf("cert_file and key_file must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if settings.InsecureSkipVerify {
+		LogWarning("NewTlsConfig", "!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+		LogWarning("NewTlsConfig", "insecure_skip_verify is set - TLS certificates will NOT be verified.")
+		LogWarning("NewTlsConfig", "Traffic (including credentials) can be intercepted. Never use this in production.")
+		LogWarning("NewTlsConfig", "!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseDurationSetting(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return time.ParseDuration(value)
+}
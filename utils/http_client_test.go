@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// Writes the httptest server's certificate out as a CA bundle.
+func writeTestCaFile(t *testing.T, server *httptest.Server) string {
+	file, err := ioutil.TempFile("", "epico-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return file.Name()
+}
+
+func TestNewHttpClientCustomCa(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client, err := NewHttpClient(generic_structs.HttpClientSettings{}, generic_structs.TlsSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("expect unknown authority error without ca_file")
+	}
+
+	caFile := writeTestCaFile(t, server)
+	defer os.Remove(caFile)
+	client, err = NewHttpClient(generic_structs.HttpClientSettings{Timeout: "5s"}, generic_structs.TlsSettings{CaFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewHttpClientInvalidSettings(t *testing.T) {
+	if _, err := NewHttpClient(generic_structs.HttpClientSettings{Timeout: "soon"}, generic_structs.TlsSettings{}); err == nil {
+		t.Errorf("expect error for invalid timeout")
+	}
+	if _, err := NewTlsConfig(generic_structs.TlsSettings{CertFile: "client.pem"}); err == nil {
+		t.Errorf("expect error for cert_file without key_file")
+	}
+	if _, err := NewTlsConfig(generic_structs.TlsSettings{CaFile: "/does/not/exist"}); err == nil {
+		t.Errorf("expect error for missing ca_file")
+	}
+	if _, err := NewTlsConfig(generic_structs.TlsSettings{MinVersion: "2.0"}); err == nil {
+		t.Errorf("expect error for invalid min_version")
+	}
+}
+
+func TestOauth2TwoLegAuthReusesToken(t *testing.T) {
+	var tokenFetches int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenFetches, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "tok", "token_type": "bearer", "expires_in": 3600}`))
+	}))
+	defer tokenServer.Close()
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer apiServer.Close()
+
+	client, err := NewHttpClient(generic_structs.HttpClientSettings{}, generic_structs.TlsSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authParams := []string{"id", "secret", "read", tokenServer.URL, "audience|api"}
+	for i := 0; i < 3; i++ {
+		apiRequest := newTestApiRequest(t, apiServer.URL)
+		apiRequest.Client = client
+		apiRequest = Oauth2TwoLegAuth(apiRequest, authParams)
+		resp, err := apiRequest.Client.Do(apiRequest.FullRequest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if e, a := http.StatusOK, resp.StatusCode; e != a {
+			t.Errorf("expect %v, got %v", e, a)
+		}
+	}
+	if e, a := int32(1), atomic.LoadInt32(&tokenFetches); e != a {
+		t.Errorf("expect %v token fetches, got %v", e, a)
+	}
+}
+
+// Generates a self-signed client certificate and writes the cert/key PEMs.
+func writeTestClientCert(t *testing.T) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "epico-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, err := ioutil.TempFile("", "epico-client-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certFile.Close()
+	keyFile, err := ioutil.TempFile("", "epico-client-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem.Encode(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	keyFile.Close()
+
+	return certFile.Name(), keyFile.Name(), certificate
+}
+
+func TestNewHttpClientMutualTls(t *testing.T) {
+	certFile, keyFile, certificate := writeTestClientCert(t)
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certificate)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := writeTestCaFile(t, server)
+	defer os.Remove(caFile)
+
+	client, err := NewHttpClient(generic_structs.HttpClientSettings{}, generic_structs.TlsSettings{CaFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("expect handshake failure without a client certificate")
+	}
+
+	client, err = NewHttpClient(generic_structs.HttpClientSettings{}, generic_structs.TlsSettings{
+		CaFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "example.com",
+		MinVersion: "1.2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if e, a := "epico-client", string(body); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	client, err = NewHttpClient(generic_structs.HttpClientSettings{}, generic_structs.TlsSettings{
+		CaFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "wrong.example.net",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("expect server name mismatch")
+	}
+}
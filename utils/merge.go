@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Strategies for merging an endpoint's records into a key that already holds
+//    something they can't be appended to, e.g. records at "a" when another
+//    endpoint's are at "a.b".
+const (
+	// Keep both - the values are combined into one list.
+	MergeAppend = "append"
+	// Put the records under the endpoint's name instead, e.g. at "name.a".
+	MergeNamespace = "namespace"
+	// Drop the records and report the conflict.
+	MergeError = "error"
+)
+
+var mergeStrategies = map[string]bool{"": true, MergeAppend: true, MergeNamespace: true, MergeError: true}
+
+// Reports a collision merging an endpoint's records - see MergeJsonRecords.
+type MergeConflict struct {
+	ApiCallName string `json:"api_call_name"`
+	Key         string `json:"key"`
+	Reason      string `json:"reason"`
+}
+
+// Checks a merge strategy is one of MergeAppend (the default if blank),
+//    MergeNamespace or MergeError.
+// Vars:
+// strategy = The merge strategy.
+func ValidateMergeStrategy(strategy string) error {
+	if !mergeStrategies[strategy] {
+		return fmt.Errorf("invalid merge strategy %q - must be %s, %s or %s", strategy, MergeAppend, MergeNamespace, MergeError)
+	}
+
+	return nil
+}
+
+// Merges a list of records into a decoded JSON structure at a key set,
+//    creating the objects along the way (e.g. adding [ 4 ] to {"X": { "Y":
+//    { "Z": [ 1, 2, 3 ] } } } with key set "X.Y.Z" gives {"X": { "Y": { "Z":
+//    [ 1, 2, 3, 4 ] } } }).  The structure is updated in place.  If a value
+//    in the way isn't an object, or the value at the key isn't a list, the
+//    strategy given decides what happens, and the conflict is returned
+//    unless the records could still be added (see MergeAppend).
+// Vars:
+// structure   = Structure being added to.
+// kSet        = Key set the records are added at.
+// records     = Records being added.
+// force       = Adds an empty list if there are no records and nothing is
+//               at the key yet.
+// strategy    = How collisions are handled.
+// apiCallName = Name of the endpoint the records are from, for namespacing
+//               and reporting.
+func MergeJsonRecords(structure map[string]interface{}, kSet []string, records []interface{}, force bool, strategy string, apiCallName string) *MergeConflict {
+	if !force && len(records) == 0 {
+		return nil
+	}
+	if records == nil {
+		records = []interface{}{}
+	}
+
+	depth, reason := jsonMergeConflict(structure, kSet)
+	if depth < 0 {
+		setJsonRecords(structure, kSet, records)
+		return nil
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	conflict := &MergeConflict{ApiCallName: apiCallName, Key: strings.Join(kSet[:depth+1], "."), Reason: reason}
+	switch strategy {
+	case MergeNamespace:
+		namespacedKSet := append([]string{apiCallName}, kSet...)
+		if apiCallName != "" {
+			if depth, _ := jsonMergeConflict(structure, namespacedKSet); depth < 0 {
+				setJsonRecords(structure, namespacedKSet, records)
+				return nil
+			}
+		}
+		conflict.Reason += " - unable to namespace as " + apiCallName
+	case MergeError:
+	default:
+		// Combined with what's there, e.g. records at "a" with an object
+		//    holding "b" gives [ { "b": ... }, records... ].
+		var value interface{} = records
+		for i := len(kSet) - 1; i > depth; i-- {
+			value = map[string]interface{}{kSet[i]: value}
+		}
+		parent := structure
+		for _, k := range kSet[:depth] {
+			parent = parent[k].(map[string]interface{})
+		}
+		parent[kSet[depth]] = MergeJsonValues(parent[kSet[depth]], value)
+		return nil
+	}
+
+	LogWarning("MergeJsonRecords", "["+apiCallName+"]", "Dropping records: "+conflict.Key+" "+conflict.Reason)
+	return conflict
+}
+
+// Merges two decoded JSON values into one - objects key by key, and lists by
+//    appending.  Anything else is combined into a list of both, so nothing
+//    is lost.
+// Vars:
+// existing = Value already present.
+// incoming = Value being merged in.
+func MergeJsonValues(existing interface{}, incoming interface{}) interface{} {
+	existingMap, existingIsMap := existing.(map[string]interface{})
+	incomingMap, incomingIsMap := incoming.(map[string]interface{})
+	if existingIsMap && incomingIsMap {
+		for k, v := range incomingMap {
+			if current, ok := existingMap[k]; ok {
+				existingMap[k] = MergeJsonValues(current, v)
+			} else {
+				existingMap[k] = v
+			}
+		}
+		return existingMap
+	}
+
+	existingList, ok := existing.([]interface{})
+	if !ok {
+		existingList = []interface{}{existing}
+	}
+	if incomingList, ok := incoming.([]interface{}); ok {
+		return append(existingList, incomingList...)
+	}
+
+	return append(existingList, incoming)
+}
+
+// Returns the depth in the key set at which records can't be merged, and why,
+//    or -1 if they can.
+func jsonMergeConflict(structure map[string]interface{}, kSet []string) (int, string) {
+	for i, k := range kSet {
+		value, ok := structure[k]
+		if !ok {
+			return -1, ""
+		}
+		if i == len(kSet)-1 {
+			if _, ok := value.([]interface{}); !ok {
+				return i, fmt.Sprintf("holds %s, not a list", jsonTypeName(value))
+			}
+			return -1, ""
+		}
+		if structure, ok = value.(map[string]interface{}); !ok {
+			return i, fmt.Sprintf("holds %s, not an object", jsonTypeName(value))
+		}
+	}
+
+	return -1, ""
+}
+
+// Adds records at a key set with no conflicts (see jsonMergeConflict).
+func setJsonRecords(structure map[string]interface{}, kSet []string, records []interface{}) {
+	for _, k := range kSet[:len(kSet)-1] {
+		next, ok := structure[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			structure[k] = next
+		}
+		structure = next
+	}
+
+	k := kSet[len(kSet)-1]
+	if existing, ok := structure[k].([]interface{}); ok {
+		structure[k] = append(existing, records...)
+	} else {
+		structure[k] = append(make([]interface{}, 0, len(records)), records...)
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case nil:
+		return "null"
+	}
+
+	return fmt.Sprintf("a %T", value)
+}
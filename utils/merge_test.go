@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+func decodeJson(t testing.TB, document string) map[string]interface{} {
+	var structure map[string]interface{}
+	if err := json.Unmarshal([]byte(document), &structure); err != nil {
+		t.Fatal(err)
+	}
+	return structure
+}
+
+func TestMergeJsonRecords(t *testing.T) {
+	records := []interface{}{"r"}
+	cases := []struct {
+		existing string
+		key      string
+		strategy string
+		expected string
+		conflict string
+	}{
+		{`{}`, "a.b", "", `{"a": {"b": ["r"]}}`, ""},
+		{`{"a": {"b": [1]}}`, "a.b", MergeError, `{"a": {"b": [1, "r"]}}`, ""},
+		// Records at a key holding an object, and under a key holding a list.
+		{`{"a": {"b": [1]}}`, "a", "", `{"a": [{"b": [1]}, "r"]}`, ""},
+		{`{"a": [1]}`, "a.b", MergeAppend, `{"a": [1, {"b": ["r"]}]}`, ""},
+		{`{"a": "x"}`, "a.b.c", "", `{"a": ["x", {"b": {"c": ["r"]}}]}`, ""},
+		{`{"a": [1]}`, "a.b", MergeNamespace, `{"a": [1], "ep": {"a": {"b": ["r"]}}}`, ""},
+		{`{"a": [1], "ep": 2}`, "a.b", MergeNamespace, `{"a": [1], "ep": 2}`, "a"},
+		{`{"a": {"b": [1]}}`, "a", MergeError, `{"a": {"b": [1]}}`, "a"},
+		{`{"a": {"b": {"c": 1}}}`, "a.b", MergeError, `{"a": {"b": {"c": 1}}}`, "a.b"},
+	}
+	for _, c := range cases {
+		structure := decodeJson(t, c.existing)
+		conflict := MergeJsonRecords(structure, strings.Split(c.key, "."), records, true, c.strategy, "ep")
+		if expected := decodeJson(t, c.expected); !reflect.DeepEqual(expected, structure) {
+			t.Errorf("expect %v adding at %v to %v (%v), got %v", c.expected, c.key, c.existing, c.strategy, structure)
+		}
+		if (conflict == nil) != (c.conflict == "") || (conflict != nil && (conflict.Key != c.conflict || conflict.ApiCallName != "ep")) {
+			t.Errorf("expect conflict at %q adding at %v to %v (%v), got %+v", c.conflict, c.key, c.existing, c.strategy, conflict)
+		}
+	}
+
+	// Empty lists are only added if forced, and never collide.
+	structure := decodeJson(t, `{"a": "x"}`)
+	MergeJsonRecords(structure, []string{"b"}, nil, false, "", "ep")
+	MergeJsonRecords(structure, []string{"c"}, nil, true, "", "ep")
+	if conflict := MergeJsonRecords(structure, []string{"a"}, nil, true, MergeError, "ep"); conflict != nil {
+		t.Errorf("expect no conflict for no records, got %+v", conflict)
+	}
+	if expected := decodeJson(t, `{"a": "x", "c": []}`); !reflect.DeepEqual(expected, structure) {
+		t.Errorf("expect %v, got %v", expected, structure)
+	}
+}
+
+func TestCollapseJsonCollisions(t *testing.T) {
+	returns := decodeJson(t, `{"items": [1], "meta": {"count": 1}, "errors": {"a": ["x"]}}`)
+	errors := decodeJson(t, `{"items": [2], "meta": {"warnings": ["w"]}, "errors": {"a": ["y"], "b": ["z"]}}`)
+
+	var output map[string]interface{}
+	json.Unmarshal(CollapseJson(returns, errors), &output)
+	expected := decodeJson(t, `{"items": [1, 2], "meta": {"count": 1, "warnings": ["w"]}, "errors": {"a": ["x", "y"], "b": ["z"]}}`)
+	if !reflect.DeepEqual(expected, output) {
+		t.Errorf("expect %v, got %v", expected, output)
+	}
+}
+
+func TestDefaultJsonPostProcessMergeConflicts(t *testing.T) {
+	// Two endpoints writing to "data" and "data.detail" used to panic.
+	first := generic_structs.ComparableApiRequest{Uuid: "1"}
+	second := generic_structs.ComparableApiRequest{Uuid: "2", Endpoint: "second"}
+	keys := func(uuid string, name string, key string, strategy string) map[string]string {
+		return map[string]string{
+			"api_call_uuid": uuid, "api_call_name": name, "key_count": "1", "merge_strategy": strategy,
+			"current_base_key_0": "items", "desired_base_key_0": key,
+		}
+	}
+
+	for strategy, expected := range map[string]string{
+		MergeAppend:    `{"data": [{"detail": [2]}, 1]}`,
+		MergeNamespace: `{"data": {"detail": [2]}, "list": {"data": [1]}}`,
+		MergeError:     `{"data": {"detail": [2]}, "merge_conflicts": [{"api_call_name": "list", "key": "data", "reason": "holds an object, not a list"}]}`,
+	} {
+		// The first response is merged first, so map order doesn't matter.
+		jsonKeys := []map[string]string{keys("1", "detail", "data.detail", strategy), keys("2", "list", "data", strategy)}
+		parsedStructure, parsedErrorStructure := ParsePostProcessedJson(first, jsonKeys, []byte(`{"items": [2]}`), map[string]interface{}{}, map[string]interface{}{})
+		parsedStructure, parsedErrorStructure = ParsePostProcessedJson(second, jsonKeys, []byte(`{"items": [1]}`), parsedStructure, parsedErrorStructure)
+
+		var output map[string]interface{}
+		json.Unmarshal(CollapseJson(parsedStructure, parsedErrorStructure), &output)
+		if e := decodeJson(t, expected); !reflect.DeepEqual(e, output) {
+			t.Errorf("expect %v for %v, got %v", expected, strategy, output)
+		}
+	}
+}
+
+// The merging replaced by MergeJsonRecords, kept to benchmark against.
+func legacyAddJsonKeyStructure(kSet []string, count int, currentStructure map[string]interface{}, newStructure []interface{}, force bool) interface{} {
+	if !force && len(newStructure) == 0 {
+		return legacyMarshalToInterface(currentStructure)
+	}
+
+	if count == len(kSet)-1 {
+		if _, ok := currentStructure[kSet[count]]; !ok {
+			currentStructure[kSet[count]] = legacyMarshalToInterface(newStructure)
+		} else if len(newStructure) > 0 {
+			currentStructure[kSet[count]] = legacyMarshalToInterface(append(
+				currentStructure[kSet[count]].([]interface{}), newStructure...))
+		}
+		return legacyMarshalToInterface(currentStructure)
+	}
+	if _, ok := currentStructure[kSet[count]]; !ok {
+		currentStructure[kSet[count]] = legacyMarshalToInterface(
+			legacyAddJsonKeyStructure(kSet, count+1, make(map[string]interface{}), newStructure, force))
+	} else if len(newStructure) > 0 {
+		currentStructure[kSet[count]] = legacyMarshalToInterface(
+			legacyAddJsonKeyStructure(kSet, count+1, currentStructure[kSet[count]].(map[string]interface{}), newStructure, force))
+	}
+	return legacyMarshalToInterface(currentStructure)
+}
+
+func legacyMarshalToInterface(data interface{}) interface{} {
+	jsonIntermediary, _ := json.Marshal(data)
+	var typeParsedStructure interface{}
+	json.Unmarshal(jsonIntermediary, &typeParsedStructure)
+	return typeParsedStructure
+}
+
+// Pages of records, as merged for a large paged endpoint.
+func benchmarkPages(b *testing.B) [][]interface{} {
+	pages := make([][]interface{}, 20)
+	for i := range pages {
+		var page []interface{}
+		document := make([]string, 100)
+		for j := range document {
+			document[j] = fmt.Sprintf(`{"id": %d, "name": "record %d", "tags": {"env": "prod", "team": "ops"}, "sizes": [1, 2, 3]}`, i*100+j, j)
+		}
+		if err := json.Unmarshal([]byte("["+strings.Join(document, ",")+"]"), &page); err != nil {
+			b.Fatal(err)
+		}
+		pages[i] = page
+	}
+	return pages
+}
+
+func BenchmarkMergeJsonRecords(b *testing.B) {
+	pages := benchmarkPages(b)
+	kSet := []string{"results", "records"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		structure := make(map[string]interface{})
+		for _, page := range pages {
+			MergeJsonRecords(structure, kSet, page, true, "", "records")
+		}
+	}
+}
+
+func BenchmarkLegacyAddJsonKeyStructure(b *testing.B) {
+	pages := benchmarkPages(b)
+	kSet := []string{"results", "records"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		structure := make(map[string]interface{})
+		for _, page := range pages {
+			structure = legacyAddJsonKeyStructure(kSet, 0, structure, page, true).(map[string]interface{})
+		}
+	}
+}
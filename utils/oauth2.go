@@ -0,0 +1,481 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/jws"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// DeviceCodeDefaultInterval is how often the token endpoint is polled during
+//    the device code flow when the authorization server doesn't say.
+var DeviceCodeDefaultInterval = 5 * time.Second
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+const jwtBearerAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// Builds the common oauth2.Config from the leading auth params shared by the
+//    OAuth2 grant helpers:
+//              [0] => client ID
+//              [1] => client secret
+//              [2] => scopes (csv)
+//              [3] => token url
+func oauth2Config(authParams []string) *oauth2.Config {
+	var scopes []string
+	if authParams[2] != "" {
+		scopes = strings.Split(authParams[2], ",")
+	}
+
+	return &oauth2.Config{
+		ClientID:     authParams[0],
+		ClientSecret: authParams[1],
+		Scopes:       scopes,
+		Endpoint:     oauth2.Endpoint{TokenURL: authParams[3]},
+	}
+}
+
+// Key refresh tokens are persisted under in the state store.
+func refreshTokenStateKey(authParams []string) string {
+	return "oauth2_refresh_token:" + tokenCacheKey(authParams[0], authParams[3])
+}
+
+// Key a hash of the configured refresh token is persisted under, so a new one
+//    in the params can be told apart from the one the persisted token was
+//    rotated from.
+func configuredRefreshTokenStateKey(authParams []string) string {
+	return "oauth2_configured_refresh_token:" + tokenCacheKey(authParams[0], authParams[3])
+}
+
+// persistingTokenSource saves the refresh token to the state store whenever
+//    the authorization server rotates it, so the next run starts from a
+//    refresh token that is still valid.
+type persistingTokenSource struct {
+	source   oauth2.TokenSource
+	stateKey string
+
+	mutex        sync.Mutex
+	refreshToken string
+}
+
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := p.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if token.RefreshToken != "" && token.RefreshToken != p.refreshToken {
+		if err := GetStateStore().SetState(p.stateKey, token.RefreshToken); err != nil {
+			LogError("persistingTokenSource", "Unable to persist rotated refresh token", err)
+		}
+		p.refreshToken = token.RefreshToken
+	}
+
+	return token, nil
+}
+
+// Returns a token source that refreshes from the given token, persisting any
+//    rotated refresh tokens.
+func refreshingTokenSource(ctx context.Context, cfg *oauth2.Config, token *oauth2.Token, stateKey string) oauth2.TokenSource {
+	persisting := &persistingTokenSource{
+		source:       cfg.TokenSource(ctx, token),
+		stateKey:     stateKey,
+		refreshToken: token.RefreshToken,
+	}
+	if token.RefreshToken != "" {
+		if err := GetStateStore().SetState(stateKey, token.RefreshToken); err != nil {
+			LogError("refreshingTokenSource", "Unable to persist refresh token", err)
+		}
+	}
+
+	return oauth2.ReuseTokenSource(nil, persisting)
+}
+
+// Sets up the ApiRequest client from a token source builder, logging any
+//    failure the way the other auth helpers do.
+func applyOauth2Client(apiRequest generic_structs.ApiRequest, authName string, authParams []string, newSource func(context.Context) (oauth2.TokenSource, error)) generic_structs.ApiRequest {
+	client, cacheKey, err := oauth2Client(apiRequest, authName, authParams, newSource)
+	if err != nil {
+		LogError(authName, "Unable to create token source", err)
+		return generic_structs.ApiRequest{}
+	}
+	apiRequest.Client = client
+	apiRequest.AuthCacheKey = cacheKey
+
+	return apiRequest
+}
+
+// Auth function for the OAuth2 refresh token grant.  Refresh tokens rotated
+//    by the server are persisted to the state store and preferred over the
+//    one in the params on later runs, unless the one in the params has
+//    changed since.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Oauth2 params in the order of:
+//              [0] => client ID
+//              [1] => client secret
+//              [2] => scopes (csv)
+//              [3] => token url
+//              [4] => initial refresh token (optional once one is persisted)
+func Oauth2RefreshTokenAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 4 {
+		LogError("Oauth2RefreshTokenAuth", "Expected client ID, client secret, scopes, token URL and refresh token")
+		return generic_structs.ApiRequest{}
+	}
+
+	return applyOauth2Client(apiRequest, "Oauth2RefreshTokenAuth", authParams, func(ctx context.Context) (oauth2.TokenSource, error) {
+		stateKey := refreshTokenStateKey(authParams)
+		refreshToken, ok := GetStateStore().GetState(stateKey)
+		if len(authParams) > 4 && authParams[4] != "" {
+			configuredKey := configuredRefreshTokenStateKey(authParams)
+			configuredHash := tokenCacheKey(authParams[4])
+			if lastConfiguredHash, _ := GetStateStore().GetState(configuredKey); !ok || lastConfiguredHash != configuredHash {
+				refreshToken = authParams[4]
+				if err := GetStateStore().SetState(configuredKey, configuredHash); err != nil {
+					LogError("Oauth2RefreshTokenAuth", "Unable to persist configured refresh token", err)
+				}
+			}
+		} else if !ok {
+			return nil, fmt.Errorf("No refresh token in auth params or state store")
+		}
+
+		return refreshingTokenSource(ctx, oauth2Config(authParams), &oauth2.Token{RefreshToken: refreshToken}, stateKey), nil
+	})
+}
+
+// passwordTokenSource fetches tokens with the resource owner password grant,
+//    using the refresh token when one is issued and falling back to the
+//    password grant if refreshing fails.
+type passwordTokenSource struct {
+	ctx      context.Context
+	cfg      *oauth2.Config
+	username string
+	password string
+	refresh  oauth2.TokenSource
+}
+
+func (p *passwordTokenSource) Token() (*oauth2.Token, error) {
+	if p.refresh != nil {
+		token, err := p.refresh.Token()
+		if err == nil {
+			return token, nil
+		}
+		LogWarning("passwordTokenSource", "Refresh failed - falling back to password grant", err)
+		p.refresh = nil
+	}
+
+	token, err := p.cfg.PasswordCredentialsToken(p.ctx, p.username, p.password)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken != "" {
+		p.refresh = p.cfg.TokenSource(p.ctx, token)
+	}
+
+	return token, nil
+}
+
+// Auth function for the OAuth2 resource owner password credentials grant.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Oauth2 params in the order of:
+//              [0] => client ID
+//              [1] => client secret
+//              [2] => scopes (csv)
+//              [3] => token url
+//              [4] => username
+//              [5] => password
+func Oauth2PasswordAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 6 {
+		LogError("Oauth2PasswordAuth", "Expected client ID, client secret, scopes, token URL, username and password")
+		return generic_structs.ApiRequest{}
+	}
+
+	return applyOauth2Client(apiRequest, "Oauth2PasswordAuth", authParams, func(ctx context.Context) (oauth2.TokenSource, error) {
+		return oauth2.ReuseTokenSource(nil, &passwordTokenSource{
+			ctx:      ctx,
+			cfg:      oauth2Config(authParams),
+			username: authParams[4],
+			password: authParams[5],
+		}), nil
+	})
+}
+
+// privateKeyJwtTokenSource runs the client credentials grant authenticating
+//    with a freshly signed client assertion (RFC 7523 private_key_jwt) on
+//    every token fetch.
+type privateKeyJwtTokenSource struct {
+	ctx      context.Context
+	clientID string
+	key      *rsa.PrivateKey
+	keyID    string
+	scopes   []string
+	tokenUrl string
+	audience string
+}
+
+func (p *privateKeyJwtTokenSource) Token() (*oauth2.Token, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	assertion, err := jws.Encode(&jws.Header{
+		Algorithm: "RS256",
+		Typ:       "JWT",
+		KeyID:     p.keyID,
+	}, &jws.ClaimSet{
+		Iss:           p.clientID,
+		Sub:           p.clientID,
+		Aud:           p.audience,
+		Iat:           now.Unix(),
+		Exp:           now.Add(5 * time.Minute).Unix(),
+		PrivateClaims: map[string]interface{}{"jti": hex.EncodeToString(jti)},
+	}, p.key)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &clientcredentials.Config{
+		ClientID: p.clientID,
+		TokenURL: p.tokenUrl,
+		Scopes:   p.scopes,
+		EndpointParams: url.Values{
+			"client_assertion_type": {jwtBearerAssertionType},
+			"client_assertion":      {assertion},
+		},
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+
+	return cfg.Token(p.ctx)
+}
+
+// Auth function for the OAuth2 client credentials grant using a JWT client
+//    assertion (private_key_jwt) instead of a client secret.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Oauth2 params in the order of:
+//              [0] => client ID
+//              [1] => PEM RSA private key (PKCS#1 or PKCS#8)
+//              [2] => key ID (optional)
+//              [3] => scopes (csv)
+//              [4] => token url
+//              [5] => assertion audience (optional, defaults to the token url)
+func Oauth2PrivateKeyJwtAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 5 {
+		LogError("Oauth2PrivateKeyJwtAuth", "Expected client ID, private key, key ID, scopes and token URL")
+		return generic_structs.ApiRequest{}
+	}
+
+	return applyOauth2Client(apiRequest, "Oauth2PrivateKeyJwtAuth", authParams, func(ctx context.Context) (oauth2.TokenSource, error) {
+		key, err := parseRsaPrivateKey([]byte(authParams[1]))
+		if err != nil {
+			return nil, err
+		}
+		audience := authParams[4]
+		if len(authParams) > 5 && authParams[5] != "" {
+			audience = authParams[5]
+		}
+		var scopes []string
+		if authParams[3] != "" {
+			scopes = strings.Split(authParams[3], ",")
+		}
+
+		return oauth2.ReuseTokenSource(nil, &privateKeyJwtTokenSource{
+			ctx:      ctx,
+			clientID: authParams[0],
+			key:      key,
+			keyID:    authParams[2],
+			scopes:   scopes,
+			tokenUrl: authParams[4],
+			audience: audience,
+		}), nil
+	})
+}
+
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+// Auth function for the OAuth2 device authorization grant (RFC 8628), meant
+//    for interactive bootstrap.  The first run logs a code for the user to
+//    enter at the verification URL and waits for approval; the refresh token
+//    obtained is persisted to the state store so later runs are
+//    non-interactive.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Oauth2 params in the order of:
+//              [0] => client ID
+//              [1] => client secret (may be blank for public clients)
+//              [2] => scopes (csv)
+//              [3] => token url
+//              [4] => device authorization url
+func Oauth2DeviceCodeAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 5 {
+		LogError("Oauth2DeviceCodeAuth", "Expected client ID, client secret, scopes, token URL and device authorization URL")
+		return generic_structs.ApiRequest{}
+	}
+
+	client := httpClientFor(apiRequest)
+	return applyOauth2Client(apiRequest, "Oauth2DeviceCodeAuth", authParams, func(ctx context.Context) (oauth2.TokenSource, error) {
+		cfg := oauth2Config(authParams)
+		stateKey := refreshTokenStateKey(authParams)
+		if refreshToken, ok := GetStateStore().GetState(stateKey); ok {
+			return refreshingTokenSource(ctx, cfg, &oauth2.Token{RefreshToken: refreshToken}, stateKey), nil
+		}
+
+		token, err := runDeviceCodeFlow(client, authParams)
+		if err != nil {
+			return nil, err
+		}
+
+		return refreshingTokenSource(ctx, cfg, token, stateKey), nil
+	})
+}
+
+// Requests a device code, asks the user to approve it and polls the token
+//    endpoint until they do (or the code expires).
+func runDeviceCodeFlow(client *http.Client, authParams []string) (*oauth2.Token, error) {
+	values := url.Values{"client_id": {authParams[0]}}
+	if authParams[2] != "" {
+		values.Set("scope", strings.Replace(authParams[2], ",", " ", -1))
+	}
+	body, status, err := postForm(client, authParams[4], values)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status > 299 {
+		return nil, fmt.Errorf("Device authorization request failed with status %d: %s", status, string(body))
+	}
+
+	var authorization deviceAuthorization
+	if err := json.Unmarshal(body, &authorization); err != nil {
+		return nil, fmt.Errorf("Unable to parse device authorization response: %s", err.Error())
+	}
+	if authorization.VerificationUriComplete != "" {
+		LogInfo("Oauth2DeviceCodeAuth", "To authorize Epico, visit", authorization.VerificationUriComplete)
+	} else {
+		LogInfo("Oauth2DeviceCodeAuth", "To authorize Epico, visit "+authorization.VerificationUri+" and enter the code", authorization.UserCode)
+	}
+
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = DeviceCodeDefaultInterval
+	}
+	deadline := time.Now().Add(time.Duration(authorization.ExpiresIn) * time.Second)
+	if authorization.ExpiresIn <= 0 {
+		deadline = time.Now().Add(15 * time.Minute)
+	}
+
+	values = url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {authorization.DeviceCode},
+		"client_id":   {authParams[0]},
+	}
+	if authParams[1] != "" {
+		values.Set("client_secret", authParams[1])
+	}
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		body, _, err := postForm(client, authParams[3], values)
+		if err != nil {
+			return nil, err
+		}
+		var tokenResponse deviceTokenResponse
+		if err := json.Unmarshal(body, &tokenResponse); err != nil {
+			return nil, fmt.Errorf("Unable to parse device token response: %s", err.Error())
+		}
+
+		switch tokenResponse.Error {
+		case "":
+			if tokenResponse.AccessToken == "" {
+				return nil, fmt.Errorf("Device token response missing access_token")
+			}
+			token := &oauth2.Token{
+				AccessToken:  tokenResponse.AccessToken,
+				TokenType:    tokenResponse.TokenType,
+				RefreshToken: tokenResponse.RefreshToken,
+			}
+			if tokenResponse.ExpiresIn > 0 {
+				token.Expiry = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+			}
+			return token, nil
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("Device authorization failed: %s", tokenResponse.Error)
+		}
+	}
+
+	return nil, fmt.Errorf("Device code expired before it was authorized")
+}
+
+func postForm(client *http.Client, endpoint string, values url.Values) ([]byte, int, error) {
+	resp, err := client.PostForm(endpoint, values)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	return body, resp.StatusCode, nil
+}
+
+func parseRsaPrivateKey(keyPem []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPem)
+	if block == nil {
+		return nil, fmt.Errorf("Private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse private key: %s", err.Error())
+	}
+	key, ok := parsedKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Private key is not an RSA key")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2/jws"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// A local token server supporting the grants under test.  Each grant issues
+//    an access token naming the grant so the API server can check it.
+type testTokenServer struct {
+	*httptest.Server
+	t         *testing.T
+	publicKey *rsa.PublicKey
+
+	mutex         sync.Mutex
+	refreshTokens map[string]bool
+	issued        int
+	devicePolls   int
+}
+
+func newTestTokenServer(t *testing.T) *testTokenServer {
+	server := &testTokenServer{t: t, refreshTokens: map[string]bool{"rt-initial": true}}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	return server
+}
+
+func (s *testTokenServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/device" {
+		fmt.Fprint(w, `{"device_code": "dev-1", "user_code": "ABCD-EFGH", "verification_uri": "https://example.com/device", "expires_in": 60}`)
+		return
+	}
+
+	switch r.FormValue("grant_type") {
+	case "refresh_token":
+		refreshToken := r.FormValue("refresh_token")
+		if !s.refreshTokens[refreshToken] {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+		// Rotate - the old refresh token is no longer valid.
+		delete(s.refreshTokens, refreshToken)
+		s.issued++
+		newRefreshToken := fmt.Sprintf("rt-%d", s.issued)
+		s.refreshTokens[newRefreshToken] = true
+		fmt.Fprintf(w, `{"access_token": "refresh-access", "token_type": "bearer", "expires_in": 3600, "refresh_token": "%s"}`, newRefreshToken)
+	case "password":
+		if r.FormValue("username") != "alice" || r.FormValue("password") != "wonderland" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "password-access", "token_type": "bearer", "expires_in": 3600}`)
+	case "client_credentials":
+		if r.FormValue("client_assertion_type") != jwtBearerAssertionType {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assertion := r.FormValue("client_assertion")
+		if err := jws.Verify(assertion, s.publicKey); err != nil {
+			s.t.Errorf("expect valid assertion signature, got %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		claims, err := jws.Decode(assertion)
+		if err != nil || claims.Iss != "client" || claims.Sub != "client" || claims.Aud != s.URL+"/token" {
+			s.t.Errorf("unexpected assertion claims %+v (%v)", claims, err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "jwt-access", "token_type": "bearer", "expires_in": 3600}`)
+	case deviceCodeGrantType:
+		s.devicePolls++
+		if s.devicePolls < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "authorization_pending"}`)
+			return
+		}
+		s.refreshTokens["rt-device"] = true
+		fmt.Fprint(w, `{"access_token": "device-access", "token_type": "bearer", "expires_in": 3600, "refresh_token": "rt-device"}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "unsupported_grant_type"}`)
+	}
+}
+
+// Runs an authenticated request against an API server that echoes the
+//    bearer token back.
+func runOauth2TestRequest(t *testing.T, authFunction func(generic_structs.ApiRequest, []string) generic_structs.ApiRequest, authParams []string) string {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer apiServer.Close()
+
+	apiRequest := authFunction(newTestApiRequest(t, apiServer.URL), authParams)
+	if apiRequest.Client == nil {
+		t.Fatalf("expect auth function to set a client")
+	}
+	resp, err := apiRequest.Client.Do(apiRequest.FullRequest)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer resp.Body.Close()
+	body := make([]byte, 128)
+	n, _ := resp.Body.Read(body)
+	InvalidateCachedToken(apiRequest.AuthCacheKey)
+
+	return string(body[:n])
+}
+
+func TestOauth2RefreshTokenAuthRotation(t *testing.T) {
+	server := newTestTokenServer(t)
+	defer server.Close()
+	store := NewMemoryStateStore()
+	SetStateStore(store)
+	defer SetStateStore(nil)
+
+	authParams := []string{"client", "secret", "read", server.URL + "/token", "rt-initial"}
+	if e, a := "Bearer refresh-access", runOauth2TestRequest(t, Oauth2RefreshTokenAuth, authParams); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	rotated, ok := store.GetState(refreshTokenStateKey(authParams))
+	if !ok || rotated != "rt-1" {
+		t.Errorf("expect rotated refresh token rt-1 to be persisted, got %v", rotated)
+	}
+
+	// rt-initial is no longer valid, so a second run only works if the
+	//    persisted token is used.
+	if e, a := "Bearer refresh-access", runOauth2TestRequest(t, Oauth2RefreshTokenAuth, authParams); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if rotated, _ := store.GetState(refreshTokenStateKey(authParams)); rotated != "rt-2" {
+		t.Errorf("expect rotated refresh token rt-2 to be persisted, got %v", rotated)
+	}
+
+	// A new refresh token in the params is used over the persisted one, and
+	//    the token it's rotated to after that.
+	server.mutex.Lock()
+	server.refreshTokens["rt-new"] = true
+	server.mutex.Unlock()
+	authParams[4] = "rt-new"
+	for i := 3; i <= 4; i++ {
+		if e, a := "Bearer refresh-access", runOauth2TestRequest(t, Oauth2RefreshTokenAuth, authParams); e != a {
+			t.Errorf("expect %v, got %v", e, a)
+		}
+		if rotated, _ := store.GetState(refreshTokenStateKey(authParams)); rotated != fmt.Sprintf("rt-%d", i) {
+			t.Errorf("expect rotated refresh token rt-%d to be persisted, got %v", i, rotated)
+		}
+	}
+}
+
+func TestOauth2PasswordAuth(t *testing.T) {
+	server := newTestTokenServer(t)
+	defer server.Close()
+
+	authParams := []string{"client", "secret", "", server.URL + "/token", "alice", "wonderland"}
+	if e, a := "Bearer password-access", runOauth2TestRequest(t, Oauth2PasswordAuth, authParams); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	if apiRequest := Oauth2PasswordAuth(newTestApiRequest(t, server.URL), authParams[:4]); apiRequest.FullRequest != nil {
+		t.Errorf("expect failure without username and password")
+	}
+}
+
+func TestOauth2PrivateKeyJwtAuth(t *testing.T) {
+	server := newTestTokenServer(t)
+	defer server.Close()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.publicKey = &key.PublicKey
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	authParams := []string{"client", string(keyPem), "key-1", "read,write", server.URL + "/token"}
+	if e, a := "Bearer jwt-access", runOauth2TestRequest(t, Oauth2PrivateKeyJwtAuth, authParams); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	authParams[1] = "not a key"
+	if apiRequest := Oauth2PrivateKeyJwtAuth(newTestApiRequest(t, server.URL), authParams); apiRequest.FullRequest != nil {
+		t.Errorf("expect failure with an invalid private key")
+	}
+}
+
+func TestOauth2DeviceCodeAuth(t *testing.T) {
+	server := newTestTokenServer(t)
+	defer server.Close()
+	store := NewMemoryStateStore()
+	SetStateStore(store)
+	defer SetStateStore(nil)
+	defaultInterval := DeviceCodeDefaultInterval
+	DeviceCodeDefaultInterval = 10 * time.Millisecond
+	defer func() { DeviceCodeDefaultInterval = defaultInterval }()
+
+	authParams := []string{"client", "", "read", server.URL + "/token", server.URL + "/device"}
+	if e, a := "Bearer device-access", runOauth2TestRequest(t, Oauth2DeviceCodeAuth, authParams); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := 3, server.devicePolls; e != a {
+		t.Errorf("expect %v polls, got %v", e, a)
+	}
+	if refreshToken, _ := store.GetState(refreshTokenStateKey(authParams)); refreshToken != "rt-device" {
+		t.Errorf("expect device refresh token to be persisted, got %v", refreshToken)
+	}
+
+	// Later runs refresh without going through the device flow again.
+	if e, a := "Bearer refresh-access", runOauth2TestRequest(t, Oauth2DeviceCodeAuth, authParams); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := 3, server.devicePolls; e != a {
+		t.Errorf("expect no further polls, got %v", a)
+	}
+}
+
+func TestFileStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epico-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := &FileStateStore{Path: filepath.Join(dir, "state.json")}
+	if _, ok := store.GetState("missing"); ok {
+		t.Errorf("expect missing key")
+	}
+	if err := store.SetState("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetState("b", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := &FileStateStore{Path: store.Path}
+	if value, ok := reloaded.GetState("a"); !ok || value != "1" {
+		t.Errorf("expect 1, got %v", value)
+	}
+	if value, ok := reloaded.GetState("b"); !ok || value != "2" {
+		t.Errorf("expect 2, got %v", value)
+	}
+}
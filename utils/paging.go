@@ -0,0 +1,433 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A single link from an RFC 8288 (formerly RFC 5988) Link header.
+type Link struct {
+	Url    string
+	Params map[string]string // Parameter names are lower cased
+}
+
+// Returns whether the link has the relation type given.  rel may hold
+//    several space separated types, e.g. rel="next last".
+func (l Link) HasRel(rel string) bool {
+	for _, r := range strings.Fields(l.Params["rel"]) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parses the values of one or more Link headers.  Malformed links are
+//    skipped rather than failing the whole header.
+// Vars:
+// headers = The Link header values.
+func ParseLinkHeader(headers []string) []Link {
+	links := []Link{}
+	for _, header := range headers {
+		for {
+			header = strings.TrimLeft(header, " \t,")
+			if header == "" {
+				break
+			}
+			if header[0] != '<' {
+				// Not a link - skip to the next one.
+				header = skipLinkValue(header)
+				continue
+			}
+			end := strings.IndexByte(header, '>')
+			if end < 0 {
+				break
+			}
+			link := Link{Url: strings.TrimSpace(header[1:end]), Params: make(map[string]string)}
+			header = header[end+1:]
+
+			// Parameters run until the comma separating this link from the
+			//    next one.
+			for {
+				header = strings.TrimLeft(header, " \t")
+				if header == "" || header[0] == ',' {
+					break
+				}
+				if header[0] != ';' {
+					header = skipLinkValue(header)
+					break
+				}
+				header = strings.TrimLeft(header[1:], " \t")
+
+				nameEnd := strings.IndexAny(header, "=;,")
+				if nameEnd < 0 {
+					nameEnd = len(header)
+				}
+				name := strings.ToLower(strings.TrimSpace(header[:nameEnd]))
+				header = header[nameEnd:]
+
+				value := ""
+				if header != "" && header[0] == '=' {
+					header = strings.TrimLeft(header[1:], " \t")
+					value, header = parseLinkParamValue(header)
+				}
+				// Only the first occurrence of a parameter counts.
+				if _, ok := link.Params[name]; name != "" && !ok {
+					link.Params[name] = value
+				}
+			}
+
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+// Reads a token or quoted-string parameter value, returning the value and
+//    the rest of the header.
+func parseLinkParamValue(header string) (string, string) {
+	if header == "" || header[0] != '"' {
+		end := strings.IndexAny(header, ";,")
+		if end < 0 {
+			end = len(header)
+		}
+		return strings.TrimSpace(header[:end]), header[end:]
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(header); i++ {
+		switch header[i] {
+		case '\\':
+			if i+1 < len(header) {
+				i++
+				value.WriteByte(header[i])
+			}
+		case '"':
+			return value.String(), header[i+1:]
+		default:
+			value.WriteByte(header[i])
+		}
+	}
+
+	// Unterminated quotes run to the end of the header.
+	return value.String(), ""
+}
+
+// Skips past the rest of a link, honouring quoted strings, returning the
+//    header from the next comma on.
+func skipLinkValue(header string) string {
+	quoted := false
+	for i := 0; i < len(header); i++ {
+		switch {
+		case header[i] == '\\' && quoted:
+			i++
+		case header[i] == '"':
+			quoted = !quoted
+		case header[i] == ',' && !quoted:
+			return header[i:]
+		}
+	}
+
+	return ""
+}
+
+// Peeks at the response headers for an RFC 8288 Link header and returns the
+//    URL of the next page.  Used for the link_header paging mode.
+// Vars:
+// response     = The response headers as the JSON marshaled header map.
+// responseKeys = [0] => Link relation to follow (optional - default "next").
+// oldPageValue = The previous page value.
+// peekParams   = Unused, plugin-specific params.
+func LinkHeaderPagingPeek(response []byte, responseKeys []string, oldPageValue interface{}, peekParams []string) (interface{}, bool) {
+	var headers map[string][]string
+	if err := json.Unmarshal(response, &headers); err != nil {
+		LogError("LinkHeaderPagingPeek", "Unable to Unmarshal response headers", err)
+		return interface{}(nil), false
+	}
+
+	rel := "next"
+	if len(responseKeys) > 0 && responseKeys[0] != "" {
+		rel = responseKeys[0]
+	}
+
+	for name, values := range headers {
+		if !strings.EqualFold(name, "Link") {
+			continue
+		}
+		for _, link := range ParseLinkHeader(values) {
+			if link.HasRel(rel) && link.Url != "" {
+				if link.Url == oldPageValue {
+					return interface{}(nil), false
+				}
+				return link.Url, true
+			}
+		}
+	}
+
+	return interface{}(nil), false
+}
+
+// Returns whether a next page URL is on the same scheme and host (including
+//    port) as the first page.  The auth is re-run for every page, so a Link
+//    header mustn't be able to send it to another host.
+// Vars:
+// firstUrl = URL of the first page.
+// nextUrl  = URL of the next page.
+func SameUrlOrigin(firstUrl *url.URL, nextUrl *url.URL) bool {
+	return strings.EqualFold(firstUrl.Scheme, nextUrl.Scheme) && strings.EqualFold(firstUrl.Host, nextUrl.Host)
+}
+
+// Default cap on the pages fetched by the offset and page_number paging
+//    modes, which can't tell when an API ignores the paging param and keeps
+//    returning the same page.
+const DefaultCountedPagingMaxPages = 1000
+
+// Peeks at a response for offset/limit paging, returning the offset of the
+//    next page - the previous offset plus the items returned.  Paging stops
+//    on an empty page, or a page shorter than the page size if it is known.
+// Vars:
+// response     = The JSON response in []byte form.
+// responseKeys = The split list of keys to the items returned.
+// oldPageValue = The offset the response was fetched with, as a float64.
+// peekParams   = [0] => Page size (optional).
+func OffsetPagingPeek(response []byte, responseKeys []string, oldPageValue interface{}, peekParams []string) (interface{}, bool) {
+	offset, ok := oldPageValue.(float64)
+	if !ok {
+		LogError("OffsetPagingPeek", "Invalid previous offset", oldPageValue)
+		return interface{}(nil), false
+	}
+	itemCount, morePages := countedPagingPeek("OffsetPagingPeek", response, responseKeys, peekParams)
+	if !morePages {
+		return interface{}(nil), false
+	}
+
+	return offset + float64(itemCount), true
+}
+
+// Peeks at a response for page number paging, returning the next page
+//    number.  Paging stops on an empty page, or a page shorter than the page
+//    size if it is known.
+// Vars:
+// response     = The JSON response in []byte form.
+// responseKeys = The split list of keys to the items returned.
+// oldPageValue = The page number the response was fetched with, as a float64.
+// peekParams   = [0] => Page size (optional).
+func PageNumberPagingPeek(response []byte, responseKeys []string, oldPageValue interface{}, peekParams []string) (interface{}, bool) {
+	pageNumber, ok := oldPageValue.(float64)
+	if !ok {
+		LogError("PageNumberPagingPeek", "Invalid previous page number", oldPageValue)
+		return interface{}(nil), false
+	}
+	if _, morePages := countedPagingPeek("PageNumberPagingPeek", response, responseKeys, peekParams); !morePages {
+		return interface{}(nil), false
+	}
+
+	return pageNumber + 1, true
+}
+
+// Counts the items on a page and decides whether there should be another.
+func countedPagingPeek(function string, response []byte, responseKeys []string, peekParams []string) (int, bool) {
+	itemCount, err := PageItemCount(response, responseKeys)
+	if err != nil {
+		LogError(function, "Unable to count the items returned", err)
+		return 0, false
+	}
+	if itemCount == 0 {
+		return 0, false
+	}
+
+	if len(peekParams) > 0 && peekParams[0] != "" {
+		pageSize, err := strconv.Atoi(peekParams[0])
+		if err != nil || pageSize < 1 {
+			LogError(function, "Invalid page size", peekParams[0])
+			return 0, false
+		}
+		if itemCount < pageSize {
+			return itemCount, false
+		}
+	}
+
+	return itemCount, true
+}
+
+// Counts the items in a JSON response at the key set given, e.g. the
+//    current_base_key.  A blank key set counts a response that is a list.
+// Vars:
+// response     = The JSON response in []byte form.
+// responseKeys = The split list of keys to the items.
+func PageItemCount(response []byte, responseKeys []string) (int, error) {
+	var unparsedStructure interface{}
+	if err := json.Unmarshal(response, &unparsedStructure); err != nil {
+		return 0, err
+	}
+
+	if len(responseKeys) == 0 || (len(responseKeys) == 1 && responseKeys[0] == "") {
+		if items, ok := unparsedStructure.([]interface{}); ok {
+			return len(items), nil
+		}
+		return 0, errors.New("no item key given for a response that isn't a list")
+	}
+
+	return len(ParseJsonSubStructure(responseKeys, 0, unparsedStructure)), nil
+}
+
+// Checks the paging settings of the modes Epico pages itself.  The offset and
+//    page_number modes can only send the next page in the querystring - left
+//    anywhere else, the same page would be fetched over and over.
+// Vars:
+// paging = The endpoint's paging settings.
+func ValidatePaging(paging map[string]string) error {
+	mode := paging["indicator_from_structure"]
+	if mode != "offset" && mode != "page_number" {
+		return nil
+	}
+	if paging["location_to"] != "querystring" {
+		return fmt.Errorf("%s paging needs location_to: querystring, got %q", mode, paging["location_to"])
+	}
+	if paging["indicator_to_field"] == "" {
+		return fmt.Errorf("%s paging needs an indicator_to_field", mode)
+	}
+
+	return nil
+}
+
+// Limits on how far an endpoint is paged, set in its paging settings as
+//    max_pages, max_items and max_duration.  Zero values are unlimited.
+type PagingLimits struct {
+	MaxPages    int
+	MaxItems    int
+	MaxDuration time.Duration
+}
+
+// Reads the paging limits from an endpoint's paging settings.  The offset
+//    and page_number modes default to DefaultCountedPagingMaxPages pages.
+// Vars:
+// paging = The endpoint's paging settings.
+func ParsePagingLimits(paging map[string]string) (PagingLimits, error) {
+	var limits PagingLimits
+	var err error
+
+	if paging["max_pages"] != "" {
+		if limits.MaxPages, err = strconv.Atoi(paging["max_pages"]); err != nil || limits.MaxPages < 0 {
+			return PagingLimits{}, fmt.Errorf("invalid max_pages %q", paging["max_pages"])
+		}
+	} else if paging["indicator_from_structure"] == "offset" || paging["indicator_from_structure"] == "page_number" {
+		limits.MaxPages = DefaultCountedPagingMaxPages
+	}
+	if paging["max_items"] != "" {
+		if limits.MaxItems, err = strconv.Atoi(paging["max_items"]); err != nil || limits.MaxItems < 0 {
+			return PagingLimits{}, fmt.Errorf("invalid max_items %q", paging["max_items"])
+		}
+	}
+	if paging["max_duration"] != "" {
+		if limits.MaxDuration, err = time.ParseDuration(paging["max_duration"]); err != nil || limits.MaxDuration < 0 {
+			return PagingLimits{}, fmt.Errorf("invalid max_duration %q", paging["max_duration"])
+		}
+	}
+
+	return limits, nil
+}
+
+// Keeps an endpoint's paging within its limits, and stops it if the API
+//    hands back a page value it has already been given.
+type PagingGuard struct {
+	limits   PagingLimits
+	itemKeys []string
+	started  time.Time
+	pages    int
+	items    int
+	seen     map[string]bool
+}
+
+// Starts guarding the paging of an endpoint.
+// Vars:
+// limits   = The endpoint's paging limits.
+// itemKeys = The split list of keys to the items on each page, for max_items.
+func NewPagingGuard(limits PagingLimits, itemKeys []string) *PagingGuard {
+	return &PagingGuard{
+		limits:   limits,
+		itemKeys: itemKeys,
+		started:  time.Now(),
+		seen:     make(map[string]bool),
+	}
+}
+
+// Records a page that has been fetched.
+// Vars:
+// response = The JSON response for the page.
+func (g *PagingGuard) AddPage(response []byte) {
+	g.pages++
+	if g.limits.MaxItems > 0 {
+		// Pages that can't be counted don't count towards max_items.
+		if itemCount, err := PageItemCount(response, g.itemKeys); err == nil {
+			g.items += itemCount
+		}
+	}
+}
+
+// Records a page value as used without checking it, e.g. for the first page.
+// Vars:
+// pageValue = The page value.
+func (g *PagingGuard) Seen(pageValue interface{}) {
+	g.seen[pagingValueKey(pageValue)] = true
+}
+
+// Returns why paging has to stop rather than fetch the page for the value
+//    given - max_pages, max_items, max_duration or cycle - or blank if it can
+//    carry on.
+// Vars:
+// pageValue = The page value the next page would be fetched with.
+func (g *PagingGuard) Stop(pageValue interface{}) string {
+	switch {
+	case g.limits.MaxPages > 0 && g.pages >= g.limits.MaxPages:
+		return "max_pages"
+	case g.limits.MaxItems > 0 && g.items >= g.limits.MaxItems:
+		return "max_items"
+	case g.limits.MaxDuration > 0 && time.Since(g.started) >= g.limits.MaxDuration:
+		return "max_duration"
+	}
+
+	key := pagingValueKey(pageValue)
+	if g.seen[key] {
+		return "cycle"
+	}
+	g.seen[key] = true
+
+	return ""
+}
+
+// Describes where paging stopped, for logging.
+// Vars:
+// reason = The reason returned by Stop.
+func (g *PagingGuard) Describe(reason string) string {
+	description := fmt.Sprintf("after %d pages and %s", g.pages, time.Since(g.started).Round(time.Millisecond))
+	switch reason {
+	case "max_pages":
+		return fmt.Sprintf("Stopped paging at max_pages (%d) %s - results are incomplete", g.limits.MaxPages, description)
+	case "max_items":
+		return fmt.Sprintf("Stopped paging at max_items (%d, %d fetched) %s - results are incomplete", g.limits.MaxItems, g.items, description)
+	case "max_duration":
+		return fmt.Sprintf("Stopped paging at max_duration (%s) %s - results are incomplete", g.limits.MaxDuration, description)
+	case "cycle":
+		return "Stopped paging on a page value already fetched " + description + " - results may be incomplete"
+	}
+
+	return ""
+}
+
+// Returns a comparable key for a page value.
+func pagingValueKey(pageValue interface{}) string {
+	key, err := json.Marshal(pageValue)
+	if err != nil {
+		return fmt.Sprintf("%#v", pageValue)
+	}
+
+	return string(key)
+}
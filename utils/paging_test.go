@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+func TestParseLinkHeader(t *testing.T) {
+	links := ParseLinkHeader([]string{
+		`<https://api.github.com/user/repos?page=3&per_page=100>; rel="next", <https://api.github.com/user/repos?page=50&per_page=100>; rel="last"`,
+		`<https://example.com/a,b>; title="x, \"y\""; REL=prev;rel=ignored, junk; rel="next", <https://example.com/c>; rel="next last"`,
+	})
+
+	expected := []Link{
+		{Url: "https://api.github.com/user/repos?page=3&per_page=100", Params: map[string]string{"rel": "next"}},
+		{Url: "https://api.github.com/user/repos?page=50&per_page=100", Params: map[string]string{"rel": "last"}},
+		{Url: "https://example.com/a,b", Params: map[string]string{"title": `x, "y"`, "rel": "prev"}},
+		{Url: "https://example.com/c", Params: map[string]string{"rel": "next last"}},
+	}
+	if !reflect.DeepEqual(expected, links) {
+		t.Errorf("expect\n%v\ngot\n%v", expected, links)
+	}
+	if !links[3].HasRel("last") || !links[3].HasRel("NEXT") || links[3].HasRel("prev") {
+		t.Errorf("expect space separated relations to match, got %v", links[3].Params)
+	}
+
+	if links := ParseLinkHeader([]string{"", `<https://example.com/unterminated`}); len(links) != 0 {
+		t.Errorf("expect no links, got %v", links)
+	}
+}
+
+func TestLinkHeaderPagingPeek(t *testing.T) {
+	header := http.Header{}
+	header.Add("Link", `<https://gitlab.example.com/api/v4/projects?page=1>; rel="first"`)
+	header.Add("Link", `<https://gitlab.example.com/api/v4/projects?page=2>; rel="next", <https://gitlab.example.com/api/v4/projects?page=9>; rel="last"`)
+	headers, _ := json.Marshal(header)
+
+	pageValue, morePages := LinkHeaderPagingPeek(headers, []string{""}, nil, nil)
+	if e, a := "https://gitlab.example.com/api/v4/projects?page=2", pageValue; !morePages || e != a {
+		t.Errorf("expect %v, got %v %v", e, a, morePages)
+	}
+	pageValue, morePages = LinkHeaderPagingPeek(headers, []string{"last"}, nil, nil)
+	if e, a := "https://gitlab.example.com/api/v4/projects?page=9", pageValue; !morePages || e != a {
+		t.Errorf("expect %v, got %v %v", e, a, morePages)
+	}
+
+	// The same URL again, or no next link, ends paging.
+	if _, morePages := LinkHeaderPagingPeek(headers, nil, "https://gitlab.example.com/api/v4/projects?page=2", nil); morePages {
+		t.Errorf("expect a repeated URL to end paging")
+	}
+	if _, morePages := LinkHeaderPagingPeek([]byte(`{"Content-Type": ["application/json"]}`), nil, nil, nil); morePages {
+		t.Errorf("expect no more pages without a Link header")
+	}
+}
+
+// Link header APIs such as GitHub's return a list per page.
+func TestSameUrlOrigin(t *testing.T) {
+	first, _ := url.Parse("https://api.example.com/items?page=1")
+	cases := map[string]bool{
+		"https://API.example.com/items?page=2": true,
+		"/items?page=2":                        true,
+		"https://evil.example.com/items":       false,
+		"http://api.example.com/items":         false,
+		"https://api.example.com:8443/items":   false,
+	}
+	for next, expected := range cases {
+		nextUrl, _ := first.Parse(next)
+		if actual := SameUrlOrigin(first, nextUrl); actual != expected {
+			t.Errorf("%s: expect %v, got %v", next, expected, actual)
+		}
+	}
+}
+
+func TestDefaultJsonPostProcessPagedSlices(t *testing.T) {
+	jsonKeys := []map[string]string{{
+		"api_call_uuid":      "1",
+		"key_count":          "1",
+		"current_base_key_0": "",
+		"desired_base_key_0": "repositories",
+	}}
+	apiResponseMap := map[generic_structs.ComparableApiRequest][]byte{
+		{Uuid: "1", Endpoint: "page1"}: []byte(`[{"id": 1}, {"id": 2}]`),
+		{Uuid: "1", Endpoint: "page2"}: []byte(`[{"id": 3}]`),
+	}
+
+	var output map[string][]interface{}
+	if err := json.Unmarshal(DefaultJsonPostProcess(apiResponseMap, jsonKeys), &output); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := 3, len(output["repositories"]); e != a {
+		t.Errorf("expect %v repositories from both pages, got %v", e, output)
+	}
+}
+
+func TestOffsetPagingPeek(t *testing.T) {
+	fullPage := []byte(`{"data": {"rows": [{"id": 1}, {"id": 2}]}}`)
+	keys := []string{"data", "rows"}
+
+	if pageValue, morePages := OffsetPagingPeek(fullPage, keys, float64(10), []string{"2"}); !morePages || pageValue != float64(12) {
+		t.Errorf("expect offset 12, got %v %v", pageValue, morePages)
+	}
+	// Without a page size, only an empty page ends paging.
+	if pageValue, morePages := OffsetPagingPeek(fullPage, keys, float64(0), nil); !morePages || pageValue != float64(2) {
+		t.Errorf("expect offset 2, got %v %v", pageValue, morePages)
+	}
+	if _, morePages := OffsetPagingPeek(fullPage, keys, float64(0), []string{"3"}); morePages {
+		t.Errorf("expect a short page to end paging")
+	}
+	if _, morePages := OffsetPagingPeek([]byte(`{"data": {"rows": []}}`), keys, float64(0), nil); morePages {
+		t.Errorf("expect an empty page to end paging")
+	}
+	if _, morePages := OffsetPagingPeek(fullPage, keys, float64(0), []string{"none"}); morePages {
+		t.Errorf("expect an invalid page size to end paging")
+	}
+}
+
+func TestPageNumberPagingPeek(t *testing.T) {
+	if pageValue, morePages := PageNumberPagingPeek([]byte(`[{"id": 1}, {"id": 2}]`), []string{""}, float64(1), []string{"2"}); !morePages || pageValue != float64(2) {
+		t.Errorf("expect page 2, got %v %v", pageValue, morePages)
+	}
+	if _, morePages := PageNumberPagingPeek([]byte(`[]`), []string{""}, float64(2), nil); morePages {
+		t.Errorf("expect an empty page to end paging")
+	}
+	if _, morePages := PageNumberPagingPeek([]byte(`[{"id": 1}]`), []string{""}, nil, nil); morePages {
+		t.Errorf("expect a missing page number to end paging")
+	}
+}
+
+func TestPageItemCount(t *testing.T) {
+	cases := []struct {
+		response string
+		keys     []string
+		count    int
+	}{
+		{`[1, 2, 3]`, nil, 3},
+		{`{"items": [{"id": 1}]}`, []string{"items"}, 1},
+		{`{"items": {"id": 1}}`, []string{"items"}, 1},
+		{`{"items": null}`, []string{"items"}, 0},
+		{`[{"tags": [1, 2]}, {"tags": [3]}]`, []string{"tags"}, 3},
+	}
+	for _, c := range cases {
+		if count, err := PageItemCount([]byte(c.response), c.keys); err != nil || count != c.count {
+			t.Errorf("expect %v items in %v, got %v (%v)", c.count, c.response, count, err)
+		}
+	}
+	if _, err := PageItemCount([]byte(`{"items": []}`), []string{""}); err == nil {
+		t.Errorf("expect an error counting a map without a key")
+	}
+}
+
+func TestParsePagingLimits(t *testing.T) {
+	limits, err := ParsePagingLimits(map[string]string{"max_pages": "5", "max_items": "100", "max_duration": "1m"})
+	if e := (PagingLimits{MaxPages: 5, MaxItems: 100, MaxDuration: time.Minute}); err != nil || e != limits {
+		t.Errorf("expect %v, got %v (%v)", e, limits, err)
+	}
+	if limits, _ := ParsePagingLimits(map[string]string{"indicator_from_structure": "offset"}); limits.MaxPages != DefaultCountedPagingMaxPages {
+		t.Errorf("expect offset paging to be capped by default, got %v", limits)
+	}
+	if limits, _ := ParsePagingLimits(map[string]string{}); limits != (PagingLimits{}) {
+		t.Errorf("expect no limits by default, got %v", limits)
+	}
+	for _, paging := range []map[string]string{{"max_pages": "x"}, {"max_items": "-1"}, {"max_duration": "10"}} {
+		if _, err := ParsePagingLimits(paging); err == nil {
+			t.Errorf("expect an error for %v", paging)
+		}
+	}
+}
+
+func TestValidatePaging(t *testing.T) {
+	valid := []map[string]string{
+		nil,
+		{"indicator_from_structure": "link_header"},
+		{"indicator_from_structure": "offset", "location_to": "querystring", "indicator_to_field": "offset"},
+	}
+	for _, paging := range valid {
+		if err := ValidatePaging(paging); err != nil {
+			t.Errorf("%v: expect no error, got %v", paging, err)
+		}
+	}
+	invalid := []map[string]string{
+		{"indicator_from_structure": "offset", "indicator_to_field": "offset"},
+		{"indicator_from_structure": "page_number", "location_to": "body", "indicator_to_field": "page"},
+		{"indicator_from_structure": "page_number", "location_to": "querystring"},
+	}
+	for _, paging := range invalid {
+		if err := ValidatePaging(paging); err == nil {
+			t.Errorf("%v: expect an error", paging)
+		}
+	}
+}
+
+func TestPagingGuard(t *testing.T) {
+	page := []byte(`{"items": [1, 2]}`)
+
+	guard := NewPagingGuard(PagingLimits{MaxPages: 2}, []string{"items"})
+	guard.AddPage(page)
+	if reason := guard.Stop("b"); reason != "" {
+		t.Errorf("expect paging to carry on, got %v", reason)
+	}
+	guard.AddPage(page)
+	if reason := guard.Stop("c"); reason != "max_pages" {
+		t.Errorf("expect max_pages, got %v", reason)
+	}
+
+	guard = NewPagingGuard(PagingLimits{MaxItems: 3}, []string{"items"})
+	guard.AddPage(page)
+	guard.Stop(float64(2))
+	guard.AddPage(page)
+	if reason := guard.Stop(float64(4)); reason != "max_items" {
+		t.Errorf("expect max_items, got %v", reason)
+	}
+
+	guard = NewPagingGuard(PagingLimits{MaxDuration: time.Nanosecond}, nil)
+	time.Sleep(time.Millisecond)
+	if reason := guard.Stop("b"); reason != "max_duration" {
+		t.Errorf("expect max_duration, got %v", reason)
+	}
+
+	// Any value already used is a cycle, not just the last one.
+	guard = NewPagingGuard(PagingLimits{}, nil)
+	guard.Seen("a")
+	for _, pageValue := range []string{"b", "c"} {
+		if reason := guard.Stop(pageValue); reason != "" {
+			t.Errorf("expect %v to be fetched, got %v", pageValue, reason)
+		}
+	}
+	for _, pageValue := range []string{"a", "b"} {
+		if reason := guard.Stop(pageValue); reason != "cycle" {
+			t.Errorf("expect %v to be a cycle, got %v", pageValue, reason)
+		}
+	}
+}
+
+func TestDefaultJsonPostProcessPartialResults(t *testing.T) {
+	jsonKeys := []map[string]string{
+		{"api_call_name": "users", "api_call_uuid": "1", "key_count": "1", "current_base_key_0": "users", "desired_base_key_0": "users", "partial_result": "max_pages"},
+		{"api_call_name": "groups", "api_call_uuid": "2", "key_count": "1", "current_base_key_0": "groups", "desired_base_key_0": "groups"},
+	}
+	apiResponseMap := map[generic_structs.ComparableApiRequest][]byte{
+		{Uuid: "1"}: []byte(`{"users": [{"id": 1}]}`),
+		{Uuid: "2"}: []byte(`{"groups": [{"id": 2}]}`),
+	}
+
+	var output map[string][]map[string]interface{}
+	if err := json.Unmarshal(DefaultJsonPostProcess(apiResponseMap, jsonKeys), &output); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{{"api_call_name": "users", "reason": "max_pages"}}
+	if !reflect.DeepEqual(expected, output["partial_results"]) || len(output["users"]) != 1 {
+		t.Errorf("expect the users call to be marked partial, got %v", output)
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// The key provenance metadata is added at in each record.
+const ProvenanceKey = "_epico"
+
+// Returns the provenance metadata for the records from a response - the API
+//    and endpoint names, the URL requested (without its querystring), the
+//    page, when it was fetched and, for sub-endpoints, the parent's
+//    endpoint_key.  Returns nil unless the call's API root set provenance.
+// Vars:
+// request = The request the response was for.
+// keys    = The call's key set.
+func RecordProvenance(request generic_structs.ComparableApiRequest, keys map[string]string) map[string]interface{} {
+	if keys["provenance"] != "true" {
+		return nil
+	}
+
+	provenance := map[string]interface{}{
+		"api":      keys["api_name"],
+		"endpoint": keys["api_call_name"],
+		// Numbers are float64, as JSON decodes them.
+		"page": float64(request.Page),
+	}
+	if request.Url != "" {
+		provenance["url"] = request.Url
+	}
+	if !request.Time.IsZero() {
+		provenance["fetched_at"] = request.Time.UTC().Format(time.RFC3339)
+	}
+	if endpointKey, ok := keys["endpoint_key"]; ok {
+		provenance["endpoint_key"] = endpointKey
+	}
+
+	return provenance
+}
+
+// Adds provenance metadata to each record that is an object, at
+//    ProvenanceKey.  Each record gets its own copy.
+// Vars:
+// records    = The records to annotate.
+// provenance = The metadata, from RecordProvenance.
+func AddRecordProvenance(records []interface{}, provenance map[string]interface{}) {
+	for _, record := range records {
+		recordMap, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		recordProvenance := make(map[string]interface{}, len(provenance))
+		for k, v := range provenance {
+			recordProvenance[k] = v
+		}
+		recordMap[ProvenanceKey] = recordProvenance
+	}
+}
@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	generic_structs "github.com/SREnity/epico/structs"
+	"gopkg.in/yaml.v2"
+)
+
+// Formats an endpoint's responses can be decoded from - see DecodeResponse.
+const (
+	ResponseFormatJson   = "json"
+	ResponseFormatXml    = "xml"
+	ResponseFormatCsv    = "csv"
+	ResponseFormatTsv    = "tsv"
+	ResponseFormatNdjson = "ndjson"
+	ResponseFormatYaml   = "yaml"
+)
+
+var responseFormats = map[string]bool{"": true, ResponseFormatJson: true, ResponseFormatXml: true, ResponseFormatCsv: true, ResponseFormatTsv: true, ResponseFormatNdjson: true, ResponseFormatYaml: true}
+
+// Key an XML element's text is kept at when it also has attributes or
+//    children, as goxml2json does.
+const xmlContentKey = "#content"
+
+// Checks a response format is one DecodeResponse handles.
+// Vars:
+// format = The endpoint's response_format.
+func ValidateResponseFormat(format string) error {
+	if !responseFormats[format] {
+		return fmt.Errorf("invalid response format %q - must be %s, %s, %s, %s, %s or %s", format, ResponseFormatJson, ResponseFormatXml, ResponseFormatCsv, ResponseFormatTsv, ResponseFormatNdjson, ResponseFormatYaml)
+	}
+
+	return nil
+}
+
+// Decodes a response to JSON, so base keys, paging and sub-endpoints work the
+//    same whatever the API returns.  JSON (the default) is returned as is.
+//    CSV, TSV and NDJSON give a list of records, which are found with a
+//    blank current_base_key as for any JSON list.
+//    * xml    - Elements become objects keyed by their local names, without
+//               namespaces - an error if siblings from different namespaces
+//               share one.  Attributes are added with the attribute prefix
+//               and text alongside them at "#content".  Repeated elements,
+//               and those listed in xml_arrays, become lists.
+//    * csv    - Records are keyed by the header row, or csv_columns if there
+//               isn't one, renamed by csv_header_map.  Values are strings.
+//    * tsv    - As csv, separated by tabs.  Values with tabs, newlines or
+//               quotes are quoted as in CSV.
+//    * ndjson - One JSON value per line.
+//    * yaml   - Several documents give a list of them.
+// Vars:
+// response = The response body.
+// format   = The endpoint's response_format.
+// settings = The endpoint's response_decoding options.
+func DecodeResponse(response []byte, format string, settings generic_structs.ResponseDecodingSettings) ([]byte, error) {
+	var decoded interface{}
+	var err error
+	switch format {
+	case "", ResponseFormatJson:
+		return response, nil
+	case ResponseFormatXml:
+		decoded, err = decodeXmlResponse(response, settings)
+	case ResponseFormatCsv:
+		decoded, err = decodeCsvResponse(response, ',', settings)
+	case ResponseFormatTsv:
+		decoded, err = decodeCsvResponse(response, '\t', settings)
+	case ResponseFormatNdjson:
+		return decodeNdjsonResponse(response)
+	case ResponseFormatYaml:
+		decoded, err = decodeYamlResponse(response)
+	default:
+		err = ValidateResponseFormat(format)
+	}
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	return json.Marshal(decoded)
+}
+
+type xmlElement struct {
+	path   string
+	fields map[string]interface{}
+	// The namespace of each field, so fields from different namespaces with
+	//    the same local name can be caught.
+	spaces map[string]string
+	text   strings.Builder
+}
+
+// Records the namespace of a field, returning an error if the field already
+//    came from another.
+func (e *xmlElement) setSpace(key string, space string) error {
+	if existing, ok := e.spaces[key]; ok && existing != space {
+		return fmt.Errorf("%q is in both namespace %q and %q under %q - they can't be told apart", key, existing, space, e.path)
+	}
+	e.spaces[key] = space
+
+	return nil
+}
+
+func decodeXmlResponse(response []byte, settings generic_structs.ResponseDecodingSettings) (interface{}, error) {
+	attributePrefix := settings.XmlAttributePrefix
+	if attributePrefix == "" {
+		attributePrefix = "-"
+	}
+	arrays := make(map[string]bool, len(settings.XmlArrays))
+	for _, path := range settings.XmlArrays {
+		arrays[path] = true
+	}
+
+	root := &xmlElement{fields: make(map[string]interface{}), spaces: make(map[string]string)}
+	stack := []*xmlElement{root}
+	decoder := xml.NewDecoder(bytes.NewReader(response))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		current := stack[len(stack)-1]
+		switch typedToken := token.(type) {
+		case xml.StartElement:
+			path := typedToken.Name.Local
+			if current != root {
+				path = current.path + "." + path
+			}
+			element := &xmlElement{path: path, fields: make(map[string]interface{}), spaces: make(map[string]string)}
+			for _, attr := range typedToken.Attr {
+				// Namespace declarations aren't data.
+				if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+					continue
+				}
+				key := attributePrefix + attr.Name.Local
+				if err := element.setSpace(key, attr.Name.Space); err != nil {
+					return nil, err
+				}
+				element.fields[key] = attr.Value
+			}
+			stack = append(stack, element)
+		case xml.CharData:
+			current.text.Write(typedToken)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+			name := typedToken.Name.Local
+			if err := parent.setSpace(name, typedToken.Name.Space); err != nil {
+				return nil, err
+			}
+
+			var value interface{}
+			text := strings.TrimSpace(current.text.String())
+			if len(current.fields) == 0 {
+				value = text
+			} else {
+				if text != "" {
+					current.fields[xmlContentKey] = text
+				}
+				value = current.fields
+			}
+
+			// Element values are never lists themselves, so a list here
+			//    holds repeated elements.
+			if existing, ok := parent.fields[name]; ok {
+				if existingList, ok := existing.([]interface{}); ok {
+					parent.fields[name] = append(existingList, value)
+				} else {
+					parent.fields[name] = []interface{}{existing, value}
+				}
+			} else if arrays[current.path] {
+				parent.fields[name] = []interface{}{value}
+			} else {
+				parent.fields[name] = value
+			}
+		}
+	}
+	if len(stack) > 1 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return root.fields, nil
+}
+
+func decodeCsvResponse(response []byte, separator rune, settings generic_structs.ResponseDecodingSettings) (interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(response, []byte("\xef\xbb\xbf"))))
+	reader.Comma = separator
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := settings.CsvColumns
+	if columns == nil && len(rows) > 0 {
+		columns, rows = rows[0], rows[1:]
+	}
+	records := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		record := make(map[string]interface{}, len(columns))
+		for i, value := range row {
+			// Values past the last column are dropped.
+			if i >= len(columns) || columns[i] == "" {
+				continue
+			}
+			column := columns[i]
+			if renamed, ok := settings.CsvHeaderMap[column]; ok {
+				column = renamed
+			}
+			record[column] = value
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// Lines are kept as they are, so numbers aren't rounded.
+func decodeNdjsonResponse(response []byte) ([]byte, error) {
+	decoded := bytes.Buffer{}
+	decoded.WriteString("[")
+	reader := bufio.NewReader(bytes.NewReader(response))
+	count := 0
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return []byte(nil), err
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if !json.Valid(trimmed) {
+				return []byte(nil), fmt.Errorf("line %d is not valid JSON", lineNumber)
+			}
+			if count > 0 {
+				decoded.WriteString(",")
+			}
+			decoded.Write(trimmed)
+			count++
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	decoded.WriteString("]")
+
+	return decoded.Bytes(), nil
+}
+
+func decodeYamlResponse(response []byte) (interface{}, error) {
+	documents := []interface{}{}
+	decoder := yaml.NewDecoder(bytes.NewReader(response))
+	for {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, yamlToJsonValue(document))
+	}
+
+	if len(documents) == 1 {
+		return documents[0], nil
+	}
+	return documents, nil
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	cases := []struct {
+		name     string
+		format   string
+		settings generic_structs.ResponseDecodingSettings
+		response string
+		expected string
+	}{
+		{"json", "", generic_structs.ResponseDecodingSettings{}, `{"a": 1}`, `{"a": 1}`},
+		{
+			"xml", ResponseFormatXml, generic_structs.ResponseDecodingSettings{XmlArrays: []string{"result.buckets.bucket"}},
+			`<?xml version="1.0"?>
+			<s3:result xmlns:s3="http://s3.amazonaws.com/doc/" xmlns="http://example.com/">
+				<s3:buckets><s3:bucket id="1">a &amp; b</s3:bucket></s3:buckets>
+				<item>1</item><item><![CDATA[<2>]]></item>
+				<empty/>
+			</s3:result>`,
+			`{"result": {"buckets": {"bucket": [{"-id": "1", "#content": "a & b"}]}, "item": ["1", "<2>"], "empty": ""}}`,
+		},
+		{
+			"xml attribute prefix", ResponseFormatXml, generic_structs.ResponseDecodingSettings{XmlAttributePrefix: "@"},
+			`<a b="c"><d>e</d></a>`, `{"a": {"@b": "c", "d": "e"}}`,
+		},
+		{
+			"csv", ResponseFormatCsv, generic_structs.ResponseDecodingSettings{CsvHeaderMap: map[string]string{"Account ID": "account_id"}},
+			"\xef\xbb\xbfAccount ID,name\n1,\"a, b\"\n2,c,extra\n3\n",
+			`[{"account_id": "1", "name": "a, b"}, {"account_id": "2", "name": "c"}, {"account_id": "3"}]`,
+		},
+		{
+			"tsv without header", ResponseFormatTsv, generic_structs.ResponseDecodingSettings{CsvColumns: []string{"id", "", "name"}},
+			"1\tx\t\"a\tb\nc\"\n2\ty\t\"\"\"d\"\"\"\n", `[{"id": "1", "name": "a\tb\nc"}, {"id": "2", "name": "\"d\""}]`,
+		},
+		{"ndjson", ResponseFormatNdjson, generic_structs.ResponseDecodingSettings{}, "{\"id\": 12345678901234567890}\n\n[1]\n\"x\"", `[{"id": 12345678901234567890}, [1], "x"]`},
+		{"empty ndjson", ResponseFormatNdjson, generic_structs.ResponseDecodingSettings{}, "", `[]`},
+		{"yaml", ResponseFormatYaml, generic_structs.ResponseDecodingSettings{}, "items:\n  - id: 1\n    2: two\n", `{"items": [{"id": 1, "2": "two"}]}`},
+		{"yaml documents", ResponseFormatYaml, generic_structs.ResponseDecodingSettings{}, "a: 1\n---\nb: [x]\n", `[{"a": 1}, {"b": ["x"]}]`},
+	}
+
+	for _, c := range cases {
+		decoded, err := DecodeResponse([]byte(c.response), c.format, c.settings)
+		if err != nil {
+			t.Errorf("%s: expect no error, got %v", c.name, err)
+			continue
+		}
+		var expected, actual interface{}
+		if err := json.Unmarshal([]byte(c.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(decoded, &actual); err != nil {
+			t.Errorf("%s: expect valid JSON, got %s", c.name, decoded)
+			continue
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("%s: expect %s, got %s", c.name, c.expected, decoded)
+		}
+	}
+
+	// Numbers in NDJSON lines aren't rounded.
+	if decoded, _ := DecodeResponse([]byte(`{"id": 12345678901234567890}`), ResponseFormatNdjson, generic_structs.ResponseDecodingSettings{}); string(decoded) != `[{"id": 12345678901234567890}]` {
+		t.Errorf("expect the line as is, got %s", decoded)
+	}
+}
+
+func TestDecodeResponseErrors(t *testing.T) {
+	cases := []struct {
+		format   string
+		response string
+	}{
+		{ResponseFormatXml, `<a><b></a>`},
+		{ResponseFormatXml, `<a>`},
+		{ResponseFormatXml, `<a xmlns:x="urn:x" xmlns:y="urn:y"><x:b>1</x:b><y:b>2</y:b></a>`},
+		{ResponseFormatXml, `<a xmlns:x="urn:x" xmlns:y="urn:y" x:b="1" y:b="2"/>`},
+		{ResponseFormatCsv, "a,b\n\"1,2\n"},
+		{ResponseFormatTsv, "a\tb\n\"1\t2\n"},
+		{ResponseFormatNdjson, "{\"a\": 1}\n{\"a\": \n"},
+		{ResponseFormatYaml, "a: [1"},
+		{"protobuf", ""},
+	}
+
+	for _, c := range cases {
+		if _, err := DecodeResponse([]byte(c.response), c.format, generic_structs.ResponseDecodingSettings{}); err == nil {
+			t.Errorf("%s: expect an error decoding %q", c.format, c.response)
+		}
+	}
+	if err := ValidateResponseFormat("protobuf"); err == nil {
+		t.Error("expect an error for an unknown format")
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// How much of a failed response's body is kept.
+const maxFailureResponseLength = 1024
+
+// The output of a pull, with the data returned kept apart from the errors.
+type PullResult struct {
+	// Records from every endpoint, at their desired base keys.
+	Data map[string]interface{} `json:"data"`
+	// Errors the APIs reported, from the current error keys, plus any
+	//    partial_results and merge_conflicts.
+	Errors map[string]interface{} `json:"errors"`
+	// Requests that returned no data.
+	Failures []RequestFailure `json:"failures"`
+}
+
+// A request that failed - a non-2xx response, a transport error or a response
+//    that couldn't be parsed.
+type RequestFailure struct {
+	ApiCallName string `json:"api_call_name"`
+	// Without its querystring, which may hold credentials added by the auth
+	//    function.
+	Url        string `json:"url,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error"`
+	// The start of the response body, if there was one.
+	Response string `json:"response,omitempty"`
+}
+
+// Records a failed request in its call's key set, for the post process to
+//    report - see RequestFailures.
+// Vars:
+// keySet     = The key set of the call the request was for.
+// requestUrl = URL requested.
+// statusCode = Status returned, if any.
+// response   = Body returned, if any.
+// err        = Error running the request, if any.
+func AddRequestFailure(keySet map[string]string, requestUrl string, statusCode int, response []byte, err error) {
+	count, _ := strconv.Atoi(keySet["failure_count"])
+	prefix := "failure_" + strconv.Itoa(count) + "_"
+	keySet["failure_count"] = strconv.Itoa(count + 1)
+
+	if redactedUrl := RedactUrl(requestUrl); redactedUrl != "" {
+		keySet[prefix+"url"] = redactedUrl
+	}
+	if err != nil {
+		keySet[prefix+"error"] = err.Error()
+		return
+	}
+	keySet[prefix+"status"] = strconv.Itoa(statusCode)
+	keySet[prefix+"error"] = fmt.Sprintf("Expected response status 2xx, got %d", statusCode)
+	if len(response) > maxFailureResponseLength {
+		response = response[:maxFailureResponseLength]
+	}
+	keySet[prefix+"response"] = string(response)
+}
+
+// Returns a request URL without its querystring or user info, which may hold
+//    credentials added by the auth function, or "" if it can't be parsed.
+// Vars:
+// requestUrl = URL requested.
+func RedactUrl(requestUrl string) string {
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return ""
+	}
+	parsedUrl.RawQuery = ""
+	parsedUrl.User = nil
+
+	return parsedUrl.String()
+}
+
+// Returns the failed requests recorded in the key sets by AddRequestFailure.
+// Vars:
+// jsonKeys = The key sets of every call.
+func RequestFailures(jsonKeys []map[string]string) []RequestFailure {
+	failures := []RequestFailure{}
+	for _, keys := range jsonKeys {
+		count, _ := strconv.Atoi(keys["failure_count"])
+		for i := 0; i < count; i++ {
+			prefix := "failure_" + strconv.Itoa(i) + "_"
+			statusCode, _ := strconv.Atoi(keys[prefix+"status"])
+			failures = append(failures, RequestFailure{
+				ApiCallName: keys["api_call_name"],
+				Url:         keys[prefix+"url"],
+				StatusCode:  statusCode,
+				Error:       keys[prefix+"error"],
+				Response:    keys[prefix+"response"],
+			})
+		}
+	}
+
+	return failures
+}
+
+// Returns the result as JSON.
+func (r PullResult) Json() []byte {
+	resultJson, err := json.Marshal(r)
+	if err != nil {
+		LogError("PullResult.Json", "Unable to Marshal result JSON", err)
+		return []byte(nil)
+	}
+
+	return resultJson
+}
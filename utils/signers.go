@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/SREnity/epico/signers"
+	"github.com/SREnity/epico/signers/azure_shared_key"
+	"github.com/SREnity/epico/signers/cloud_hmac"
+	"github.com/SREnity/epico/signers/gcp_jwt"
+	"github.com/SREnity/epico/signers/http_signatures"
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// Returns the time requests are signed at by the signer based auth helpers.
+//    Replaced in tests so signatures can be checked against fixed vectors.
+var requestSignTime = time.Now
+
+// Signs the request with the signer, returning an empty ApiRequest (and
+//    logging why) if it can't be signed.
+func signApiRequest(authName string, apiRequest generic_structs.ApiRequest, signer signers.Signer) generic_structs.ApiRequest {
+	if apiRequest.FullRequest == nil {
+		LogError(authName, "No request to sign", nil)
+		return generic_structs.ApiRequest{}
+	}
+	if err := signer.Sign(apiRequest.FullRequest, requestSignTime()); err != nil {
+		LogError(authName, "Unable to sign request", err)
+		return generic_structs.ApiRequest{}
+	}
+
+	return apiRequest
+}
+
+// Auth function for Alibaba Cloud APIs signed with ACS3-HMAC-SHA256.  The
+//    x-acs-action and x-acs-version headers should be set in the endpoint's
+//    header params.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Alibaba params in the order of:
+//              [0] => AccessKey ID
+//              [1] => AccessKey secret
+//              [2] => STS security token (optional)
+func AlibabaAcs3Auth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 2 {
+		LogError("AlibabaAcs3Auth", "An AccessKey ID and secret are required", nil)
+		return generic_structs.ApiRequest{}
+	}
+
+	return signApiRequest("AlibabaAcs3Auth", apiRequest, cloud_hmac.Acs3Signer{
+		AccessKeyId:     authParams[0],
+		AccessKeySecret: authParams[1],
+		SecurityToken:   authParam(authParams, 2),
+	})
+}
+
+// Auth function for Tencent Cloud API 3.0 requests signed with
+//    TC3-HMAC-SHA256.  The X-TC-Action, X-TC-Version and X-TC-Region headers
+//    should be set in the endpoint's header params.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Tencent params in the order of:
+//              [0] => SecretId
+//              [1] => SecretKey
+//              [2] => session token (optional)
+//              [3] => service, e.g. cvm (optional - taken from the host)
+func TencentTc3Auth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 2 {
+		LogError("TencentTc3Auth", "A SecretId and SecretKey are required", nil)
+		return generic_structs.ApiRequest{}
+	}
+
+	return signApiRequest("TencentTc3Auth", apiRequest, cloud_hmac.Tc3Signer{
+		SecretId:  authParams[0],
+		SecretKey: authParams[1],
+		Token:     authParam(authParams, 2),
+		Service:   authParam(authParams, 3),
+	})
+}
+
+// Auth function for Azure Storage (Blob, Queue and File) Shared Key
+//    authorization.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Azure params in the order of:
+//              [0] => storage account name
+//              [1] => base64 account key
+func AzureSharedKeyAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 2 {
+		LogError("AzureSharedKeyAuth", "An account name and key are required", nil)
+		return generic_structs.ApiRequest{}
+	}
+
+	return signApiRequest("AzureSharedKeyAuth", apiRequest, azure_shared_key.Signer{
+		AccountName: authParams[0],
+		AccountKey:  authParams[1],
+	})
+}
+
+// Auth function for Google APIs using a JWT self-signed with a service
+//    account key as the bearer token, without an OAuth2 token exchange.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Google params in the order of:
+//              [0] => service account JSON key, or the path to the key file
+//              [1] => audience (optional - defaults to the request's host)
+//              [2] => space separated scopes (optional - used in place of
+//                     the audience)
+func GcpJwtAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 1 {
+		LogError("GcpJwtAuth", "A service account key is required", nil)
+		return generic_structs.ApiRequest{}
+	}
+
+	keyJson := []byte(authParams[0])
+	if !strings.HasPrefix(strings.TrimSpace(authParams[0]), "{") {
+		var err error
+		if keyJson, err = ioutil.ReadFile(authParams[0]); err != nil {
+			LogError("GcpJwtAuth", "Unable to read service account key file", err)
+			return generic_structs.ApiRequest{}
+		}
+	}
+	key, err := gcp_jwt.ParseServiceAccountKey(keyJson)
+	if err != nil {
+		LogError("GcpJwtAuth", "Invalid service account key", err)
+		return generic_structs.ApiRequest{}
+	}
+
+	return signApiRequest("GcpJwtAuth", apiRequest, gcp_jwt.Signer{
+		Key:      key,
+		Audience: authParam(authParams, 1),
+		Scopes:   authParam(authParams, 2),
+	})
+}
+
+// Auth function for draft-cavage HTTP Signatures.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Signature params in the order of:
+//              [0] => key ID
+//              [1] => algorithm - hmac-sha256, rsa-sha256, ecdsa-sha256 or
+//                     hs2019
+//              [2] => shared secret or PEM private key
+//              [3] => space separated headers to sign (optional - defaults
+//                     to "(request-target) date")
+//              [4] => Authorization to send the signature in that header
+//                     instead of Signature (optional)
+func HttpSignatureAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 3 {
+		LogError("HttpSignatureAuth", "A key ID, algorithm and key are required", nil)
+		return generic_structs.ApiRequest{}
+	}
+	key, err := http_signatures.ParseKey(authParams[1], authParams[2])
+	if err != nil {
+		LogError("HttpSignatureAuth", "Invalid signing key", err)
+		return generic_structs.ApiRequest{}
+	}
+
+	return signApiRequest("HttpSignatureAuth", apiRequest, http_signatures.CavageSigner{
+		KeyId:      authParams[0],
+		Algorithm:  authParams[1],
+		Key:        key,
+		Headers:    strings.Fields(authParam(authParams, 3)),
+		HeaderName: authParam(authParams, 4),
+	})
+}
+
+// Auth function for RFC 9421 HTTP Message Signatures.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = Signature params in the order of:
+//              [0] => key ID
+//              [1] => algorithm - hmac-sha256, rsa-v1_5-sha256,
+//                     rsa-pss-sha512, ecdsa-p256-sha256 or ed25519
+//              [2] => shared secret or PEM private key
+//              [3] => space separated components to sign (optional -
+//                     defaults to "@method @authority @path", plus
+//                     content-digest for requests with a body)
+//              [4] => signature label (optional - defaults to sig1)
+func HttpMessageSignatureAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	if len(authParams) < 3 {
+		LogError("HttpMessageSignatureAuth", "A key ID, algorithm and key are required", nil)
+		return generic_structs.ApiRequest{}
+	}
+	key, err := http_signatures.ParseKey(authParams[1], authParams[2])
+	if err != nil {
+		LogError("HttpMessageSignatureAuth", "Invalid signing key", err)
+		return generic_structs.ApiRequest{}
+	}
+
+	return signApiRequest("HttpMessageSignatureAuth", apiRequest, http_signatures.MessageSigner{
+		KeyId:      authParams[0],
+		Algorithm:  authParams[1],
+		Key:        key,
+		Components: strings.Fields(authParam(authParams, 3)),
+		Label:      authParam(authParams, 4),
+	})
+}
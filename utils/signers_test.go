@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+func withRequestSignTime(t time.Time) func() {
+	requestSignTime = func() time.Time { return t }
+	return func() { requestSignTime = time.Now }
+}
+
+// The Tencent Cloud documentation example, run through the YAML auth_type.
+func TestTencentTc3Auth(t *testing.T) {
+	defer withRequestSignTime(time.Unix(1551113065, 0))()
+
+	req, _ := http.NewRequest("POST", "https://cvm.tencentcloudapi.com/", strings.NewReader(`{"Limit": 1, "Filters": [{"Values": ["\u672a\u547d\u540d"], "Name": "instance-name"}]}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	apiRequest := AuthFunctions["tencent_tc3"](generic_structs.ApiRequest{FullRequest: req}, []string{"AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******", "Gu5t9xGARNpq86cd98joQYCN3*******"})
+	if apiRequest.FullRequest == nil {
+		t.Fatalf("expect request to be signed")
+	}
+	if a := apiRequest.FullRequest.Header.Get("Authorization"); !strings.HasSuffix(a, "Signature=2230eefd229f582d8b1b891af7107b91597240707d778ab3738f756258d7652c") {
+		t.Errorf("expect the documented signature, got %v", a)
+	}
+}
+
+// RFC 9421 B.2.5, run through the YAML auth_type.
+func TestHttpMessageSignatureAuth(t *testing.T) {
+	defer withRequestSignTime(time.Unix(1618884473, 0))()
+
+	apiRequest := newTestApiRequest(t, "https://example.com/foo?param=Value&Pet=dog")
+	apiRequest.FullRequest.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	apiRequest.FullRequest.Header.Set("Content-Type", "application/json")
+	apiRequest = AuthFunctions["http_message_signature"](apiRequest, []string{
+		"test-shared-secret",
+		"hmac-sha256",
+		"base64:uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==",
+		"date @authority content-type",
+		"sig-b25",
+	})
+	if apiRequest.FullRequest == nil {
+		t.Fatalf("expect request to be signed")
+	}
+	if e, a := "sig-b25=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:", apiRequest.FullRequest.Header.Get("Signature"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+func TestSignerAuthFailures(t *testing.T) {
+	cases := map[string][]string{
+		"alibaba_acs3":           {"id-only"},
+		"azure_shared_key":       {"account", "not base64!"},
+		"gcp_jwt":                {`{"type": "service_account"}`},
+		"http_signature":         {"key", "rsa-sha256", "not a PEM key"},
+		"http_message_signature": {"key", "ed25519"},
+	}
+	for authType, authParams := range cases {
+		if apiRequest := AuthFunctions[authType](newTestApiRequest(t, "https://example.com/"), authParams); apiRequest.FullRequest != nil {
+			t.Errorf("expect %v to fail with %v", authType, authParams)
+		}
+	}
+}
+
+func TestAzureSharedKeyAuth(t *testing.T) {
+	apiRequest := AuthFunctions["azure_shared_key"](newTestApiRequest(t, "https://myaccount.blob.core.windows.net/container?restype=container&comp=list"), []string{
+		"myaccount", base64.StdEncoding.EncodeToString([]byte("key")),
+	})
+	if apiRequest.FullRequest == nil {
+		t.Fatalf("expect request to be signed")
+	}
+	if a := apiRequest.FullRequest.Header.Get("Authorization"); !strings.HasPrefix(a, "SharedKey myaccount:") {
+		t.Errorf("expect a SharedKey authorization, got %v", a)
+	}
+}
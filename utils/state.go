@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// StateStore persists small pieces of state between runs - rotated refresh
+//    tokens, checkpoints and the like.
+type StateStore interface {
+	GetState(key string) (string, bool)
+	SetState(key string, value string) error
+}
+
+var stateStore struct {
+	sync.Mutex
+	store StateStore
+}
+
+// SetStateStore replaces the state store used by Epico.
+func SetStateStore(store StateStore) {
+	stateStore.Lock()
+	defer stateStore.Unlock()
+	stateStore.store = store
+}
+
+// GetStateStore returns the state store in use.  Unless one has been set,
+//    this is a FileStateStore at EPICO_STATE_FILE, or an in-memory store
+//    (lost when the process exits) if that isn't set.
+func GetStateStore() StateStore {
+	stateStore.Lock()
+	defer stateStore.Unlock()
+
+	if stateStore.store == nil {
+		if path := os.Getenv("EPICO_STATE_FILE"); path != "" {
+			stateStore.store = &FileStateStore{Path: path}
+		} else {
+			LogWarning("GetStateStore", "EPICO_STATE_FILE is not set - state will not persist between runs")
+			stateStore.store = NewMemoryStateStore()
+		}
+	}
+
+	return stateStore.store
+}
+
+// MemoryStateStore keeps state for the life of the process only.
+type MemoryStateStore struct {
+	mutex sync.Mutex
+	state map[string]string
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{state: make(map[string]string)}
+}
+
+func (m *MemoryStateStore) GetState(key string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value, ok := m.state[key]
+	return value, ok
+}
+
+func (m *MemoryStateStore) SetState(key string, value string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.state[key] = value
+	return nil
+}
+
+// FileStateStore keeps state in a JSON file readable only by the current user
+//    since it may hold credentials.  Writes replace the file atomically.
+type FileStateStore struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+func (f *FileStateStore) GetState(key string) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	state, err := f.load()
+	if err != nil {
+		LogWarning("FileStateStore", "Unable to read state file", err)
+		return "", false
+	}
+	value, ok := state[key]
+	return value, ok
+}
+
+func (f *FileStateStore) SetState(key string, value string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	state, err := f.load()
+	if err != nil {
+		return err
+	}
+	state[key] = value
+
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(f.Path), ".epico-state")
+	if err != nil {
+		return err
+	}
+	if _, err := tempFile.Write(contents); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), f.Path)
+}
+
+func (f *FileStateStore) load() (map[string]string, error) {
+	state := make(map[string]string)
+	contents, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(contents) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A value found at a sub-endpoint's key in its parent's responses.
+type SubEndpointKey struct {
+	Value interface{}
+	// The object the value was found in, which sub-endpoints see as parent.
+	Item map[string]interface{}
+	// The parent response item the value came from, and the value's place
+	//    among the values found in it.
+	Root  map[string]interface{}
+	Index int
+}
+
+// Returns the values at a sub-endpoint's dot separated key in its parent's
+//    response items, in order, with the objects they were found in.  Lists
+//    along the way are stepped into, and a list at the key gives one value
+//    per entry.  Nil and blank values are skipped.
+// Vars:
+// items = The parent's response items, from every page.
+// key   = Dot separated key the sub-endpoint is run for.
+func SubEndpointKeys(items []map[string]interface{}, key string) []SubEndpointKey {
+	kSet := strings.Split(key, ".")
+	keys := []SubEndpointKey{}
+
+	for _, item := range items {
+		index := 0
+		var walk func(structure interface{}, count int)
+		walk = func(structure interface{}, count int) {
+			switch typedStructure := structure.(type) {
+			case []interface{}:
+				for _, v := range typedStructure {
+					walk(v, count)
+				}
+			case map[string]interface{}:
+				value := typedStructure[kSet[count]]
+				if value == nil || value == "" {
+					return
+				}
+				if count < len(kSet)-1 {
+					walk(value, count+1)
+					return
+				}
+				values, ok := value.([]interface{})
+				if !ok {
+					values = []interface{}{value}
+				}
+				for _, v := range values {
+					keys = append(keys, SubEndpointKey{Value: v, Item: typedStructure, Root: item, Index: index})
+					index++
+				}
+			}
+		}
+		walk(item, 0)
+	}
+
+	return keys
+}
+
+// Returns the value at a dot separated path in the parent response item the
+//    key came from (for endpoint_key_names), matched to the key by position
+//    when the path holds more than one value.
+// Vars:
+// path = Dot separated path within the parent response item.
+func (k SubEndpointKey) Field(path string) (interface{}, bool) {
+	values := ParseJsonSubStructure(strings.Split(path, "."), 0, k.Root)
+	if k.Index >= len(values) {
+		return nil, false
+	}
+
+	return values[k.Index], true
+}
+
+// Returns the context a sub-endpoint run for a key has of its parents - the
+//    object the key was found in, followed by the parent's own context (if
+//    it is a sub-endpoint too).  The objects are kept apart rather than
+//    nested, so a field named parent can't be mistaken for the grandparent.
+// Vars:
+// parentContext = The parent endpoint's own context.
+func (k SubEndpointKey) ParentContext(parentContext []map[string]interface{}) []map[string]interface{} {
+	return append([]map[string]interface{}{k.Item}, parentContext...)
+}
+
+// Returns a sub-endpoint key or parent value as a string for templates.
+//    Numbers are written out in full (no exponents), and objects and lists
+//    as JSON so they can be read with the json template function.
+// Vars:
+// value = The decoded JSON value.
+func SubEndpointKeyString(value interface{}) string {
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(typedValue), 'f', -1, 32)
+	case int:
+		return strconv.Itoa(typedValue)
+	case int64:
+		return strconv.FormatInt(typedValue, 10)
+	case bool:
+		return strconv.FormatBool(typedValue)
+	case nil:
+		return ""
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(encoded)
+}
+
+// Adds a sub-endpoint's parent context to its template vars, so fields can be
+//    referenced as {{parent.field}}, {{parent.field.nested}} and
+//    {{parent.list.0}}, and those further up as {{grandparent.field}},
+//    {{great_grandparent.field}} and so on.  Objects and lists are also set
+//    whole as JSON.
+// Vars:
+// vars          = Template vars to add to.
+// parentContext = The sub-endpoint's parent context.
+func AddParentTemplateVars(vars map[string]string, parentContext []map[string]interface{}) {
+	for i, context := range parentContext {
+		addFlattenedTemplateVars(vars, parentTemplateVar(i), context)
+	}
+}
+
+// Returns the template var a sub-endpoint's parent context is set as - parent,
+//    then grandparent, great_grandparent, great_great_grandparent, etc.
+// Vars:
+// generation = 0 for the parent, 1 for the grandparent and so on.
+func parentTemplateVar(generation int) string {
+	if generation == 0 {
+		return "parent"
+	}
+
+	return strings.Repeat("great_", generation-1) + "grandparent"
+}
+
+func addFlattenedTemplateVars(vars map[string]string, prefix string, value interface{}) {
+	vars[prefix] = SubEndpointKeyString(value)
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for k, v := range typedValue {
+			addFlattenedTemplateVars(vars, prefix+"."+k, v)
+		}
+	case []interface{}:
+		for i, v := range typedValue {
+			addFlattenedTemplateVars(vars, prefix+"."+strconv.Itoa(i), v)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubEndpointKeys(t *testing.T) {
+	items := []map[string]interface{}{
+		{"id": 1.0, "name": "one", "teams": []interface{}{
+			map[string]interface{}{"slug": "a", "tags": []interface{}{"x", "y"}},
+			map[string]interface{}{"slug": ""},
+			map[string]interface{}{"slug": "b"},
+		}},
+		{"id": true, "name": "two"},
+		{"name": "no id"},
+	}
+
+	keys := SubEndpointKeys(items, "teams.slug")
+	if len(keys) != 2 || keys[0].Value != "a" || keys[1].Value != "b" || keys[1].Index != 1 {
+		t.Fatalf("expect keys a and b, got %v", keys)
+	}
+	if e := items[0]["teams"].([]interface{})[2]; !reflect.DeepEqual(e, keys[1].Item) {
+		t.Errorf("expect item %v, got %v", e, keys[1].Item)
+	}
+	if v, ok := keys[1].Field("name"); ok {
+		t.Errorf("expect no second name in the item, got %v", v)
+	}
+
+	keys = SubEndpointKeys(items, "id")
+	if len(keys) != 2 || keys[0].Value != 1.0 || keys[1].Value != true {
+		t.Fatalf("expect keys 1 and true, got %v", keys)
+	}
+	if v, ok := keys[1].Field("name"); !ok || v != "two" {
+		t.Errorf("expect two, got %v", v)
+	}
+
+	// A list at the key gives a value per entry.
+	keys = SubEndpointKeys(items, "teams.tags")
+	if len(keys) != 2 || keys[0].Value != "x" || keys[1].Value != "y" {
+		t.Errorf("expect keys x and y, got %v", keys)
+	}
+}
+
+func TestSubEndpointKeyString(t *testing.T) {
+	cases := map[string]interface{}{
+		"abc":                 "abc",
+		"12345678901":         12345678901.0,
+		"1.5":                 1.5,
+		"7":                   7,
+		"true":                true,
+		"":                    nil,
+		`{"a":[1,"b"]}`:       map[string]interface{}{"a": []interface{}{1.0, "b"}},
+		`[{"id":1},{"id":2}]`: []interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2}},
+	}
+	for e, value := range cases {
+		if a := SubEndpointKeyString(value); e != a {
+			t.Errorf("expect %v for %#v, got %v", e, value, a)
+		}
+	}
+}
+
+func TestAddParentTemplateVars(t *testing.T) {
+	org := SubEndpointKey{Item: map[string]interface{}{"name": "acme", "id": 42.0}}
+	team := SubEndpointKey{Item: map[string]interface{}{
+		"slug":   "ops",
+		"meta":   map[string]interface{}{"private": false, "tags": []interface{}{"a"}},
+		"parent": map[string]interface{}{"name": "engineering"},
+	}}
+	parentContext := team.ParentContext(org.ParentContext(nil))
+
+	vars := map[string]string{}
+	AddParentTemplateVars(vars, parentContext)
+	expected := map[string]string{
+		"parent.slug":         "ops",
+		"parent.meta.private": "false",
+		"parent.meta.tags.0":  "a",
+		"parent.meta.tags":    `["a"]`,
+		"parent.parent.name":  "engineering",
+		"grandparent.name":    "acme",
+		"grandparent.id":      "42",
+	}
+	for k, e := range expected {
+		if vars[k] != e {
+			t.Errorf("expect %v for %v, got %v", e, k, vars[k])
+		}
+	}
+	if _, ok := vars["great_grandparent"]; ok {
+		t.Errorf("expect no context past the top endpoint")
+	}
+
+	if a, err := RenderTemplate("/orgs/{{grandparent.id}}/teams/{{parent.slug}}", vars, time.Now()); err != nil || a != "/orgs/42/teams/ops" {
+		t.Errorf("expect /orgs/42/teams/ops, got %v (%v)", a, err)
+	}
+	for generation, e := range []string{"parent", "grandparent", "great_grandparent", "great_great_grandparent"} {
+		if a := parentTemplateVar(generation); e != a {
+			t.Errorf("expect %v, got %v", e, a)
+		}
+	}
+}
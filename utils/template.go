@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Matches the {{var}} shorthand for a var lookup, optionally piped to
+//    functions ({{var | raw}}).  Dots are allowed in names so nested vars such
+//    as {{parent.id}} can be written the same way.
+var legacyVarRegex = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_.\-]*)\s*(\|[^}]*)?}}`)
+
+// Matches {{time:expression}} and {{time:expression|format}} tokens.
+var timeTokenRegex = regexp.MustCompile(`{{time:([^}|]*)(?:\|([^}]*))?}}`)
+
+// Matches {{provider:...}} tokens resolved outside of templates, such as
+//    {{secret:env:TOKEN}}, which are left in place.
+var passThroughTokenRegex = regexp.MustCompile(`{{[A-Za-z_]+:[^}]*}}`)
+
+// Words that are template keywords rather than var names.
+var templateKeywords = map[string]bool{
+	"break": true, "continue": true, "else": true, "end": true,
+	"false": true, "nil": true, "true": true,
+}
+
+// Renders a config value (endpoint URL, param, key, etc.) as a Go
+//    text/template with the vars given.  Vars are referenced as {{var}},
+//    {{.var}} or {{var "var" "default"}}, and an undefined var is an error.
+//    {{time:expression|format}} tokens are rendered as times (see
+//    ParseTimeExpression and FormatTime) and other {{provider:...}} tokens,
+//    e.g. secrets, are left for later.  Functions available:
+//    var NAME [DEFAULT]     - the var, or the default if it isn't set.
+//    default DEFAULT VALUE  - the default if the value is empty.
+//    env NAME [DEFAULT]     - an environment variable, or the default if it
+//                             isn't set.
+//    time EXPR [FORMAT]     - a time expression, e.g. {{time "-24h" "rfc3339"}}.
+//    date FORMAT VALUE      - reformats a time, e.g. {{var "since" | date "epoch_ms"}}.
+//    queryescape, pathescape, raw, base64, base64decode, json PATH, upper,
+//    lower, trim.
+// Vars:
+// value = The string to render.
+// vars  = Vars available to the template.
+// now   = The current time.
+func RenderTemplate(value string, vars map[string]string, now time.Time) (string, error) {
+	return renderTemplate(value, vars, now, false)
+}
+
+// Renders a URL template as RenderTemplate does, escaping each value for
+//    where it appears in the URL - path escaped in the path (so an ID
+//    containing / stays one segment) and query escaped in the querystring or
+//    fragment.  Values in the scheme and host, or at the very start (e.g. a
+//    {{base_url}}), are left as is, as are values passed through raw,
+//    pathescape, queryescape or urlquery.
+// Vars:
+// value = The URL to render.
+// vars  = Vars available to the template.
+// now   = The current time.
+func RenderUrlTemplate(value string, vars map[string]string, now time.Time) (string, error) {
+	return renderTemplate(value, vars, now, true)
+}
+
+func renderTemplate(value string, vars map[string]string, now time.Time, escapeUrl bool) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	funcs := templateFuncs(vars, now)
+	tmpl, err := template.New("").Option("missingkey=error").Funcs(funcs).Parse(translateLegacyTokens(value, funcs))
+	if err != nil {
+		return "", err
+	}
+	if escapeUrl {
+		escapeUrlActions(tmpl.Tree, tmpl.Tree.Root, "")
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, vars); err != nil {
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
+
+// Rewrites the {{var}}, {{time:...}} and {{provider:...}} shorthands into
+//    template actions.
+func translateLegacyTokens(value string, funcs template.FuncMap) string {
+	value = timeTokenRegex.ReplaceAllStringFunc(value, func(token string) string {
+		matches := timeTokenRegex.FindStringSubmatch(token)
+		return "{{time " + strconv.Quote(matches[1]) + " " + strconv.Quote(matches[2]) + "}}"
+	})
+	value = passThroughTokenRegex.ReplaceAllStringFunc(value, func(token string) string {
+		return "{{raw " + strconv.Quote(token) + "}}"
+	})
+
+	return legacyVarRegex.ReplaceAllStringFunc(value, func(token string) string {
+		matches := legacyVarRegex.FindStringSubmatch(token)
+		if _, ok := funcs[matches[1]]; ok || templateKeywords[matches[1]] {
+			return token
+		}
+		return "{{var " + strconv.Quote(matches[1]) + " " + matches[2] + "}}"
+	})
+}
+
+// Functions that mark a value as already escaped for a URL.
+var urlEscapeFuncs = map[string]bool{"raw": true, "pathescape": true, "queryescape": true, "urlquery": true}
+
+// The escaping function for each part of a URL - see UrlContext.
+var urlContextEscapeFuncs = map[string]string{"path": "pathescape", "query": "queryescape", "fragment": "queryescape"}
+
+// Appends the escaping function for each action's place in the URL to its
+//    pipeline, the way html/template does.  The place is judged from the
+//    template's own text before the action.  Returns the text seen so far.
+func escapeUrlActions(tree *parse.Tree, node parse.Node, prefix string) string {
+	switch typedNode := node.(type) {
+	case *parse.ListNode:
+		if typedNode == nil {
+			return prefix
+		}
+		for _, n := range typedNode.Nodes {
+			prefix = escapeUrlActions(tree, n, prefix)
+		}
+	case *parse.TextNode:
+		prefix += string(typedNode.Text)
+	case *parse.IfNode:
+		prefix = escapeUrlActions(tree, typedNode.ElseList, escapeUrlActions(tree, typedNode.List, prefix))
+	case *parse.RangeNode:
+		prefix = escapeUrlActions(tree, typedNode.ElseList, escapeUrlActions(tree, typedNode.List, prefix))
+	case *parse.WithNode:
+		prefix = escapeUrlActions(tree, typedNode.ElseList, escapeUrlActions(tree, typedNode.List, prefix))
+	case *parse.ActionNode:
+		pipe := typedNode.Pipe
+		if len(pipe.Decl) > 0 || len(pipe.Cmds) == 0 {
+			break
+		}
+		if identifier, ok := pipe.Cmds[len(pipe.Cmds)-1].Args[0].(*parse.IdentifierNode); ok && urlEscapeFuncs[identifier.Ident] {
+			break
+		}
+		if escapeFunc := urlContextEscapeFuncs[UrlContext(prefix)]; escapeFunc != "" {
+			pipe.Cmds = append(pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      pipe.Pos,
+				Args:     []parse.Node{parse.NewIdentifier(escapeFunc).SetTree(tree).SetPos(pipe.Pos)},
+			})
+		}
+	}
+
+	return prefix
+}
+
+// Returns the part of a URL that text following the prefix given falls in -
+//    host (the scheme, host and port, or nothing yet), path, query or fragment.
+// Vars:
+// prefix = The URL up to the point in question.
+func UrlContext(prefix string) string {
+	if strings.Contains(prefix, "#") {
+		return "fragment"
+	}
+	if strings.Contains(prefix, "?") {
+		return "query"
+	}
+	if i := strings.Index(prefix, "://"); i >= 0 {
+		prefix = prefix[i+3:]
+	}
+	if !strings.Contains(prefix, "/") {
+		return "host"
+	}
+
+	return "path"
+}
+
+// Escapes a value for the part of a URL it is being placed in - see
+//    UrlContext.
+// Vars:
+// prefix = The URL up to where the value is being placed.
+// value  = The value to escape.
+func EscapeUrlValue(prefix string, value string) string {
+	switch UrlContext(prefix) {
+	case "path":
+		return url.PathEscape(value)
+	case "query", "fragment":
+		return url.QueryEscape(value)
+	}
+
+	return value
+}
+
+func templateFuncs(vars map[string]string, now time.Time) template.FuncMap {
+	return template.FuncMap{
+		"var": func(name string, defaultValue ...string) (string, error) {
+			if value, ok := vars[name]; ok {
+				return value, nil
+			}
+			if len(defaultValue) > 0 {
+				return defaultValue[0], nil
+			}
+			return "", fmt.Errorf("undefined var %q", name)
+		},
+		"default": func(defaultValue string, value string) string {
+			if value == "" {
+				return defaultValue
+			}
+			return value
+		},
+		"env": func(name string, defaultValue ...string) (string, error) {
+			if value, ok := os.LookupEnv(name); ok {
+				return value, nil
+			}
+			if len(defaultValue) > 0 {
+				return defaultValue[0], nil
+			}
+			return "", fmt.Errorf("undefined environment variable %q", name)
+		},
+		"time": func(expression string, format ...string) (string, error) {
+			t, err := ParseTimeExpression(expression, now, vars)
+			if err != nil {
+				return "", err
+			}
+			return FormatTime(t, strings.Join(format, "")), nil
+		},
+		"date": func(format string, value string) (string, error) {
+			t, err := ParseTimeExpression(value, now, nil)
+			if err != nil {
+				return "", err
+			}
+			return FormatTime(t, format), nil
+		},
+		"queryescape": func(value interface{}) string {
+			return url.QueryEscape(fmt.Sprint(value))
+		},
+		"pathescape": func(value interface{}) string {
+			return url.PathEscape(fmt.Sprint(value))
+		},
+		"raw": func(value interface{}) string {
+			return fmt.Sprint(value)
+		},
+		"base64": func(value string) string {
+			return base64.StdEncoding.EncodeToString([]byte(value))
+		},
+		"base64decode": func(value string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			return string(decoded), err
+		},
+		"json":  jsonTemplateValue,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"trim":  strings.TrimSpace,
+	}
+}
+
+// Returns the value at a dot separated path (list indexes allowed) in a JSON
+//    document.  Strings are returned as is and anything else as JSON.
+func jsonTemplateValue(path string, document string) (string, error) {
+	var structure interface{}
+	if err := json.Unmarshal([]byte(document), &structure); err != nil {
+		return "", err
+	}
+
+	if path != "" {
+		for _, k := range strings.Split(path, ".") {
+			switch typedStructure := structure.(type) {
+			case map[string]interface{}:
+				value, ok := typedStructure[k]
+				if !ok {
+					return "", fmt.Errorf("no %q in JSON path %q", k, path)
+				}
+				structure = value
+			case []interface{}:
+				i, err := strconv.Atoi(k)
+				if err != nil || i < 0 || i >= len(typedStructure) {
+					return "", fmt.Errorf("invalid index %q in JSON path %q", k, path)
+				}
+				structure = typedStructure[i]
+			default:
+				return "", errors.New("JSON path " + strconv.Quote(path) + " goes past a value")
+			}
+		}
+	}
+
+	if value, ok := structure.(string); ok {
+		return value, nil
+	}
+	value, err := json.Marshal(structure)
+
+	return string(value), err
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	vars := map[string]string{
+		"org":          "SREnity",
+		"repo":         "a b/c",
+		"window_start": "2024-03-01T00:00:00Z",
+		"account":      `{"id": 123, "tags": [{"name": "prod"}]}`,
+		"parent.id":    "p1",
+		"empty":        "",
+	}
+	os.Setenv("EPICO_TEMPLATE_TEST", "from-env")
+	defer os.Unsetenv("EPICO_TEMPLATE_TEST")
+
+	cases := map[string]string{
+		"https://api.github.com/orgs/{{org}}/repos":                  "https://api.github.com/orgs/SREnity/repos",
+		"{{ org }}-{{.org}}-{{var \"org\"}}":                         "SREnity-SREnity-SREnity",
+		"{{var \"missing\" \"fallback\"}}":                           "fallback",
+		"{{var \"empty\" | default \"none\"}}":                       "none",
+		"{{env \"EPICO_TEMPLATE_TEST\"}}":                            "from-env",
+		"{{env \"EPICO_TEMPLATE_UNSET\" \"x\"}}":                     "x",
+		"/repos/{{pathescape .repo}}?q={{queryescape .repo}}":        "/repos/a%20b%2Fc?q=a+b%2Fc",
+		"{{base64 \"user:pass\"}}":                                   "dXNlcjpwYXNz",
+		"{{base64decode \"dXNlcjpwYXNz\"}}":                          "user:pass",
+		"{{json \"id\" .account}}/{{json \"tags.0.name\" .account}}": "123/prod",
+		"{{upper .org}}{{lower .org}}":                               "SRENITYsrenity",
+		"{{parent.id}}":                                              "p1",
+		"{{if eq .org \"SREnity\"}}yes{{else}}no{{end}}":             "yes",
+		"since={{time:window_start|rfc3339}}&until={{time:now}}":     "since=2024-03-01T00:00:00Z&until=1710072000",
+		"{{time \"-1h\" \"epoch_ms\"}}":                              "1710068400000",
+		"{{.window_start | date \"date\"}}":                          "2024-03-01",
+		"Bearer {{secret:env:TOKEN}}":                                "Bearer {{secret:env:TOKEN}}",
+		"no templates { here }":                                      "no templates { here }",
+	}
+	for value, expected := range cases {
+		if a, err := RenderTemplate(value, vars, now); err != nil || a != expected {
+			t.Errorf("expect %v for %v, got %v (%v)", expected, value, a, err)
+		}
+	}
+
+	for _, value := range []string{
+		"{{missing}}",
+		"{{.missing}}",
+		"{{env \"EPICO_TEMPLATE_UNSET\"}}",
+		"{{time:never}}",
+		"{{json \"nope\" .account}}",
+		"{{base64decode \"%%\"}}",
+		"{{if}}",
+	} {
+		if _, err := RenderTemplate(value, vars, now); err == nil {
+			t.Errorf("expect an error for %v", value)
+		}
+	}
+}
+
+func TestRenderUrlTemplate(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	// A sub-endpoint's endpoint_key as taken from the parent response.
+	vars := map[string]string{
+		"base_url":     "https://api.example.com/v1",
+		"region":       "eu-west-1",
+		"endpoint_key": "team/a b#1?ü",
+		"path":         "x/y",
+	}
+
+	cases := map[string]string{
+		"https://api.example.com/teams/{{endpoint_key}}":           "https://api.example.com/teams/team%2Fa%20b%231%3F%C3%BC",
+		"https://api.example.com/teams?name={{endpoint_key}}&x=1":  "https://api.example.com/teams?name=team%2Fa+b%231%3F%C3%BC&x=1",
+		"https://api.example.com/teams#{{endpoint_key}}":           "https://api.example.com/teams#team%2Fa+b%231%3F%C3%BC",
+		"{{base_url}}/teams/{{.endpoint_key}}":                     "https://api.example.com/v1/teams/team%2Fa%20b%231%3F%C3%BC",
+		"https://ec2.{{region}}.amazonaws.com/":                    "https://ec2.eu-west-1.amazonaws.com/",
+		"https://api.example.com/{{path|raw}}/{{raw .path}}":       "https://api.example.com/x/y/x/y",
+		"https://api.example.com/{{pathescape .path}}?p={{.path}}": "https://api.example.com/x%2Fy?p=x%2Fy",
+		"https://api.example.com/{{if .path}}{{.path}}{{end}}":     "https://api.example.com/x%2Fy",
+		"https://api.example.com/{{$p := .path}}{{$p}}":            "https://api.example.com/x%2Fy",
+		"https://api.example.com/?since={{time:now|rfc3339}}":      "https://api.example.com/?since=2024-03-10T12%3A00%3A00Z",
+	}
+	for value, expected := range cases {
+		if a, err := RenderUrlTemplate(value, vars, now); err != nil || a != expected {
+			t.Errorf("expect %v for %v, got %v (%v)", expected, value, a, err)
+		}
+	}
+
+	// Values outside of URLs aren't escaped.
+	if a, _ := RenderTemplate("{{endpoint_key}}", vars, now); a != vars["endpoint_key"] {
+		t.Errorf("expect an unescaped value, got %v", a)
+	}
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// Matches a named time base, e.g. window_start, with an optional duration
+//    offset such as -1s.
+var timeBaseRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)([+-].+)?$`)
+
+// Layouts absolute times may be given in.  Times without a zone are UTC.
+var absoluteTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// Resolves a time expression as used in {{time:...}} tokens and time_window
+//    settings.  Expressions can be:
+//    now                  - the current time.
+//    -24h                 - a Go duration relative to now.
+//    window_start         - a var holding an absolute time, optionally with a
+//                           duration offset (window_start-1s).
+//    2024-01-02T15:04:05Z - an absolute time, also 2024-01-02T15:04:05 or
+//                           2024-01-02 in UTC.
+//    1704153600           - epoch seconds.
+// Vars:
+// expression = The time expression.
+// now        = The current time.
+// vars       = Vars that named bases are looked up in.
+func ParseTimeExpression(expression string, now time.Time, vars map[string]string) (time.Time, error) {
+	expression = strings.TrimSpace(expression)
+	if expression == "" {
+		return time.Time{}, errors.New("empty time expression")
+	}
+
+	if epoch, err := strconv.ParseInt(expression, 10, 64); err == nil && expression != "0" {
+		return time.Unix(epoch, 0).UTC(), nil
+	}
+	if duration, err := time.ParseDuration(expression); err == nil {
+		return now.Add(duration), nil
+	}
+	for _, layout := range absoluteTimeLayouts {
+		if t, err := time.Parse(layout, expression); err == nil {
+			return t, nil
+		}
+	}
+
+	matches := timeBaseRegex.FindStringSubmatch(expression)
+	if matches == nil {
+		return time.Time{}, fmt.Errorf("invalid time expression %q", expression)
+	}
+	base := now
+	if matches[1] != "now" {
+		value, ok := vars[matches[1]]
+		if !ok {
+			return time.Time{}, fmt.Errorf("unknown time %q in %q", matches[1], expression)
+		}
+		var err error
+		if base, err = ParseTimeExpression(value, now, nil); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time in %v: %v", matches[1], err)
+		}
+	}
+	if matches[2] != "" {
+		offset, err := time.ParseDuration(matches[2])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid offset in %q: %v", expression, err)
+		}
+		base = base.Add(offset)
+	}
+
+	return base, nil
+}
+
+// Formats a time for a {{time:...}} token.  Formats are epoch (seconds, the
+//    default), epoch_ms, rfc3339, rfc3339nano, date (2006-01-02), or any Go
+//    time layout.  The named formats are in UTC.
+// Vars:
+// t      = The time to format.
+// format = The format to use.
+func FormatTime(t time.Time, format string) string {
+	switch format {
+	case "", "epoch":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "epoch_ms":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	case "rfc3339":
+		return t.UTC().Format(time.RFC3339)
+	case "rfc3339nano":
+		return t.UTC().Format(time.RFC3339Nano)
+	case "date":
+		return t.UTC().Format("2006-01-02")
+	}
+
+	return t.Format(format)
+}
+
+// TimeWindow is one window of a time_window endpoint.
+type TimeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Splits an endpoint's time_window into successive windows, the last one
+//    ending at the window end.  Paging a checkpointed endpoint resumes from
+//    the checkpoint if it is later than the configured start.
+// Vars:
+// settings   = The endpoint's time_window settings.
+// now        = The current time.
+// vars       = Vars that named bases are looked up in.
+// checkpoint = End of the last completed window (RFC3339), or blank.
+func TimeWindows(settings generic_structs.TimeWindowSettings, now time.Time, vars map[string]string, checkpoint string) ([]TimeWindow, error) {
+	if settings.Start == "" || settings.Size == "" {
+		return nil, errors.New("a time_window needs a start and size")
+	}
+	start, err := ParseTimeExpression(settings.Start, now, vars)
+	if err != nil {
+		return nil, err
+	}
+	end := now
+	if settings.End != "" {
+		if end, err = ParseTimeExpression(settings.End, now, vars); err != nil {
+			return nil, err
+		}
+	}
+	size, err := time.ParseDuration(settings.Size)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("invalid time_window size %q", settings.Size)
+	}
+
+	if checkpoint != "" {
+		checkpointTime, err := time.Parse(time.RFC3339Nano, checkpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time_window checkpoint %q", checkpoint)
+		}
+		if checkpointTime.After(start) {
+			start = checkpointTime
+		}
+	}
+
+	windows := []TimeWindow{}
+	for windowStart := start; windowStart.Before(end); windowStart = windowStart.Add(size) {
+		windowEnd := windowStart.Add(size)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+		windows = append(windows, TimeWindow{Start: windowStart, End: windowEnd})
+	}
+
+	return windows, nil
+}
+
+// Returns the state store key a time_window endpoint's checkpoint is kept
+//    under.  Record tags (e.g. the fan out account and region) and vars_data
+//    values are part of the key so each combination is checkpointed
+//    separately.
+// Vars:
+// rootName     = Name of the API root.
+// endpointName = Name of the endpoint.
+// tags         = Tags added to the endpoint's records.
+// varsData     = The root's expanded vars_data values.
+func TimeWindowStateKey(rootName string, endpointName string, tags map[string]string, varsData map[string]string) string {
+	key := "time_window:" + rootName + ":" + endpointName
+	for _, values := range []struct {
+		prefix string
+		values map[string]string
+	}{{"", tags}, {"vars_data.", varsData}} {
+		names := make([]string, 0, len(values.values))
+		for k := range values.values {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			key += ":" + values.prefix + k + "=" + values.values[k]
+		}
+	}
+
+	return key
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+func TestParseTimeExpression(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	vars := map[string]string{"window_start": "2024-03-01T00:00:00Z", "bad": "yesterday"}
+
+	cases := map[string]time.Time{
+		"now":                       now,
+		"-24h":                      now.Add(-24 * time.Hour),
+		"1704153600":                time.Unix(1704153600, 0).UTC(),
+		"2024-01-02T15:04:05+01:00": time.Date(2024, 1, 2, 14, 4, 5, 0, time.UTC),
+		"2024-01-02T15:04:05":       time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+		"2024-01-02":                time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		"window_start":              time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"window_start-1s":           time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC),
+		"now+1h":                    now.Add(time.Hour),
+	}
+	for expression, expected := range cases {
+		if a, err := ParseTimeExpression(expression, now, vars); err != nil || !a.Equal(expected) {
+			t.Errorf("expect %v for %v, got %v (%v)", expected, expression, a, err)
+		}
+	}
+
+	for _, expression := range []string{"", "missing", "bad", "window_start-1x", "2024-13-01"} {
+		if _, err := ParseTimeExpression(expression, now, vars); err == nil {
+			t.Errorf("expect an error for %q", expression)
+		}
+	}
+}
+
+func TestTimeWindows(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := func(d int, h int) time.Time { return time.Date(2024, 3, d, h, 0, 0, 0, time.UTC) }
+	settings := generic_structs.TimeWindowSettings{Start: "2024-03-08", Size: "24h"}
+
+	windows, err := TimeWindows(settings, now, nil, "")
+	expected := []TimeWindow{{day(8, 0), day(9, 0)}, {day(9, 0), day(10, 0)}, {day(10, 0), day(10, 12)}}
+	if err != nil || !reflect.DeepEqual(expected, windows) {
+		t.Errorf("expect\n%v\ngot\n%v (%v)", expected, windows, err)
+	}
+
+	// A later checkpoint resumes from where the last run finished.
+	windows, err = TimeWindows(settings, now, nil, "2024-03-09T06:00:00Z")
+	expected = []TimeWindow{{day(9, 6), day(10, 6)}, {day(10, 6), day(10, 12)}}
+	if err != nil || !reflect.DeepEqual(expected, windows) {
+		t.Errorf("expect\n%v\ngot\n%v (%v)", expected, windows, err)
+	}
+
+	// Nothing is left once the checkpoint reaches the end.
+	settings.End = "-12h"
+	if windows, err := TimeWindows(settings, now, nil, "2024-03-10T00:00:00Z"); err != nil || len(windows) != 0 {
+		t.Errorf("expect no windows, got %v (%v)", windows, err)
+	}
+
+	for _, settings := range []generic_structs.TimeWindowSettings{
+		{Start: "-24h"},
+		{Start: "-24h", Size: "0s"},
+		{Start: "soon", Size: "1h"},
+	} {
+		if _, err := TimeWindows(settings, now, nil, ""); err == nil {
+			t.Errorf("expect an error for %v", settings)
+		}
+	}
+	if _, err := TimeWindows(generic_structs.TimeWindowSettings{Start: "-24h", Size: "1h"}, now, nil, "garbage"); err == nil {
+		t.Errorf("expect an error for an invalid checkpoint")
+	}
+}
+
+func TestTimeWindowStateKey(t *testing.T) {
+	key := TimeWindowStateKey("aws", "events", map[string]string{"region": "us-east-1", "account": "123"}, nil)
+	if e := "time_window:aws:events:account=123:region=us-east-1"; e != key {
+		t.Errorf("expect %v, got %v", e, key)
+	}
+
+	// Each vars_data combination has its own checkpoint.
+	orgA := TimeWindowStateKey("github", "events", nil, map[string]string{"org": "a", "repo": "x"})
+	orgB := TimeWindowStateKey("github", "events", nil, map[string]string{"org": "b", "repo": "x"})
+	if e := "time_window:github:events:vars_data.org=a:vars_data.repo=x"; e != orgA {
+		t.Errorf("expect %v, got %v", e, orgA)
+	}
+	if orgA == orgB {
+		t.Errorf("expect different keys for different vars_data, got %v", orgA)
+	}
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	settings := generic_structs.TimeWindowSettings{Start: "-24h", Size: "12h", Checkpoint: true}
+	checkpoints := map[string]string{orgA: now.Format(time.RFC3339)}
+	if windows, _ := TimeWindows(settings, now, nil, checkpoints[orgA]); len(windows) != 0 {
+		t.Errorf("expect org a to be up to date, got %v", windows)
+	}
+	if windows, _ := TimeWindows(settings, now, nil, checkpoints[orgB]); len(windows) != 2 {
+		t.Errorf("expect org b to fetch every window, got %v", windows)
+	}
+}
@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// DefaultTokenTtl is how long a session token is reused when neither the
+//    auth response (expires_in) nor the config (auth_token_ttl) give us an
+//    expiry.
+var DefaultTokenTtl = 10 * time.Minute
+
+// Tokens are refreshed once they are within this fraction of their lifetime
+//    of expiring, capped at maxTokenRefreshWindow, so we don't hand out a
+//    token that expires mid-request.
+const tokenRefreshFraction = 10
+const maxTokenRefreshWindow = 5 * time.Minute
+
+type cachedToken struct {
+	value   string
+	refresh time.Time
+}
+
+var tokenCache = struct {
+	sync.Mutex
+	tokens map[string]cachedToken
+}{tokens: make(map[string]cachedToken)}
+
+// OAuth2 token sources are kept for the life of the process so each request
+//    reuses the token (the sources refresh it themselves when it expires).
+var tokenSourceCache = struct {
+	sync.Mutex
+	sources map[string]*cachedTokenSource
+}{sources: make(map[string]*cachedTokenSource)}
+
+// A token source being built, or built.  Sources are built outside of the
+//    cache lock, as some (e.g. the device code flow) wait on a user, and
+//    callers wanting the same one wait for ready.
+type cachedTokenSource struct {
+	ready  chan struct{}
+	source oauth2.TokenSource
+	err    error
+}
+
+// Builds the cache key for a token from the auth endpoint and credentials
+//    used to fetch it.  Hashed so secrets don't sit around in map keys.
+func tokenCacheKey(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns a cached token if we have one that isn't due for refresh.
+func getCachedToken(key string) (string, bool) {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+
+	token, ok := tokenCache.tokens[key]
+	if !ok || !time.Now().Before(token.refresh) {
+		return "", false
+	}
+
+	return token.value, true
+}
+
+// Caches a token for the given time to live.
+func setCachedToken(key string, value string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	window := ttl / tokenRefreshFraction
+	if window > maxTokenRefreshWindow {
+		window = maxTokenRefreshWindow
+	}
+	now := time.Now()
+
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	tokenCache.tokens[key] = cachedToken{
+		value:   value,
+		refresh: now.Add(ttl - window),
+	}
+}
+
+// InvalidateCachedToken drops a cached token so the next auth call fetches a
+//    new one.  Used when the API rejects a token we thought was valid.
+func InvalidateCachedToken(key string) {
+	tokenCache.Lock()
+	delete(tokenCache.tokens, key)
+	tokenCache.Unlock()
+
+	tokenSourceCache.Lock()
+	delete(tokenSourceCache.sources, key)
+	tokenSourceCache.Unlock()
+}
+
+// Wraps the request's client with an OAuth2 transport backed by a cached
+//    token source.  Token fetches go through the wrapped client's transport
+//    so proxy/TLS settings apply to them too.  Returns the client and the
+//    cache key used for it.
+// Vars:
+// apiRequest = The ApiRequest whose client is being wrapped.
+// authName   = Name of the auth helper, to keep cache keys distinct.
+// authParams = Params identifying the credentials.
+// newSource  = Builds the token source on a cache miss.
+func oauth2Client(apiRequest generic_structs.ApiRequest, authName string, authParams []string, newSource func(context.Context) (oauth2.TokenSource, error)) (*http.Client, string, error) {
+	base := httpClientFor(apiRequest)
+	cacheKey := tokenCacheKey(append([]string{authName, fmt.Sprintf("%p", base)}, authParams...)...)
+
+	tokenSourceCache.Lock()
+	cached, ok := tokenSourceCache.sources[cacheKey]
+	if !ok {
+		cached = &cachedTokenSource{ready: make(chan struct{})}
+		tokenSourceCache.sources[cacheKey] = cached
+	}
+	tokenSourceCache.Unlock()
+
+	if ok {
+		<-cached.ready
+	} else {
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, base)
+		cached.source, cached.err = newSource(ctx)
+		if cached.err != nil {
+			// Not kept, so the next call tries again.
+			tokenSourceCache.Lock()
+			if tokenSourceCache.sources[cacheKey] == cached {
+				delete(tokenSourceCache.sources, cacheKey)
+			}
+			tokenSourceCache.Unlock()
+		}
+		close(cached.ready)
+	}
+	if cached.err != nil {
+		return nil, "", cached.err
+	}
+
+	return &http.Client{
+		Transport: &oauth2.Transport{Source: cached.source, Base: base.Transport},
+		Timeout:   base.Timeout,
+	}, cacheKey, nil
+}
+
+// Works out how long a freshly fetched token should live.  expires_in from
+//    the auth response wins, then the configured auth_token_ttl, then
+//    DefaultTokenTtl.
+// Vars:
+// apiRequest = The ApiRequest being authenticated.
+// expiresIn  = The raw expires_in value from the auth response, if any.
+func tokenTtl(apiRequest generic_structs.ApiRequest, expiresIn interface{}) time.Duration {
+	switch v := expiresIn.(type) {
+	case float64:
+		if v > 0 {
+			return time.Duration(v) * time.Second
+		}
+	case string:
+		if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	if apiRequest.Settings.AuthTokenTtl != "" {
+		ttl, err := time.ParseDuration(apiRequest.Settings.AuthTokenTtl)
+		if err == nil {
+			return ttl
+		}
+		LogWarning("tokenTtl", "Invalid auth_token_ttl, using default", err)
+	}
+
+	return DefaultTokenTtl
+}
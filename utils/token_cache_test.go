@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+func newTestApiRequest(t *testing.T, endpoint string) generic_structs.ApiRequest {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return generic_structs.ApiRequest{Endpoint: endpoint, FullRequest: req}
+}
+
+func TestSessionTokenAuthCachesToken(t *testing.T) {
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&logins, 1)
+		w.Write([]byte(`{"data": {"token": "abc", "expires_in": 3600}}`))
+	}))
+	defer server.Close()
+
+	authParams := []string{"data.token", "Authorization", "token ", server.URL, "user", "pass"}
+	for i := 0; i < 3; i++ {
+		apiRequest := SessionTokenAuth(newTestApiRequest(t, "http://example.com/items"), authParams)
+		if e, a := "token abc", apiRequest.FullRequest.Header.Get("Authorization"); e != a {
+			t.Errorf("expect %v, got %v", e, a)
+		}
+		if apiRequest.AuthCacheKey == "" {
+			t.Errorf("expect cache key to be set")
+		}
+	}
+	if e, a := int32(1), atomic.LoadInt32(&logins); e != a {
+		t.Errorf("expect %v logins, got %v", e, a)
+	}
+	if e, a := "token ", authParams[2]; e != a {
+		t.Errorf("expect auth params to be left untouched, got %v", a)
+	}
+
+	apiRequest := SessionTokenAuth(newTestApiRequest(t, "http://example.com/items"), authParams)
+	InvalidateCachedToken(apiRequest.AuthCacheKey)
+	SessionTokenAuth(newTestApiRequest(t, "http://example.com/items"), authParams)
+	if e, a := int32(2), atomic.LoadInt32(&logins); e != a {
+		t.Errorf("expect %v logins after invalidation, got %v", e, a)
+	}
+}
+
+func TestTokenCacheRefreshesBeforeExpiry(t *testing.T) {
+	key := tokenCacheKey("test", "refresh")
+	setCachedToken(key, "short", 50*time.Millisecond)
+	if _, ok := getCachedToken(key); !ok {
+		t.Fatalf("expect token to be cached")
+	}
+
+	// The refresh window is 10% of the lifetime, so the token should be
+	//    dropped before it actually expires.
+	time.Sleep(46 * time.Millisecond)
+	if _, ok := getCachedToken(key); ok {
+		t.Errorf("expect token to be due for refresh")
+	}
+}
+
+func TestTokenTtl(t *testing.T) {
+	apiRequest := generic_structs.ApiRequest{}
+	if e, a := 30*time.Second, tokenTtl(apiRequest, float64(30)); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := 45*time.Second, tokenTtl(apiRequest, "45"); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := DefaultTokenTtl, tokenTtl(apiRequest, nil); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	apiRequest.Settings.AuthTokenTtl = "2m"
+	if e, a := 2*time.Minute, tokenTtl(apiRequest, nil); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+func TestOauth2ClientBuildsSourcesOutsideLock(t *testing.T) {
+	apiRequest := newTestApiRequest(t, "http://example.com")
+	release := make(chan struct{})
+	var builds int32
+	slowSource := func(ctx context.Context) (oauth2.TokenSource, error) {
+		atomic.AddInt32(&builds, 1)
+		<-release
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "slow"}), nil
+	}
+
+	done := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, cacheKey, _ := oauth2Client(apiRequest, "slow", nil, slowSource)
+			done <- cacheKey
+		}()
+	}
+
+	// Another source isn't held up by the one waiting.
+	finished := make(chan struct{})
+	go func() {
+		oauth2Client(apiRequest, "fast", nil, func(ctx context.Context) (oauth2.TokenSource, error) {
+			return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "fast"}), nil
+		})
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect other sources to be built while one is waiting")
+	}
+
+	close(release)
+	cacheKey := <-done
+	if e, a := cacheKey, <-done; e != a {
+		t.Errorf("expect the same source, got %v and %v", e, a)
+	}
+	if e, a := int32(1), atomic.LoadInt32(&builds); e != a {
+		t.Errorf("expect the source to be built %v time, got %v", e, a)
+	}
+	InvalidateCachedToken(cacheKey)
+}
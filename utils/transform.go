@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	generic_structs "github.com/SREnity/epico/structs"
+	"github.com/jmespath/go-jmespath"
+)
+
+// Types records' fields can be coerced to.
+var transformCoerceTypes = map[string]bool{"string": true, "int": true, "float": true, "bool": true}
+
+// Checks an endpoint's transform steps each set one operation, with valid
+//    coercion types and expressions.
+// Vars:
+// steps = The endpoint's transform steps.
+func ValidateTransform(steps []generic_structs.TransformStep) error {
+	for i, step := range steps {
+		operations := 0
+		for _, set := range []bool{len(step.Select) > 0, len(step.Rename) > 0, step.Flatten != "", len(step.Coerce) > 0, len(step.Compute) > 0, step.Filter != "", step.Jmespath != ""} {
+			if set {
+				operations++
+			}
+		}
+		if operations != 1 {
+			return fmt.Errorf("transform step %d must set exactly one operation, has %d", i+1, operations)
+		}
+
+		for field, fieldType := range step.Coerce {
+			if !transformCoerceTypes[fieldType] {
+				return fmt.Errorf("transform step %d: invalid type %q for %s", i+1, fieldType, field)
+			}
+		}
+		expressions := []string{step.Filter, step.Jmespath}
+		for _, expression := range step.Compute {
+			expressions = append(expressions, expression)
+		}
+		for _, expression := range expressions {
+			if expression == "" {
+				continue
+			}
+			if _, err := jmespath.Compile(expression); err != nil {
+				return fmt.Errorf("transform step %d: invalid expression %q: %v", i+1, expression, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Applies an endpoint's transform steps to the records found at a base key,
+//    in order, returning the new records.  Steps that work on fields leave
+//    records that aren't objects as they are.  A value that can't be coerced
+//    or an expression that fails is logged and the value left unchanged.
+// Vars:
+// records = The records at the base key.
+// steps   = The endpoint's transform steps.
+func TransformRecords(records []interface{}, steps []generic_structs.TransformStep) []interface{} {
+	for _, step := range steps {
+		if step.Jmespath != "" {
+			result, err := jmespath.Search(step.Jmespath, records)
+			if err != nil {
+				LogWarning("TransformRecords", "Error applying "+strconv.Quote(step.Jmespath), err)
+				continue
+			}
+			if resultList, ok := result.([]interface{}); ok {
+				records = resultList
+			} else if result != nil {
+				records = []interface{}{result}
+			} else {
+				records = []interface{}{}
+			}
+			continue
+		}
+
+		transformedRecords := make([]interface{}, 0, len(records))
+		for _, record := range records {
+			if step.Filter != "" {
+				if keep, err := jmespath.Search(step.Filter, record); err != nil {
+					LogWarning("TransformRecords", "Error applying "+strconv.Quote(step.Filter), err)
+				} else if !jmespathTruthy(keep) {
+					continue
+				}
+				transformedRecords = append(transformedRecords, record)
+				continue
+			}
+
+			recordMap, ok := record.(map[string]interface{})
+			if !ok {
+				transformedRecords = append(transformedRecords, record)
+				continue
+			}
+			transformedRecords = append(transformedRecords, transformRecord(recordMap, step))
+		}
+		records = transformedRecords
+	}
+
+	return records
+}
+
+func transformRecord(record map[string]interface{}, step generic_structs.TransformStep) map[string]interface{} {
+	switch {
+	case len(step.Select) > 0:
+		selected := make(map[string]interface{})
+		for _, field := range step.Select {
+			if value, ok := transformField(record, field); ok {
+				setTransformField(selected, field, value)
+			}
+		}
+		return selected
+	case len(step.Rename) > 0:
+		// Values are all taken before any are set, so fields can be swapped.
+		values := make(map[string]interface{})
+		for from := range step.Rename {
+			if value, ok := transformField(record, from); ok {
+				values[from] = value
+				deleteTransformField(record, from)
+			}
+		}
+		for from, value := range values {
+			setTransformField(record, step.Rename[from], value)
+		}
+	case step.Flatten != "":
+		flattened := make(map[string]interface{})
+		flattenTransformRecord(flattened, "", record, step.Flatten)
+		return flattened
+	case len(step.Coerce) > 0:
+		for field, fieldType := range step.Coerce {
+			value, ok := transformField(record, field)
+			if !ok || value == nil {
+				continue
+			}
+			coerced, err := coerceTransformValue(value, fieldType)
+			if err != nil {
+				LogWarning("TransformRecords", "Unable to coerce "+field+" to "+fieldType, err)
+				continue
+			}
+			setTransformField(record, field, coerced)
+		}
+	case len(step.Compute) > 0:
+		// Fields are all computed from the record as it was before the step,
+		//    so they don't depend on each other.
+		values := make(map[string]interface{}, len(step.Compute))
+		for field, expression := range step.Compute {
+			value, err := jmespath.Search(expression, record)
+			if err != nil {
+				LogWarning("TransformRecords", "Error computing "+field, err)
+				continue
+			}
+			values[field] = value
+		}
+		for field, value := range values {
+			setTransformField(record, field, value)
+		}
+	}
+
+	return record
+}
+
+func transformField(record map[string]interface{}, field string) (interface{}, bool) {
+	var value interface{} = record
+	for _, k := range strings.Split(field, ".") {
+		valueMap, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = valueMap[k]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func setTransformField(record map[string]interface{}, field string, value interface{}) {
+	kSet := strings.Split(field, ".")
+	for _, k := range kSet[:len(kSet)-1] {
+		next, ok := record[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			record[k] = next
+		}
+		record = next
+	}
+	record[kSet[len(kSet)-1]] = value
+}
+
+func deleteTransformField(record map[string]interface{}, field string) {
+	kSet := strings.Split(field, ".")
+	for _, k := range kSet[:len(kSet)-1] {
+		next, ok := record[k].(map[string]interface{})
+		if !ok {
+			return
+		}
+		record = next
+	}
+	delete(record, kSet[len(kSet)-1])
+}
+
+func flattenTransformRecord(flattened map[string]interface{}, prefix string, value map[string]interface{}, separator string) {
+	for k, v := range value {
+		if prefix != "" {
+			k = prefix + separator + k
+		}
+		if vMap, ok := v.(map[string]interface{}); ok && len(vMap) > 0 {
+			flattenTransformRecord(flattened, k, vMap, separator)
+		} else {
+			flattened[k] = v
+		}
+	}
+}
+
+// Integers are kept as float64, as JSON decodes them, so later steps can
+//    still compare them - they are written out the same either way.
+func coerceTransformValue(value interface{}, fieldType string) (interface{}, error) {
+	switch fieldType {
+	case "string":
+		return SubEndpointKeyString(value), nil
+	case "int", "float":
+		var number float64
+		switch typedValue := value.(type) {
+		case float64:
+			number = typedValue
+		case bool:
+			if typedValue {
+				number = 1
+			}
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(typedValue), 64)
+			if err != nil {
+				return nil, err
+			}
+			number = parsed
+		default:
+			return nil, fmt.Errorf("can't convert %s", reflect.TypeOf(value))
+		}
+		if fieldType == "int" {
+			number = math.Trunc(number)
+		}
+		return number, nil
+	case "bool":
+		switch typedValue := value.(type) {
+		case bool:
+			return typedValue, nil
+		case float64:
+			return typedValue != 0, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(typedValue))
+		}
+		return nil, fmt.Errorf("can't convert %s", reflect.TypeOf(value))
+	}
+
+	return nil, errors.New("unknown type " + fieldType)
+}
+
+// JMESPath's truthiness - false, null and empty strings, lists and objects
+//    are false.
+func jmespathTruthy(value interface{}) bool {
+	switch typedValue := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return typedValue
+	case string:
+		return typedValue != ""
+	case []interface{}:
+		return len(typedValue) > 0
+	case map[string]interface{}:
+		return len(typedValue) > 0
+	}
+
+	return true
+}
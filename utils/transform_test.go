@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	generic_structs "github.com/SREnity/epico/structs"
+	"gopkg.in/yaml.v2"
+)
+
+func TestTransformRecords(t *testing.T) {
+	var steps []generic_structs.TransformStep
+	if err := yaml.Unmarshal([]byte(`
+- select: [ id, name, size, private, owner.login, owner.type ]
+- rename: { name: repo, owner.login: owner.name }
+- coerce: { id: string, size: int, private: bool }
+- compute: { full_name: "join('/', [owner.name, repo])", big: "size > ` + "`100`" + `" }
+- filter: "owner.type == 'Organization'"
+- flatten: _
+- jmespath: "sort_by(@, &repo)"
+`), &steps); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateTransform(steps); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []interface{}
+	json.Unmarshal([]byte(`[
+		{"id": 2, "name": "zeta", "size": "250.7", "private": "true", "owner": {"login": "acme", "type": "Organization"}, "extra": 1},
+		{"id": 1, "name": "alpha", "size": 10, "private": 0, "owner": {"login": "acme", "type": "Organization"}},
+		{"id": 3, "name": "mine", "size": 5, "private": false, "owner": {"login": "me", "type": "User"}},
+		"not a record"
+	]`), &records)
+
+	var expected []interface{}
+	json.Unmarshal([]byte(`[
+		{"id": "1", "repo": "alpha", "size": 10, "private": false, "owner_name": "acme", "owner_type": "Organization", "full_name": "acme/alpha", "big": false},
+		{"id": "2", "repo": "zeta", "size": 250, "private": true, "owner_name": "acme", "owner_type": "Organization", "full_name": "acme/zeta", "big": true}
+	]`), &expected)
+
+	// The string record is dropped by the filter.
+	if a := TransformRecords(records, steps); !reflect.DeepEqual(expected, a) {
+		t.Errorf("expect\n%v\ngot\n%v", expected, a)
+	}
+
+	// Values that can't be coerced are left as they are.
+	coerced := TransformRecords([]interface{}{map[string]interface{}{"size": "big"}}, []generic_structs.TransformStep{{Coerce: map[string]string{"size": "int"}}})
+	if e := []interface{}{map[string]interface{}{"size": "big"}}; !reflect.DeepEqual(e, coerced) {
+		t.Errorf("expect %v, got %v", e, coerced)
+	}
+}
+
+func TestValidateTransform(t *testing.T) {
+	for _, steps := range [][]generic_structs.TransformStep{
+		{{}},
+		{{Select: []string{"id"}, Flatten: "_"}},
+		{{Coerce: map[string]string{"id": "uuid"}}},
+		{{Filter: "state =="}},
+		{{Compute: map[string]string{"x": "join("}}},
+	} {
+		if err := ValidateTransform(steps); err == nil {
+			t.Errorf("expect an error for %+v", steps)
+		}
+	}
+}
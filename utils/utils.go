@@ -21,6 +21,7 @@ import (
 	"golang.org/x/oauth2/jwt"
 
 	generic_structs "github.com/SREnity/epico/structs"
+	"gopkg.in/yaml.v2"
 )
 
 type oneloginRequest struct {
@@ -28,7 +29,8 @@ type oneloginRequest struct {
 }
 
 type oneloginData struct {
-	AccessToken string `json:"access_token"`
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   interface{} `json:"expires_in"`
 }
 
 type oneloginTokens struct {
@@ -77,35 +79,42 @@ func XmlResponseProcess(apiResponse []byte) []byte {
 }
 
 // Used to expand out the shorthand YAMLs with expansion vars into a series of
-//    individual, expanded YAML []byte's for consumption by Epico.
+//    individual, expanded YAML []byte's for consumption by Epico.  The YAML
+//    is parsed as an API root and expanded by ExpandVarsData, so fields
+//    outside of generic_structs.ApiRoot are dropped.
+// Deprecated: Use ExpandVarsData on the parsed API root instead.
 // Args:
 // rawYaml  = raw YAML []byte that will be tranformed into a slice of []bytes
 // varsData = vars data to be expanded
 func PopulateYamlSlice(rawYaml string, varsData map[string][]string) [][]byte {
-	indexes := make([]string, len(varsData))            // These are keys that need to be
-	depth := 0                                          //    expanded
-	varValues := make(map[string]string, len(varsData)) // Actual sub values
-	index := 0
-	for k, _ := range varsData {
-		// Load our keys into the indexes slice and instantiate varValues maps
-		indexes[index] = k
-		index += 1
-		varValues[k] = ""
+	var api generic_structs.ApiRoot
+	if err := yaml.Unmarshal([]byte(rawYaml), &api); err != nil {
+		LogError("PopulateYamlSlice", "Error unmarshaling YAML", err)
+		return [][]byte(nil)
 	}
+	api.VarsData = varsData
 
+	expandedApis, err := ExpandVarsData(api)
+	if err != nil {
+		LogError("PopulateYamlSlice", "Error expanding vars_data", err)
+		return [][]byte(nil)
+	}
 	var returnSlice [][]byte
-	for i, _ := range varsData[indexes[depth]] {
-		// Don't need to append here because returnSlice is being passed and
-		//    builds upon the old slice.
-		returnSlice = populateSliceRecursion(rawYaml, varsData, indexes, depth, i, varValues, returnSlice)
+	for _, expandedApi := range expandedApis {
+		expandedYaml, err := yaml.Marshal(expandedApi)
+		if err != nil {
+			LogError("PopulateYamlSlice", "Error marshaling expanded YAML", err)
+			return [][]byte(nil)
+		}
+		returnSlice = append(returnSlice, expandedYaml)
 	}
 
 	return returnSlice
 }
 
 // This function collapses two map[string]interface{} json representations into
-//    a single one. WARNING (TODO): This does not handle key collisions
-//    gracefully.
+//    a single one.  Keys in both are merged with MergeJsonValues, so errors
+//    sharing a key with returned data are kept alongside it.
 func CollapseJson(returnsList map[string]interface{}, errorsList map[string]interface{}) []byte {
 	finalList := make(map[string]interface{})
 
@@ -113,7 +122,11 @@ func CollapseJson(returnsList map[string]interface{}, errorsList map[string]inte
 		finalList[k] = v
 	}
 	for k, v := range errorsList {
-		finalList[k] = v
+		if current, ok := finalList[k]; ok {
+			finalList[k] = MergeJsonValues(current, v)
+		} else {
+			finalList[k] = v
+		}
 	}
 
 	finalJson, err := json.Marshal(finalList)
@@ -163,6 +176,14 @@ func ParsePostProcessedJson(response generic_structs.ComparableApiRequest, jsonK
 			LogError("ParsePostProcessedJson", "Invalid key count", err)
 			return map[string]interface{}{}, map[string]interface{}{}
 		}
+		var transform []generic_structs.TransformStep
+		if keys["transform"] != "" {
+			if err := json.Unmarshal([]byte(keys["transform"]), &transform); err != nil {
+				LogError("ParsePostProcessedJson", "Invalid transform", err)
+				return map[string]interface{}{}, map[string]interface{}{}
+			}
+		}
+		provenance := RecordProvenance(response, keys)
 		for i := 0; i < length; i++ {
 			currentBaseKeySet := strings.Split(
 				keys["current_base_key_"+strconv.Itoa(i)], ".")
@@ -197,11 +218,17 @@ func ParsePostProcessedJson(response generic_structs.ComparableApiRequest, jsonK
 					parsedSubStructure[i] = unboxedElement
 				}
 			}
-			// Was getting some weird byRef issues when setting the map directly
-			//    equal and passing it as a param.
-			newVar := addJsonKeyStructure(desiredBaseKeySet, 0, parsedStructure,
-				parsedSubStructure, true)
-			parsedStructure = newVar.(map[string]interface{})
+			if len(transform) > 0 {
+				parsedSubStructure = TransformRecords(parsedSubStructure, transform)
+			}
+			// Added after the transform, so it can't be selected away.
+			if provenance != nil {
+				AddRecordProvenance(parsedSubStructure, provenance)
+			}
+			if conflict := MergeJsonRecords(parsedStructure, desiredBaseKeySet,
+				parsedSubStructure, true, keys["merge_strategy"], keys["api_call_name"]); conflict != nil {
+				addMergeConflict(parsedErrorStructure, *conflict)
+			}
 
 			// Run through error keys.
 			// These aren't added explicitly to the key set (aren't always going
@@ -209,11 +236,10 @@ func ParsePostProcessedJson(response generic_structs.ComparableApiRequest, jsonK
 			if _, ok := unparsedStructure[currentErrorKeySet[0]]; ok {
 				parsedSubStructure = ParseJsonSubStructure(currentErrorKeySet, 0,
 					unparsedStructure)
-				// Was getting some weird byRef issues when setting the map
-				//    directly equal and passing it as a param.
-				newVar = addJsonKeyStructure(desiredErrorKeySet, 0, parsedErrorStructure,
-					parsedSubStructure, false)
-				parsedErrorStructure = newVar.(map[string]interface{})
+				if conflict := MergeJsonRecords(parsedErrorStructure, desiredErrorKeySet,
+					parsedSubStructure, false, keys["merge_strategy"], keys["api_call_name"]); conflict != nil {
+					addMergeConflict(parsedErrorStructure, *conflict)
+				}
 			}
 		}
 	}
@@ -469,10 +495,48 @@ func CalculatePagingPeek(response []byte, responseKeys []string, oldPageValue in
 // Vars:
 // apiResponseMap = A map of API requests made and their corresponding responses
 func DefaultJsonPostProcess(apiResponseMap map[generic_structs.ComparableApiRequest][]byte, jsonKeys []map[string]string) []byte {
+	result := DefaultJsonPostProcessResult(apiResponseMap, jsonKeys)
+	if result.Data == nil {
+		return []byte(nil)
+	}
+
+	return CollapseJson(result.Data, result.Errors)
+}
+
+// Compiles the result of a pull as DefaultJsonPostProcess does, but with the
+//    data, the errors and the failed requests kept apart.  Responses that
+//    aren't valid JSON are reported as failures.
+// Vars:
+// apiResponseMap = A map of API requests made and their corresponding responses
+// jsonKeys       = The key sets of every call.
+func DefaultJsonPostProcessResult(apiResponseMap map[generic_structs.ComparableApiRequest][]byte, jsonKeys []map[string]string) PullResult {
 	parsedStructure := make(map[string]interface{})
 	parsedErrorStructure := make(map[string]interface{})
+	failures := RequestFailures(jsonKeys)
+	// Pages of the same call share their keys, which must only be wrapped
+	//    once.
+	wrappedKeys := make(map[string]bool)
 
 	for request, response := range apiResponseMap {
+		if !json.Valid(response) {
+			failure := RequestFailure{Url: request.Url, Error: "Response is not valid JSON"}
+			if failure.Url == "" {
+				failure.Url = RedactUrl(request.Endpoint)
+			}
+			for _, keys := range jsonKeys {
+				if keys["api_call_uuid"] == request.Uuid {
+					failure.ApiCallName = keys["api_call_name"]
+					break
+				}
+			}
+			if len(response) > maxFailureResponseLength {
+				response = response[:maxFailureResponseLength]
+			}
+			failure.Response = string(response)
+			LogError("DefaultJsonPostProcessResult", "["+failure.ApiCallName+"]", failure.Error)
+			failures = append(failures, failure)
+			continue
+		}
 
 		// Catch JSON slices that don't have a map at the root
 		var jsonSlice []interface{}
@@ -486,11 +550,12 @@ func DefaultJsonPostProcess(apiResponseMap map[generic_structs.ComparableApiRequ
 			// Only prepend it if the response is an array, otherwise we're going to
 			// mess up the expected base key from a map response
 			for i, v := range jsonKeys {
-				if v["api_call_uuid"] == request.Uuid {
+				if v["api_call_uuid"] == request.Uuid && !wrappedKeys[request.Uuid] {
+					wrappedKeys[request.Uuid] = true
 					length, err := strconv.Atoi(v["key_count"])
 					if err != nil {
-						LogError("DefaultJsonPostProcess", "Non-integer key_count is invalid", err)
-						return []byte(nil)
+						LogError("DefaultJsonPostProcessResult", "Non-integer key_count is invalid", err)
+						return PullResult{}
 					}
 					for ci := 0; ci < length; ci++ {
 						keyString := "current_base_key_" + strconv.Itoa(ci)
@@ -513,8 +578,21 @@ func DefaultJsonPostProcess(apiResponseMap map[generic_structs.ComparableApiRequ
 		parsedErrorStructure = errorVar
 	}
 
-	returnJson := CollapseJson(parsedStructure, parsedErrorStructure)
-	return returnJson
+	// Report the calls whose paging was cut short by a paging limit.
+	partialResults := []interface{}{}
+	for _, keys := range jsonKeys {
+		if keys["partial_result"] != "" {
+			partialResults = append(partialResults, map[string]interface{}{
+				"api_call_name": keys["api_call_name"],
+				"reason":        keys["partial_result"],
+			})
+		}
+	}
+	if len(partialResults) > 0 {
+		parsedErrorStructure["partial_results"] = partialResults
+	}
+
+	return PullResult{Data: parsedStructure, Errors: parsedErrorStructure, Failures: failures}
 }
 
 // Auth function for basic username/password auth implementations.  Takes a
@@ -609,8 +687,23 @@ func CustomHeaderAndBasicAuth(apiRequest generic_structs.ApiRequest, authParams
 	return apiRequest
 }
 
-// OneloginAuth performs some additional magic that is specific to OneLogin
+// OneloginAuth performs some additional magic that is specific to OneLogin.
+//    Access tokens are cached until they near expiry rather than fetched for
+//    every request.
+// Vars:
+// apiRequest = The ApiRequest to be used.
+// authParams = OneLogin params in the order of:
+//              [0] => client ID
+//              [1] => client secret
+//              [2] => token URL
 func OneloginAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic_structs.ApiRequest {
+	cacheKey := tokenCacheKey(append([]string{"OneloginAuth"}, authParams[:3]...)...)
+	apiRequest.AuthCacheKey = cacheKey
+	if token, ok := getCachedToken(cacheKey); ok {
+		apiRequest.FullRequest.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+		return apiRequest
+	}
+
 	reqDataStruct := &oneloginRequest{"client_credentials"}
 	reqDataBytes, err := json.Marshal(reqDataStruct)
 	if err != nil {
@@ -628,8 +721,7 @@ func OneloginAuth(apiRequest generic_structs.ApiRequest, authParams []string) ge
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("client_id:%s, client_secret:%s", authParams[0], authParams[1]))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClientFor(apiRequest).Do(req)
 	if err != nil {
 		LogError("OneloginAuth", "Failed to perform request", err)
 		return generic_structs.ApiRequest{}
@@ -637,7 +729,7 @@ func OneloginAuth(apiRequest generic_structs.ApiRequest, authParams []string) ge
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		LogError("OneloginAuth", "[" + apiRequest.FullRequest.URL.String() + "]", fmt.Sprintf("Expected response status 200, got %d", resp.StatusCode))
+		LogError("OneloginAuth", "["+apiRequest.FullRequest.URL.String()+"]", fmt.Sprintf("Expected response status 200, got %d", resp.StatusCode))
 		return generic_structs.ApiRequest{}
 	}
 
@@ -652,13 +744,23 @@ func OneloginAuth(apiRequest generic_structs.ApiRequest, authParams []string) ge
 		LogError("OneloginAuth", "Failed to unmarshal token data JSON", err)
 		return generic_structs.ApiRequest{}
 	}
+	if len(tokenData.Data) == 0 {
+		LogError("OneloginAuth", "No access token returned")
+		return generic_structs.ApiRequest{}
+	}
 
-	return CustomHeaderAuth(apiRequest, []string{"Authorization", fmt.Sprintf("bearer %s", tokenData.Data[0].AccessToken)})
+	token := tokenData.Data[0]
+	setCachedToken(cacheKey, token.AccessToken, tokenTtl(apiRequest, token.ExpiresIn))
+	apiRequest.FullRequest.Header.Set("Authorization", fmt.Sprintf("bearer %s", token.AccessToken))
+
+	return apiRequest
 }
 
 // Auth function for session auth implementations.  Takes provided params and
 //    retrieves the session token from the designated key then updates the
-//    header of the ApiRequest.
+//    header of the ApiRequest.  Session tokens are cached until they near
+//    expiry - taken from an expires_in field next to the token, falling back
+//    to the auth_token_ttl setting.
 // Vars:
 // apiRequest = The ApiRequest to be used.
 // authParams = Session params in the order of:
@@ -674,6 +776,13 @@ func SessionTokenAuth(apiRequest generic_structs.ApiRequest, authParams []string
 		return generic_structs.ApiRequest{}
 	}
 
+	cacheKey := tokenCacheKey(append([]string{"SessionTokenAuth"}, authParams...)...)
+	apiRequest.AuthCacheKey = cacheKey
+	if token, ok := getCachedToken(cacheKey); ok {
+		apiRequest.FullRequest.Header.Set(authParams[1], authParams[2]+token)
+		return apiRequest
+	}
+
 	bodyMap := map[string]string{}
 
 	if len(authParams) > 4 {
@@ -689,7 +798,7 @@ func SessionTokenAuth(apiRequest generic_structs.ApiRequest, authParams []string
 	}
 
 	// TODO: Break this out to allow URL encoded session function as well
-	resp, err := http.Post(authParams[3], "application/json", bytes.NewBuffer(jsonString))
+	resp, err := httpClientFor(apiRequest).Post(authParams[3], "application/json", bytes.NewBuffer(jsonString))
 	if err != nil {
 		LogError("SessionTokenAuth", "Error running the session POST request", err)
 		return generic_structs.ApiRequest{}
@@ -698,7 +807,7 @@ func SessionTokenAuth(apiRequest generic_structs.ApiRequest, authParams []string
 
 	// TODO: Use a better technique instead of raising an error
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		LogError("SessionTokenAuth", "[" + apiRequest.FullRequest.URL.String() + "]", fmt.Sprintf("Expected response status 2xx, got %d", resp.StatusCode))
+		LogError("SessionTokenAuth", "["+apiRequest.FullRequest.URL.String()+"]", fmt.Sprintf("Expected response status 2xx, got %d", resp.StatusCode))
 		return generic_structs.ApiRequest{}
 	}
 
@@ -720,22 +829,30 @@ func SessionTokenAuth(apiRequest generic_structs.ApiRequest, authParams []string
 	}
 
 	var tokenValue string
+	var expiresIn interface{}
 	for _, v := range strings.Split(authParams[0], ".") {
-		if reflect.TypeOf(jsonResponseMap.(map[string]interface{})[v]).String() == "string" {
-			tokenValue = jsonResponseMap.(map[string]interface{})[v].(string)
+		responseMap, ok := jsonResponseMap.(map[string]interface{})
+		if !ok {
+			LogError("SessionTokenAuth", "Session token not found in response", authParams[0])
+			return generic_structs.ApiRequest{}
+		}
+		if token, ok := responseMap[v].(string); ok {
+			tokenValue = token
+			expiresIn = responseMap["expires_in"]
 		} else {
-			jsonResponseMap = jsonResponseMap.(map[string]interface{})[v]
+			jsonResponseMap = responseMap[v]
 		}
 	}
 
-	customParams := authParams[1:3]
-	customParams[1] = customParams[1] + tokenValue
+	setCachedToken(cacheKey, tokenValue, tokenTtl(apiRequest, expiresIn))
+	apiRequest.FullRequest.Header.Set(authParams[1], authParams[2]+tokenValue)
 
-	return CustomHeaderAuth(apiRequest, customParams)
+	return apiRequest
 }
 
 // Auth function for Oauth 2 2-legged implementations.  Takes Oauth params and
-//    preps the http client attached to the ApiRequest.
+//    wraps the http client attached to the ApiRequest.  The token is fetched
+//    once and reused across requests until it expires.
 // Vars:
 // apiRequest = The ApiRequest to be used.
 // authParams = Oauth2 params in the order of:
@@ -774,14 +891,14 @@ func Oauth2TwoLegAuth(apiRequest generic_structs.ApiRequest, authParams []string
 		AuthStyle:      authStyle,
 	}
 
-	ctx := context.Background()
-	apiRequest.Client = cfg.Client(ctx)
-
-	return apiRequest
+	return applyOauth2Client(apiRequest, "Oauth2TwoLegAuth", authParams, func(ctx context.Context) (oauth2.TokenSource, error) {
+		return cfg.TokenSource(ctx), nil
+	})
 }
 
-// Auth function for JWT implementations.  Takes JWT params and preps the http
-//    client attached to the ApiRequest.
+// Auth function for JWT implementations.  Takes JWT params and wraps the http
+//    client attached to the ApiRequest.  The token is fetched once and reused
+//    across requests until it expires.
 // Vars:
 // apiRequest = The ApiRequest to be used.
 // authParams = JWT params in the order of:
@@ -802,36 +919,9 @@ func JwtAuth(apiRequest generic_structs.ApiRequest, authParams []string) generic
 	//if cfg.TokenURL == "" {
 	//}
 
-	ctx := context.Background()
-	apiRequest.Client = cfg.Client(ctx)
-
-	return apiRequest
-}
-
-// Used at build-time for plugins to actually expand the variables in our new YAML files.
-// Vars:
-// rawYaml   = Raw YAML we are expanding.
-// varsData  = The vars data we are expanding.
-// indexes   = List of variables being expanded.
-// depth     = Count of recursion depth.
-// listIndex = Count of variable being expanded from the []string of vars.
-// varValues = Values being replaced.
-func populateSliceRecursion(rawYaml string, varsData map[string][]string, indexes []string, depth int, listIndex int, varValues map[string]string, returnSlice [][]byte) [][]byte {
-	if depth == len(indexes)-1 { // If we're at the end of the keys list.
-		varValues[indexes[depth]] = varsData[indexes[depth]][listIndex]
-		newYaml := rawYaml
-		for k, v := range varValues {
-			newYaml = strings.Replace(newYaml, "{{"+k+"}}", v, -1)
-			returnSlice = append(returnSlice, []byte(newYaml))
-		}
-	} else { // If we have more keys to go.
-		varValues[indexes[depth]] = varsData[indexes[depth]][listIndex]
-		for i, _ := range varsData[indexes[depth+1]] {
-			returnSlice = append(returnSlice, populateSliceRecursion(rawYaml, varsData, indexes, depth+1, i, varValues, returnSlice)...)
-		}
-	}
-
-	return returnSlice
+	return applyOauth2Client(apiRequest, "JwtAuth", authParams, func(ctx context.Context) (oauth2.TokenSource, error) {
+		return cfg.TokenSource(ctx), nil
+	})
 }
 
 // Recursively drill down into JSON to find the value of a specific key set
@@ -842,12 +932,18 @@ func populateSliceRecursion(rawYaml string, varsData map[string][]string, indexe
 // count        = Recursive depth count.
 // subStructure = Structure being plumbed.
 func ParseJsonSubStructure(kSet []string, count int, subStructure interface{}) []interface{} {
+	// Decoded JSON is walked as is, and anything else is converted to it.
+	subStructureListMap, ok := jsonMapList(subStructure)
+	if ok {
+		return parseJsonMapList(kSet, count, subStructureListMap)
+	}
+
 	// Start by marshaling our interface{} into a map which everything in JSON
 	//    should be if there are more subkeys.
 	var subStructureMap map[string]interface{}
 	// If it isn't a map, it's a list of maps, so we'll create one here to use
 	//    if necessary.
-	subStructureListMap := []map[string]interface{}{}
+	subStructureListMap = []map[string]interface{}{}
 
 	marshaledInterface, err := json.Marshal(subStructure)
 	if err != nil {
@@ -870,6 +966,40 @@ func ParseJsonSubStructure(kSet []string, count int, subStructure interface{}) [
 		subStructureListMap = append(subStructureListMap, subStructureMap)
 	}
 
+	return parseJsonMapList(kSet, count, subStructureListMap)
+}
+
+// Returns a decoded JSON object, or list of them, as a list of objects.  Not
+//    ok if it is anything else.
+func jsonMapList(subStructure interface{}) ([]map[string]interface{}, bool) {
+	switch typedStructure := subStructure.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{typedStructure}, true
+	case []map[string]interface{}:
+		return typedStructure, true
+	case []interface{}:
+		subStructureListMap := make([]map[string]interface{}, len(typedStructure))
+		for i, v := range typedStructure {
+			if v == nil {
+				continue
+			}
+			vMap, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			subStructureListMap[i] = vMap
+		}
+		return subStructureListMap, true
+	case string:
+		if typedStructure == "" {
+			return []map[string]interface{}{}, true
+		}
+	}
+
+	return nil, false
+}
+
+func parseJsonMapList(kSet []string, count int, subStructureListMap []map[string]interface{}) []interface{} {
 	finalInterfaceList := []interface{}{}
 	for _, v := range subStructureListMap {
 		if count == len(kSet)-1 {
@@ -899,60 +1029,14 @@ func ParseJsonSubStructure(kSet []string, count int, subStructure interface{}) [
 	return finalInterfaceList
 }
 
-// Adds a data item at the specified key set within the JSON structure.  (e.g.
-//    adding [ 4 ] to {"X": { "Y": { "Z": [ 1, 2, 3 ] } } } with key set "X.Y.Z"
-//    would return {"X": { "Y": { "Z": [ 1, 2, 3, 4 ] } } })
-// Vars:
-// kSet             = Key set being searched for.
-// count            = Recursive depth count.
-// currentStructure = Structure being added to.
-// newStructure     = Structure being added.
-// force            = Forces the addition of an empty structure if
-//                    currentStructure is empty or nil.
-func addJsonKeyStructure(kSet []string, count int, currentStructure map[string]interface{}, newStructure []interface{}, force bool) interface{} {
-	if !force && len(newStructure) == 0 {
-		return marshalToInterface(currentStructure)
-	}
-
-	if count == len(kSet)-1 {
-		if _, ok := currentStructure[kSet[count]]; !ok {
-			currentStructure[kSet[count]] = marshalToInterface(newStructure)
-		} else { // if key does exist in current substructure
-			if len(newStructure) > 0 {
-				currentStructure[kSet[count]] = marshalToInterface(append(
-					currentStructure[kSet[count]].([]interface{}), newStructure...))
-			}
-		}
-		return marshalToInterface(currentStructure)
-	} else {
-		if _, ok := currentStructure[kSet[count]]; !ok {
-			currentStructure[kSet[count]] = marshalToInterface(
-				addJsonKeyStructure(
-					kSet, count+1, make(map[string]interface{}), newStructure, force))
-		} else {
-			if len(newStructure) > 0 {
-				currentStructure[kSet[count]] = marshalToInterface(
-					addJsonKeyStructure(
-						kSet, count+1, currentStructure[kSet[count]].(map[string]interface{}), newStructure, force))
-				// TODO: Catch panics here if they try to put errors in with
-				//    different key depths.
-			}
-		}
-		return marshalToInterface(currentStructure)
-	}
-}
-
-// A simple Marshal/Unmarshal to force the structure into a JSON-friendly
-//    format.
-func marshalToInterface(data interface{}) interface{} {
-	jsonIntermediary, err := json.Marshal(data)
-	if err != nil {
-		LogError("marshalToInterface", "Unable to Marshal interface to JSON", err)
-		return interface{}(nil)
-	}
-	var typeParsedStructure interface{}
-	json.Unmarshal([]byte(jsonIntermediary), &typeParsedStructure)
-	return typeParsedStructure
+// Reports a merge conflict with the errors, as merge_conflicts.
+func addMergeConflict(parsedErrorStructure map[string]interface{}, conflict MergeConflict) {
+	conflicts, _ := parsedErrorStructure["merge_conflicts"].([]interface{})
+	parsedErrorStructure["merge_conflicts"] = append(conflicts, map[string]interface{}{
+		"api_call_name": conflict.ApiCallName,
+		"key":           conflict.Key,
+		"reason":        conflict.Reason,
+	})
 }
 
 // Finds where a specific int exists in an []int.
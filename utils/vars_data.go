@@ -0,0 +1,403 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+)
+
+// Expands an API root's vars_data into one config per combination of values,
+//    substituting each {{var}} in the parsed config.  Values placed in an
+//    endpoint URL are escaped for the part of the URL they're in (see
+//    EscapeUrlValue) unless written as {{var|raw}}.  Each expanded config's
+//    vars_data holds just its own values.  vars_from vars are expanded later
+//    by ExpandVarsFrom, so expansion settings naming them are left until then.
+// Vars:
+// api = The parsed API root.
+func ExpandVarsData(api generic_structs.ApiRoot) ([]generic_structs.ApiRoot, error) {
+	return expandVarsData(api, nil)
+}
+
+// Discovered values must be valid host labels where they're placed in the
+//    host of an endpoint URL.
+var varsFromHostRegex = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+func expandVarsData(api generic_structs.ApiRoot, discovered map[string]bool) ([]generic_structs.ApiRoot, error) {
+	settings := api.VarsExpansion
+	if len(api.VarsFrom) > 0 {
+		for k := range api.VarsFrom {
+			if _, ok := api.VarsData[k]; ok {
+				return nil, fmt.Errorf("var %q is in both vars_data and vars_from", k)
+			}
+		}
+		settings = varsDataSettingsWithout(settings, api.VarsFrom)
+	}
+	combinations, err := VarsDataCombinations(api.VarsData, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]*regexp.Regexp, len(api.VarsData))
+	for k := range api.VarsData {
+		tokens[k] = regexp.MustCompile(`{{\s*` + regexp.QuoteMeta(k) + `\s*(\|\s*raw\s*)?}}`)
+	}
+
+	expandedApis := make([]generic_structs.ApiRoot, 0, len(combinations))
+	for _, combination := range combinations {
+		expander := varsDataExpander{values: combination, tokens: tokens, discovered: discovered}
+		expanded := expander.value(reflect.ValueOf(api), false).Interface().(generic_structs.ApiRoot)
+		if expander.err != nil {
+			return nil, expander.err
+		}
+		expanded.VarsData = make(map[string][]string, len(combination))
+		for k, v := range combination {
+			expanded.VarsData[k] = []string{v}
+		}
+		expandedApis = append(expandedApis, expanded)
+	}
+
+	return expandedApis, nil
+}
+
+// Expands an API root (already expanded by ExpandVarsData) across the values
+//    discovered for its vars_from vars, as though they were in vars_data.
+//    As the values come from an API they must be data - values containing {
+//    or } are refused so they can't be read as templates, as are values in
+//    the host (or at the start) of an endpoint URL that aren't a single host
+//    label, so they can't send requests elsewhere.
+// Vars:
+// api        = The API root.
+// discovered = Values found for each vars_from var.
+func ExpandVarsFrom(api generic_structs.ApiRoot, discovered map[string][]string) ([]generic_structs.ApiRoot, error) {
+	varsData := make(map[string][]string, len(api.VarsData)+len(discovered))
+	for k, v := range api.VarsData {
+		varsData[k] = v
+	}
+	discoveredNames := make(map[string]bool, len(discovered))
+	for k, v := range discovered {
+		for _, value := range v {
+			if strings.ContainsAny(value, "{}") {
+				return nil, fmt.Errorf("vars_from %s value %q contains { or }, so could be read as a template", k, value)
+			}
+		}
+		varsData[k] = v
+		discoveredNames[k] = true
+	}
+	api.VarsData = varsData
+	api.VarsFrom = nil
+
+	return expandVarsData(api, discoveredNames)
+}
+
+// Returns the values at a dot separated key path in a JSON response, for a
+//    vars_from var.  Numbers and booleans are converted to strings, and
+//    duplicates are dropped.
+// Vars:
+// response = The JSON response.
+// key      = Dot separated path to the values.
+func VarsFromValues(response []byte, key string) ([]string, error) {
+	var structure interface{}
+	if err := json.Unmarshal(response, &structure); err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+	seen := make(map[string]bool)
+	for _, value := range ParseJsonSubStructure(strings.Split(key, "."), 0, structure) {
+		var stringValue string
+		switch typedValue := value.(type) {
+		case string:
+			stringValue = typedValue
+		case float64:
+			stringValue = strconv.FormatFloat(typedValue, 'f', -1, 64)
+		case bool:
+			stringValue = strconv.FormatBool(typedValue)
+		default:
+			continue
+		}
+		if !seen[stringValue] {
+			seen[stringValue] = true
+			values = append(values, stringValue)
+		}
+	}
+
+	return values, nil
+}
+
+type cachedVarsFrom struct {
+	Expires time.Time `json:"expires"`
+	Values  []string  `json:"values"`
+}
+
+// Returns the state store key a vars_from var's values are cached under.
+// Vars:
+// rootName = Name of the API root.
+// varName  = The vars_from var.
+// endpoint = The discovery endpoint, so changing it invalidates the cache.
+func VarsFromStateKey(rootName string, varName string, endpoint string) string {
+	return "vars_from:" + rootName + ":" + varName + ":" + endpoint
+}
+
+// Returns the values cached for a vars_from var, if they haven't expired.
+// Vars:
+// key = State store key from VarsFromStateKey.
+// now = The current time.
+func GetCachedVarsFrom(key string, now time.Time) ([]string, bool) {
+	state, ok := GetStateStore().GetState(key)
+	if !ok {
+		return nil, false
+	}
+	var cached cachedVarsFrom
+	if err := json.Unmarshal([]byte(state), &cached); err != nil || !now.Before(cached.Expires) {
+		return nil, false
+	}
+
+	return cached.Values, true
+}
+
+// Caches the values found for a vars_from var until the expiry given.
+// Vars:
+// key     = State store key from VarsFromStateKey.
+// values  = The values found.
+// expires = When the values should be looked up again.
+func SetCachedVarsFrom(key string, values []string, expires time.Time) error {
+	state, err := json.Marshal(cachedVarsFrom{Expires: expires, Values: values})
+	if err != nil {
+		return err
+	}
+
+	return GetStateStore().SetState(key, string(state))
+}
+
+// Returns the expansion settings without the zips and filters that name any
+//    of the vars given.
+func varsDataSettingsWithout(settings generic_structs.VarsDataSettings, vars map[string]generic_structs.VarsFrom) generic_structs.VarsDataSettings {
+	names := func(keys []string) bool {
+		for _, k := range keys {
+			if _, ok := vars[k]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	filterNames := func(filter map[string]string) bool {
+		keys := make([]string, 0, len(filter))
+		for k := range filter {
+			keys = append(keys, k)
+		}
+		return names(keys)
+	}
+
+	var remaining generic_structs.VarsDataSettings
+	for _, zip := range settings.Zip {
+		if !names(zip) {
+			remaining.Zip = append(remaining.Zip, zip)
+		}
+	}
+	for _, filter := range settings.Include {
+		if !filterNames(filter) {
+			remaining.Include = append(remaining.Include, filter)
+		}
+	}
+	for _, filter := range settings.Exclude {
+		if !filterNames(filter) {
+			remaining.Exclude = append(remaining.Exclude, filter)
+		}
+	}
+
+	return remaining
+}
+
+// Returns the combinations of vars_data values to expand, in a stable order.
+//    Lists are combined with every other list (a cartesian product) unless
+//    zipped together, in which case their values are stepped through in
+//    step.  The combinations are then filtered by the include/exclude
+//    settings.  An empty list leaves nothing to expand.
+// Vars:
+// varsData = The vars_data lists.
+// settings = How the lists are combined.
+func VarsDataCombinations(varsData map[string][]string, settings generic_structs.VarsDataSettings) ([]map[string]string, error) {
+	// Each group of keys varies as one - either a zipped group or a lone key.
+	var groups [][]string
+	zipped := make(map[string]bool)
+	for _, zip := range settings.Zip {
+		for _, k := range zip {
+			if _, ok := varsData[k]; !ok {
+				return nil, fmt.Errorf("zipped var %q isn't in vars_data", k)
+			}
+			if zipped[k] {
+				return nil, fmt.Errorf("var %q is zipped more than once", k)
+			}
+			if len(varsData[k]) != len(varsData[zip[0]]) {
+				return nil, fmt.Errorf("zipped vars %v must have the same number of values", zip)
+			}
+			zipped[k] = true
+		}
+		if len(zip) > 0 {
+			groups = append(groups, zip)
+		}
+	}
+	for k := range varsData {
+		if !zipped[k] {
+			groups = append(groups, []string{k})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+
+	for _, filter := range append(append([]map[string]string{}, settings.Include...), settings.Exclude...) {
+		for k := range filter {
+			if _, ok := varsData[k]; !ok {
+				return nil, fmt.Errorf("filtered var %q isn't in vars_data", k)
+			}
+		}
+	}
+
+	combinations := []map[string]string{{}}
+	for _, group := range groups {
+		if len(varsData[group[0]]) == 0 {
+			LogWarning("VarsDataCombinations", fmt.Sprintf("vars_data %v has no values - nothing to expand", group))
+			return []map[string]string{}, nil
+		}
+
+		var nextCombinations []map[string]string
+		for _, combination := range combinations {
+			for i := range varsData[group[0]] {
+				nextCombination := make(map[string]string, len(combination)+len(group))
+				for k, v := range combination {
+					nextCombination[k] = v
+				}
+				for _, k := range group {
+					nextCombination[k] = varsData[k][i]
+				}
+				nextCombinations = append(nextCombinations, nextCombination)
+			}
+		}
+		combinations = nextCombinations
+	}
+
+	filteredCombinations := []map[string]string{}
+	for _, combination := range combinations {
+		if len(settings.Include) > 0 && !matchesAnyVarsFilter(combination, settings.Include) {
+			continue
+		}
+		if matchesAnyVarsFilter(combination, settings.Exclude) {
+			continue
+		}
+		filteredCombinations = append(filteredCombinations, combination)
+	}
+
+	return filteredCombinations, nil
+}
+
+func matchesAnyVarsFilter(combination map[string]string, filters []map[string]string) bool {
+	for _, filter := range filters {
+		matches := true
+		for k, v := range filter {
+			if combination[k] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Substitutes one combination of vars data into a parsed config.
+type varsDataExpander struct {
+	values map[string]string
+	tokens map[string]*regexp.Regexp
+	// vars_from vars, whose values are checked before going in a URL's host.
+	discovered map[string]bool
+	// The first value refused.
+	err error
+}
+
+// Copies a parsed config value, substituting the vars data into its strings
+//    (including map keys).  isUrl marks the value of an endpoint field.
+func (e *varsDataExpander) value(value reflect.Value, isUrl bool) reflect.Value {
+	switch value.Kind() {
+	case reflect.String:
+		expanded := reflect.New(value.Type()).Elem()
+		expanded.SetString(e.string(value.String(), isUrl))
+		return expanded
+	case reflect.Struct:
+		expanded := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" {
+				// Structs with unexported fields aren't config, so are kept as is.
+				return value
+			}
+			yamlName := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			expanded.Field(i).Set(e.value(value.Field(i), yamlName == "endpoint"))
+		}
+		return expanded
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		expanded := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			expanded.Index(i).Set(e.value(value.Index(i), false))
+		}
+		return expanded
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		expanded := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			expanded.SetMapIndex(e.value(iter.Key(), false), e.value(iter.Value(), false))
+		}
+		return expanded
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		if value.Kind() == reflect.Ptr {
+			expanded := reflect.New(value.Type().Elem())
+			expanded.Elem().Set(e.value(value.Elem(), isUrl))
+			return expanded
+		}
+		expanded := reflect.New(value.Type()).Elem()
+		expanded.Set(e.value(value.Elem(), isUrl))
+		return expanded
+	}
+
+	return value
+}
+
+func (e *varsDataExpander) string(value string, isUrl bool) string {
+	for k, v := range e.values {
+		var expanded strings.Builder
+		last := 0
+		for _, loc := range e.tokens[k].FindAllStringSubmatchIndex(value, -1) {
+			expanded.WriteString(value[last:loc[0]])
+			if isUrl && e.discovered[k] && UrlContext(expanded.String()) == "host" && !varsFromHostRegex.MatchString(v) && e.err == nil {
+				e.err = fmt.Errorf("vars_from %s value %q isn't a host label, so can't be used in the host of %q", k, v, value)
+			}
+			if isUrl && loc[2] < 0 {
+				expanded.WriteString(EscapeUrlValue(expanded.String(), v))
+			} else {
+				expanded.WriteString(v)
+			}
+			last = loc[1]
+		}
+		expanded.WriteString(value[last:])
+		value = expanded.String()
+	}
+
+	return value
+}
@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	generic_structs "github.com/SREnity/epico/structs"
+	"gopkg.in/yaml.v2"
+)
+
+func TestVarsDataCombinations(t *testing.T) {
+	varsData := map[string][]string{
+		"region":  {"us-east-1", "eu-west-1"},
+		"account": {"111", "222", "333"},
+		"role":    {"r1", "r2", "r3"},
+	}
+
+	// Every combination, exactly once, in a stable order.
+	combinations, err := VarsDataCombinations(map[string][]string{"region": varsData["region"], "account": varsData["account"]}, generic_structs.VarsDataSettings{})
+	expected := []map[string]string{
+		{"account": "111", "region": "us-east-1"},
+		{"account": "111", "region": "eu-west-1"},
+		{"account": "222", "region": "us-east-1"},
+		{"account": "222", "region": "eu-west-1"},
+		{"account": "333", "region": "us-east-1"},
+		{"account": "333", "region": "eu-west-1"},
+	}
+	if err != nil || !reflect.DeepEqual(expected, combinations) {
+		t.Errorf("expect\n%v\ngot\n%v (%v)", expected, combinations, err)
+	}
+
+	// Zipped lists step together, then are combined with the rest.
+	combinations, err = VarsDataCombinations(varsData, generic_structs.VarsDataSettings{
+		Zip:     [][]string{{"account", "role"}},
+		Exclude: []map[string]string{{"account": "222", "region": "eu-west-1"}},
+	})
+	expected = []map[string]string{
+		{"account": "111", "role": "r1", "region": "us-east-1"},
+		{"account": "111", "role": "r1", "region": "eu-west-1"},
+		{"account": "222", "role": "r2", "region": "us-east-1"},
+		{"account": "333", "role": "r3", "region": "us-east-1"},
+		{"account": "333", "role": "r3", "region": "eu-west-1"},
+	}
+	if err != nil || !reflect.DeepEqual(expected, combinations) {
+		t.Errorf("expect\n%v\ngot\n%v (%v)", expected, combinations, err)
+	}
+
+	combinations, err = VarsDataCombinations(varsData, generic_structs.VarsDataSettings{
+		Zip:     [][]string{{"account", "role"}},
+		Include: []map[string]string{{"region": "eu-west-1", "account": "111"}, {"role": "r3"}},
+	})
+	if e, a := 3, len(combinations); err != nil || e != a {
+		t.Errorf("expect %v combinations, got %v (%v)", e, combinations, err)
+	}
+
+	// An empty list leaves nothing to expand rather than panicking.
+	combinations, err = VarsDataCombinations(map[string][]string{"region": {}, "account": {"111"}}, generic_structs.VarsDataSettings{})
+	if err != nil || len(combinations) != 0 {
+		t.Errorf("expect no combinations, got %v (%v)", combinations, err)
+	}
+
+	for _, settings := range []generic_structs.VarsDataSettings{
+		{Zip: [][]string{{"account", "region"}}},
+		{Zip: [][]string{{"account", "missing"}}},
+		{Zip: [][]string{{"account", "role"}, {"role"}}},
+		{Include: []map[string]string{{"missing": "x"}}},
+		{Exclude: []map[string]string{{"missing": "x"}}},
+	} {
+		if _, err := VarsDataCombinations(varsData, settings); err == nil {
+			t.Errorf("expect an error for %v", settings)
+		}
+	}
+}
+
+func TestExpandVarsData(t *testing.T) {
+	var api generic_structs.ApiRoot
+	err := yaml.Unmarshal([]byte(`
+name: "teams {{team}}"
+vars_data:
+  team: [ "a/b c", "ü#1" ]
+vars:
+  team: "{{team}}"
+endpoints:
+  - name: "team_{{team}}"
+    endpoint: "https://api.example.com/teams/{{team}}/members?team={{team}}&raw={{ team | raw }}"
+    params:
+      querystring:
+        team: [ "{{team}}" ]
+    graphql:
+      variables:
+        filter: { teams: [ "{{team}}" ] }
+    endpoints:
+      "members.{{team}}.id":
+        - name: member
+          endpoint: "https://api.example.com/{{team}}/{{endpoint_key}}"
+`), &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expandedApis, err := ExpandVarsData(api)
+	if err != nil || len(expandedApis) != 2 {
+		t.Fatalf("expect 2 configs, got %v (%v)", len(expandedApis), err)
+	}
+
+	expanded := expandedApis[0]
+	if e, a := "https://api.example.com/teams/a%2Fb%20c/members?team=a%2Fb+c&raw=a/b c", expanded.Endpoints[0].Endpoint; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	subEndpoints, ok := expanded.Endpoints[0].Endpoints["members.a/b c.id"]
+	if !ok || subEndpoints[0].Endpoint != "https://api.example.com/a%2Fb%20c/{{endpoint_key}}" {
+		t.Errorf("expect the sub-endpoint to be expanded, got %v", expanded.Endpoints[0].Endpoints)
+	}
+	// Everything else gets the value as is.
+	if expanded.Name != "teams a/b c" || expanded.Vars["team"] != "a/b c" || expanded.Endpoints[0].Name != "team_a/b c" || expanded.Endpoints[0].Params.QueryString["team"][0] != "a/b c" {
+		t.Errorf("expect unescaped values outside of the URL, got %+v", expanded)
+	}
+	variables := expanded.Endpoints[0].Graphql.Variables["filter"].(map[interface{}]interface{})
+	if e, a := "a/b c", variables["teams"].([]interface{})[0]; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	if e, a := "https://api.example.com/teams/%C3%BC%231/members?team=%C3%BC%231&raw=ü#1", expandedApis[1].Endpoints[0].Endpoint; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	// The parsed config is left alone.
+	if api.Name != "teams {{team}}" || api.Endpoints[0].Params.QueryString["team"][0] != "{{team}}" {
+		t.Errorf("expect the original config to be unchanged, got %+v", api)
+	}
+}
+
+func TestVarsFromValues(t *testing.T) {
+	response := []byte(`{"orgs": [{"login": "a"}, {"login": 2}, {"login": true}, {"login": "a"}, {"login": null}]}`)
+	if values, err := VarsFromValues(response, "orgs.login"); err != nil || !reflect.DeepEqual([]string{"a", "2", "true"}, values) {
+		t.Errorf("expect the distinct values, got %v (%v)", values, err)
+	}
+	if _, err := VarsFromValues([]byte(`not json`), "orgs"); err == nil {
+		t.Errorf("expect an error for a response that isn't JSON")
+	}
+}
+
+func TestCachedVarsFrom(t *testing.T) {
+	SetStateStore(NewMemoryStateStore())
+	defer SetStateStore(nil)
+
+	now := time.Now()
+	key := VarsFromStateKey("github", "org", "https://api.github.com/user/orgs")
+	if _, ok := GetCachedVarsFrom(key, now); ok {
+		t.Errorf("expect nothing cached yet")
+	}
+	if err := SetCachedVarsFrom(key, []string{"a", "b"}, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if values, ok := GetCachedVarsFrom(key, now); !ok || !reflect.DeepEqual([]string{"a", "b"}, values) {
+		t.Errorf("expect the cached values, got %v %v", values, ok)
+	}
+	if _, ok := GetCachedVarsFrom(key, now.Add(time.Hour)); ok {
+		t.Errorf("expect the cache to have expired")
+	}
+}
+
+func TestExpandVarsFrom(t *testing.T) {
+	var api generic_structs.ApiRoot
+	err := yaml.Unmarshal([]byte(`
+name: github
+vars_data:
+  region: [ "us", "eu" ]
+vars_from:
+  org:
+    endpoint: "https://api.example.com/{{region}}/orgs"
+    key: "login"
+vars_data_expansion:
+  exclude: [ { region: "eu", org: "b" } ]
+endpoints:
+  - name: "repos"
+    endpoint: "https://api.example.com/{{region}}/orgs/{{org}}/repos"
+`), &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// vars_data is expanded first, with the filter naming org left for later.
+	expandedApis, err := ExpandVarsData(api)
+	if err != nil || len(expandedApis) != 2 {
+		t.Fatalf("expect 2 configs, got %v (%v)", len(expandedApis), err)
+	}
+	if e, a := "https://api.example.com/eu/orgs", expandedApis[1].VarsFrom["org"].Endpoint; e != a {
+		t.Errorf("expect the vars_from endpoint to be expanded, got %v", a)
+	}
+
+	var endpoints []string
+	for _, expandedApi := range expandedApis {
+		discoveredApis, err := ExpandVarsFrom(expandedApi, map[string][]string{"org": {"a", "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, discoveredApi := range discoveredApis {
+			endpoints = append(endpoints, discoveredApi.Endpoints[0].Endpoint)
+			if len(discoveredApi.VarsFrom) != 0 {
+				t.Errorf("expect vars_from to be done with, got %v", discoveredApi.VarsFrom)
+			}
+		}
+	}
+	expected := []string{
+		"https://api.example.com/us/orgs/a/repos",
+		"https://api.example.com/us/orgs/b/repos",
+		"https://api.example.com/eu/orgs/a/repos",
+	}
+	if !reflect.DeepEqual(expected, endpoints) {
+		t.Errorf("expect\n%v\ngot\n%v", expected, endpoints)
+	}
+
+	// Discovered values are data, so can't hold templates or move a request
+	//    to another host.
+	expandedApis[0].Endpoints = append(expandedApis[0].Endpoints, generic_structs.ApiEndpoint{Name: "hooks", Endpoint: "https://{{org}}.example.com/hooks?org={{org}}"})
+	for _, value := range []string{`a{{env "HOME"}}`, "a}", "evil.com/", "evil.com?", "a@evil.com"} {
+		if _, err := ExpandVarsFrom(expandedApis[0], map[string][]string{"org": {"a", value}}); err == nil {
+			t.Errorf("expect an error for discovered value %q", value)
+		}
+	}
+	if discoveredApis, err := ExpandVarsFrom(expandedApis[0], map[string][]string{"org": {"a-1"}}); err != nil || discoveredApis[0].Endpoints[1].Endpoint != "https://a-1.example.com/hooks?org=a-1" {
+		t.Errorf("expect a host label to be allowed, got %v", err)
+	}
+
+	api.VarsData["org"] = []string{"c"}
+	if _, err := ExpandVarsData(api); err == nil {
+		t.Errorf("expect an error for a var in both vars_data and vars_from")
+	}
+}
+
+func TestPopulateYamlSlice(t *testing.T) {
+	rawYaml := `
+name: "teams"
+endpoints:
+  - name: "team_{{team}}"
+    endpoint: "https://api.example.com/teams/{{team}}"
+`
+	expandedYamls := PopulateYamlSlice(rawYaml, map[string][]string{"team": {"a/b", "c"}})
+	if e, a := 2, len(expandedYamls); e != a {
+		t.Fatalf("expect %v YAMLs, got %v", e, a)
+	}
+
+	endpoints := map[string]string{}
+	for _, expandedYaml := range expandedYamls {
+		var api generic_structs.ApiRoot
+		if err := yaml.Unmarshal(expandedYaml, &api); err != nil {
+			t.Fatal(err)
+		}
+		endpoints[api.Endpoints[0].Name] = api.Endpoints[0].Endpoint
+	}
+	expected := map[string]string{"team_a/b": "https://api.example.com/teams/a%2Fb", "team_c": "https://api.example.com/teams/c"}
+	if !reflect.DeepEqual(expected, endpoints) {
+		t.Errorf("expect %v, got %v", expected, endpoints)
+	}
+}